@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/viper"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/storage"
+)
+
+// alertmanagerClient sends requests to the v2 API of a single Alertmanager
+type alertmanagerClient struct {
+	baseURL *url.URL
+	client  *http.Client
+}
+
+// NewAlertmanagerDriver creates a Driver for the Alertmanager configured in the [maia.alertmanager] section.
+// Authentication and TLS are configured like for Prometheus, e.g. in [maia.alertmanager.basic_auth].
+func NewAlertmanagerDriver() Driver {
+	rawURL := viper.GetString("maia.alertmanager.url")
+	if rawURL == "" {
+		panic(errors.New("alertmanager endpoint not configured (maia.alertmanager.url)"))
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(rawURL, "/"))
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") {
+		panic(fmt.Errorf("invalid maia.alertmanager.url setting: %q", rawURL))
+	}
+	client, err := storage.NewHTTPClientFromConfig("maia.alertmanager")
+	if err != nil {
+		panic(fmt.Errorf("invalid [maia.alertmanager] settings: %w", err))
+	}
+	logg.Info("Using Alertmanager at: \"%s\"", baseURL)
+
+	return &alertmanagerClient{baseURL: baseURL, client: client}
+}
+
+func (c *alertmanagerClient) Alerts(ctx context.Context, params url.Values) (*http.Response, error) {
+	return c.send(ctx, http.MethodGet, "/api/v2/alerts", params, nil)
+}
+
+func (c *alertmanagerClient) AlertGroups(ctx context.Context, params url.Values) (*http.Response, error) {
+	return c.send(ctx, http.MethodGet, "/api/v2/alerts/groups", params, nil)
+}
+
+func (c *alertmanagerClient) Silences(ctx context.Context, params url.Values) (*http.Response, error) {
+	return c.send(ctx, http.MethodGet, "/api/v2/silences", params, nil)
+}
+
+func (c *alertmanagerClient) Silence(ctx context.Context, id string) (*http.Response, error) {
+	return c.send(ctx, http.MethodGet, "/api/v2/silence/"+id, nil, nil)
+}
+
+func (c *alertmanagerClient) PostSilence(ctx context.Context, silence []byte) (*http.Response, error) {
+	return c.send(ctx, http.MethodPost, "/api/v2/silences", nil, silence)
+}
+
+func (c *alertmanagerClient) ExpireSilence(ctx context.Context, id string) (*http.Response, error) {
+	return c.send(ctx, http.MethodDelete, "/api/v2/silence/"+id, nil, nil)
+}
+
+// send delivers a request to Alertmanager. The path is joined to the configured URL, so that requests cannot be
+// directed to another host.
+func (c *alertmanagerClient) send(ctx context.Context, method, path string, params url.Values, body []byte) (*http.Response, error) {
+	reqURL := c.baseURL.JoinPath(path)
+	reqURL.RawQuery = params.Encode()
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, reqURL.String(), bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", storage.JSON)
+	if body != nil {
+		req.Header.Set("Content-Type", storage.JSON)
+	}
+
+	logg.Debug("Forwarding request to Alertmanager: %s %s", method, reqURL.String())
+	resp, err := c.client.Do(req)
+	if err != nil {
+		logg.Error("Alertmanager request failed: %s", err.Error())
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package alertmanager
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Driver is an interface that wraps the v2 API of Alertmanager. Because it is an interface, the real
+// implementation can be mocked away in unit tests. Like storage.Driver, it passes on the HTTP responses
+// of Alertmanager unchanged.
+type Driver interface {
+	// Alerts lists the alerts; params are the filter parameters of the Alertmanager API (filter, active, silenced, ...)
+	Alerts(ctx context.Context, params url.Values) (*http.Response, error)
+	// AlertGroups lists the alerts grouped by the routing configuration of Alertmanager
+	AlertGroups(ctx context.Context, params url.Values) (*http.Response, error)
+	// Silences lists the silences
+	Silences(ctx context.Context, params url.Values) (*http.Response, error)
+	// Silence returns a single silence
+	Silence(ctx context.Context, id string) (*http.Response, error)
+	// PostSilence creates a silence, or updates it if the JSON-encoded silence has an ID
+	PostSilence(ctx context.Context, silence []byte) (*http.Response, error)
+	// ExpireSilence expires a silence
+	ExpireSilence(ctx context.Context, id string) (*http.Response, error)
+}
+
+// Matcher is a label matcher of a silence
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	// IsEqual is false for negative matchers (!= and !~); Alertmanager treats a missing value as true
+	IsEqual *bool `json:"isEqual,omitempty"`
+}
+
+// Positive checks whether the matcher selects alerts with a certain label value (= and =~)
+func (m Matcher) Positive() bool {
+	return m.IsEqual == nil || *m.IsEqual
+}
+
+// Silence is a silence as returned by the Alertmanager API. For creating or updating a silence, ID is optional
+// and Status and UpdatedAt are not set.
+type Silence struct {
+	ID        string         `json:"id,omitempty"`
+	Matchers  []Matcher      `json:"matchers"`
+	StartsAt  time.Time      `json:"startsAt"`
+	EndsAt    time.Time      `json:"endsAt"`
+	CreatedBy string         `json:"createdBy"`
+	Comment   string         `json:"comment"`
+	Status    *SilenceStatus `json:"status,omitempty"`
+	UpdatedAt *time.Time     `json:"updatedAt,omitempty"`
+}
+
+// SilenceStatus is the state of a silence (active, pending or expired)
+type SilenceStatus struct {
+	State string `json:"state"`
+}
@@ -0,0 +1,431 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/alertmanager"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/keystone"
+)
+
+// maxSilenceSize limits the size of silences posted by clients
+const maxSilenceSize = 64 * 1024
+
+// silenceIDPattern matches the UUIDs that Alertmanager assigns to silences
+var silenceIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// class for the Alertmanager v2 API proxy
+type alertmanagerProvider struct {
+	keystone     keystone.Driver
+	alertmanager alertmanager.Driver
+}
+
+// NewAlertmanagerHandler creates a http.Handler that serves a tenant-aware subset of the Alertmanager v2 API.
+// Alerts are restricted to the scope of the user like in the Prometheus API. Silences can only be managed when
+// they are restricted to projects within the scope by a project_id matcher. With several project labels configured,
+// silences use the first one.
+func NewAlertmanagerHandler(keystoneDriver keystone.Driver, alertmanagerDriver alertmanager.Driver) http.Handler {
+	r := mux.NewRouter()
+	p := &alertmanagerProvider{
+		keystone:     keystoneDriver,
+		alertmanager: alertmanagerDriver,
+	}
+
+	r.Methods(http.MethodGet).Path("/alerts").HandlerFunc(authorize(observeDuration(p.Alerts, "alertmanager_alerts"), false, "alert:list"))
+	r.Methods(http.MethodGet).Path("/alerts/groups").HandlerFunc(authorize(observeDuration(p.AlertGroups, "alertmanager_alert_groups"), false, "alert:list"))
+	r.Methods(http.MethodGet).Path("/silences").HandlerFunc(authorize(observeDuration(p.Silences, "alertmanager_silences"), false, "alert:list"))
+	r.Methods(http.MethodGet).Path("/silence/{id}").HandlerFunc(authorize(observeDuration(p.Silence, "alertmanager_silence"), false, "alert:list"))
+	// create or update a silence
+	r.Methods(http.MethodPost).Path("/silences").HandlerFunc(authorize(observeDuration(p.PostSilence, "alertmanager_post_silence"), false, "silence:create"))
+	r.Methods(http.MethodDelete).Path("/silence/{id}").HandlerFunc(authorize(observeDuration(p.ExpireSilence, "alertmanager_expire_silence"), false, "silence:expire"))
+
+	return r
+}
+
+// Alerts lists the alerts whose labels carry the project or domain of the request.
+func (p *alertmanagerProvider) Alerts(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		returnAlertmanagerError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+	scope := alertScope(req, ks)
+
+	var alerts []json.RawMessage
+	if !p.fetch(w, req, p.alertmanager.Alerts, &alerts) {
+		return
+	}
+	result, err := scope.filterRawAlerts(alerts)
+	if err != nil {
+		returnAlertmanagerError(w, err, http.StatusBadGateway)
+		return
+	}
+	ReturnJSON(w, http.StatusOK, result)
+}
+
+// AlertGroups lists the alert groups with just the alerts within the scope of the request. Groups without such
+// alerts are left out.
+func (p *alertmanagerProvider) AlertGroups(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		returnAlertmanagerError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+	scope := alertScope(req, ks)
+
+	var groups []map[string]json.RawMessage
+	if !p.fetch(w, req, p.alertmanager.AlertGroups, &groups) {
+		return
+	}
+	result := []map[string]json.RawMessage{}
+	for _, group := range groups {
+		var alerts []json.RawMessage
+		if err := json.Unmarshal(group["alerts"], &alerts); err != nil {
+			returnAlertmanagerError(w, fmt.Errorf("cannot process alert groups: %w", err), http.StatusBadGateway)
+			return
+		}
+		scoped, err := scope.filterRawAlerts(alerts)
+		if err != nil {
+			returnAlertmanagerError(w, err, http.StatusBadGateway)
+			return
+		}
+		if len(scoped) == 0 {
+			continue
+		}
+		if group["alerts"], err = json.Marshal(scoped); err != nil {
+			returnAlertmanagerError(w, err, http.StatusInternalServerError)
+			return
+		}
+		result = append(result, group)
+	}
+	ReturnJSON(w, http.StatusOK, result)
+}
+
+// Silences lists the silences that are restricted to projects within the scope of the request.
+func (p *alertmanagerProvider) Silences(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		returnAlertmanagerError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+	projects := alertScope(req, ks).silenceProjects()
+
+	var silences []alertmanager.Silence
+	if !p.fetch(w, req, p.alertmanager.Silences, &silences) {
+		return
+	}
+	result := []alertmanager.Silence{}
+	for _, s := range silences {
+		if silenceInScope(s, projects) {
+			result = append(result, s)
+		}
+	}
+	ReturnJSON(w, http.StatusOK, result)
+}
+
+// Silence returns a single silence if it is restricted to projects within the scope of the request.
+func (p *alertmanagerProvider) Silence(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		returnAlertmanagerError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+	projects := alertScope(req, ks).silenceProjects()
+
+	silence, ok := p.scopedSilence(w, req, mux.Vars(req)["id"], projects)
+	if !ok {
+		return
+	}
+	ReturnJSON(w, http.StatusOK, silence)
+}
+
+// PostSilence creates or updates a silence. The silence must have a project_id matcher that restricts it to projects
+// within the scope of the request. Without any project_id matcher, Maia adds one for the project (or the projects of
+// the domain) of the request.
+func (p *alertmanagerProvider) PostSilence(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		returnAlertmanagerError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+	projects := alertScope(req, ks).silenceProjects()
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxSilenceSize))
+	if err != nil {
+		returnAlertmanagerError(w, fmt.Errorf("cannot read silence: %w", err), http.StatusBadRequest)
+		return
+	}
+	var silence alertmanager.Silence
+	if err := json.Unmarshal(body, &silence); err != nil {
+		returnAlertmanagerError(w, fmt.Errorf("invalid silence: %w", err), http.StatusBadRequest)
+		return
+	}
+	// fields that are only returned by Alertmanager
+	silence.Status = nil
+	silence.UpdatedAt = nil
+
+	// an existing silence must not be taken over from another tenant
+	if silence.ID != "" {
+		if _, ok := p.scopedSilence(w, req, silence.ID, projects); !ok {
+			return
+		}
+	}
+
+	if !slices.ContainsFunc(silence.Matchers, func(m alertmanager.Matcher) bool { return m.Name == scopeLabels.project[0] }) {
+		matcher, err := scopeMatcher(req, projects)
+		if err != nil {
+			returnAlertmanagerError(w, err, http.StatusForbidden)
+			return
+		}
+		silence.Matchers = append(silence.Matchers, matcher)
+	}
+	if !silenceInScope(silence, projects) {
+		returnAlertmanagerError(w, fmt.Errorf("the %s matchers of the silence must be restricted to projects within your scope", scopeLabels.project[0]), http.StatusForbidden)
+		return
+	}
+
+	body, err = json.Marshal(silence)
+	if err != nil {
+		returnAlertmanagerError(w, err, http.StatusInternalServerError)
+		return
+	}
+	resp, err := p.alertmanager.PostSilence(req.Context(), body)
+	if err != nil {
+		returnAlertmanagerError(w, err, http.StatusBadGateway)
+		return
+	}
+	ReturnResponse(w, resp)
+}
+
+// ExpireSilence expires a silence if it is restricted to projects within the scope of the request.
+func (p *alertmanagerProvider) ExpireSilence(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		returnAlertmanagerError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+	projects := alertScope(req, ks).silenceProjects()
+
+	id := mux.Vars(req)["id"]
+	if _, ok := p.scopedSilence(w, req, id, projects); !ok {
+		return
+	}
+	resp, err := p.alertmanager.ExpireSilence(req.Context(), id)
+	if err != nil {
+		returnAlertmanagerError(w, err, http.StatusBadGateway)
+		return
+	}
+	ReturnResponse(w, resp)
+}
+
+// fetch calls a list operation of Alertmanager with the parameters of the request and decodes the result. Error
+// responses of Alertmanager are passed on. It returns false when a response has already been sent.
+func (p *alertmanagerProvider) fetch(w http.ResponseWriter, req *http.Request, list func(ctx context.Context, params url.Values) (*http.Response, error), result any) bool {
+	resp, err := list(req.Context(), req.URL.Query())
+	if err != nil {
+		returnAlertmanagerError(w, err, http.StatusBadGateway)
+		return false
+	}
+	if resp.StatusCode != http.StatusOK {
+		ReturnResponse(w, resp)
+		return false
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		returnAlertmanagerError(w, fmt.Errorf("cannot process Alertmanager response: %w", err), http.StatusBadGateway)
+		return false
+	}
+	return true
+}
+
+// scopedSilence fetches a silence and checks that it is restricted to projects within the scope. Silences of other
+// tenants are reported as not found. The ID must be a UUID, so that it cannot alter the path of the request to
+// Alertmanager. It returns false when an error response has already been sent.
+func (p *alertmanagerProvider) scopedSilence(w http.ResponseWriter, req *http.Request, id string, projects []string) (alertmanager.Silence, bool) {
+	var silence alertmanager.Silence
+	if !silenceIDPattern.MatchString(id) {
+		returnAlertmanagerError(w, fmt.Errorf("invalid silence ID %q", id), http.StatusBadRequest)
+		return silence, false
+	}
+	resp, err := p.alertmanager.Silence(req.Context(), id)
+	if err != nil {
+		returnAlertmanagerError(w, err, http.StatusBadGateway)
+		return silence, false
+	}
+	if resp.StatusCode != http.StatusOK {
+		ReturnResponse(w, resp)
+		return silence, false
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&silence); err != nil {
+		returnAlertmanagerError(w, fmt.Errorf("cannot process Alertmanager response: %w", err), http.StatusBadGateway)
+		return silence, false
+	}
+	if !silenceInScope(silence, projects) {
+		logg.Info("Denying access to silence %s outside of the scope of the user", id)
+		returnAlertmanagerError(w, errors.New("silence not found"), http.StatusNotFound)
+		return silence, false
+	}
+	return silence, true
+}
+
+// silenceProjects returns the projects for which silences can be managed within the scope. The global visibility
+// sentinel is left out, since a silence for it would mute alerts of all tenants.
+func (s labelScope) silenceProjects() []string {
+	var projects []string
+	for _, p := range s[model.LabelName(scopeLabels.project[0])] {
+		if p != sentinelValue {
+			projects = append(projects, p)
+		}
+	}
+	return projects
+}
+
+// filterRawAlerts returns the alerts within the scope, leaving the encoding of the alerts untouched
+func (s labelScope) filterRawAlerts(alerts []json.RawMessage) ([]json.RawMessage, error) {
+	result := []json.RawMessage{}
+	for _, a := range alerts {
+		var alert struct {
+			Labels model.LabelSet `json:"labels"`
+		}
+		if err := json.Unmarshal(a, &alert); err != nil {
+			return nil, fmt.Errorf("cannot process alert: %w", err)
+		}
+		if s.contains(alert.Labels) {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+
+// silenceInScope checks whether a silence only applies to alerts of the given projects, i.e. whether one of its
+// matchers selects a project_id (or an alternation of project IDs) from the list. Since all matchers of a silence
+// must match, further project_id matchers can only narrow it down.
+func silenceInScope(silence alertmanager.Silence, projects []string) bool {
+	for _, m := range silence.Matchers {
+		if m.Name != scopeLabels.project[0] || !m.Positive() {
+			continue
+		}
+		values := []string{m.Value}
+		if m.IsRegex {
+			var ok bool
+			values, ok = regexLiterals(m.Value)
+			if !ok {
+				continue
+			}
+		}
+		if !slices.ContainsFunc(values, func(v string) bool { return !slices.Contains(projects, v) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// regexLiterals returns the strings matched by a regex with a small, finite language, e.g. the alternations of
+// quoted project IDs created by scopeMatcher. It returns false for any regex that matches other strings.
+func regexLiterals(expr string) ([]string, bool) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+	return regexLanguage(re.Simplify())
+}
+
+// maxRegexLiterals bounds the number of strings enumerated by regexLanguage
+const maxRegexLiterals = 1000
+
+func regexLanguage(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(re.Rune)}, true
+	case syntax.OpCharClass:
+		var result []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if int(re.Rune[i+1]-re.Rune[i])+len(result) >= maxRegexLiterals {
+				return nil, false
+			}
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				result = append(result, string(r))
+			}
+		}
+		return result, true
+	case syntax.OpCapture:
+		return regexLanguage(re.Sub[0])
+	case syntax.OpAlternate:
+		var result []string
+		for _, sub := range re.Sub {
+			values, ok := regexLanguage(sub)
+			if !ok || len(result)+len(values) > maxRegexLiterals {
+				return nil, false
+			}
+			result = append(result, values...)
+		}
+		return result, true
+	case syntax.OpConcat:
+		result := []string{""}
+		for _, sub := range re.Sub {
+			values, ok := regexLanguage(sub)
+			if !ok || len(result)*len(values) > maxRegexLiterals {
+				return nil, false
+			}
+			var product []string
+			for _, prefix := range result {
+				for _, v := range values {
+					product = append(product, prefix+v)
+				}
+			}
+			result = product
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// scopeMatcher creates the project_id matcher for a silence without one: the project for project scope, or all
+// projects of the domain for domain scope
+func scopeMatcher(req *http.Request, projects []string) (alertmanager.Matcher, error) {
+	if projectID := req.Header.Get("X-Project-Id"); projectID != "" {
+		return alertmanager.Matcher{Name: scopeLabels.project[0], Value: projectID}, nil
+	}
+	if len(projects) == 0 {
+		return alertmanager.Matcher{}, errors.New("there are no projects within your scope to which the silence can be restricted")
+	}
+	quoted := make([]string, len(projects))
+	for i, p := range projects {
+		quoted[i] = regexp.QuoteMeta(p)
+	}
+	slices.Sort(quoted)
+	return alertmanager.Matcher{Name: scopeLabels.project[0], Value: strings.Join(quoted, "|"), IsRegex: true}, nil
+}
+
+// returnAlertmanagerError reports an error like Alertmanager does, as a JSON string
+func returnAlertmanagerError(w http.ResponseWriter, err error, code int) {
+	if code >= 500 {
+		logg.Error("Alertmanager request failed: %s", err.Error())
+	}
+	ReturnJSON(w, code, err.Error())
+}
@@ -0,0 +1,286 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	policy "github.com/databus23/goslo.policy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/alertmanager"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/storage"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/test"
+)
+
+var projectAuthHeaders = map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON}
+
+const (
+	ownSilenceID   = "6f1c2d3e-5a4b-4c3d-9e8f-000000000001"
+	otherSilenceID = "6f1c2d3e-5a4b-4c3d-9e8f-000000000002"
+)
+
+func setupAlertmanagerTest(t *testing.T, ctrl *gomock.Controller) (http.Handler, *alertmanager.MockDriver) {
+	return setupAlertmanagerTestWithContext(t, ctrl, projectContext)
+}
+
+// setupAlertmanagerTestWithContext expects a request with project scope that is authenticated with the given context
+func setupAlertmanagerTestWithContext(t *testing.T, ctrl *gomock.Controller, policyContext *policy.Context) (http.Handler, *alertmanager.MockDriver) {
+	_, keystoneMock, storageMock := setupTest(t, ctrl)
+	alertmanagerMock := alertmanager.NewMockDriver(ctrl)
+	alertmanagerInstance = alertmanagerMock
+	authCall := keystoneMock.EXPECT().AuthenticateRequest(test.MatchContext(), test.HTTPRequestMatcher{InjectHeader: projectHeader}, false).Return(policyContext, nil)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "12345").Return([]string{}, nil).After(authCall).AnyTimes()
+
+	// the router of setupTest has no Alertmanager proxy, so build another one with fresh metrics
+	prometheus.DefaultRegisterer = prometheus.NewPedanticRegistry()
+	return setupRouter(keystoneMock, nil, storageMock), alertmanagerMock
+}
+
+func TestAlertmanagerAlerts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, alertmanagerMock := setupAlertmanagerTest(t, ctrl)
+
+	alertmanagerMock.EXPECT().Alerts(test.MatchContext(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, params url.Values) (*http.Response, error) {
+			assert.Equal(t, []string{"false"}, params["silenced"])
+			return test.HTTPResponseFromFile("fixtures/alertmanager_alerts.json"), nil
+		})
+
+	test.APIRequest{
+		Headers:          projectAuthHeaders,
+		Method:           "GET",
+		Path:             "/alertmanager/api/v2/alerts?silenced=false",
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/alertmanager_alerts_scoped.json",
+	}.Check(t, router)
+}
+
+func TestAlertmanagerAlertGroups(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, alertmanagerMock := setupAlertmanagerTest(t, ctrl)
+
+	alertmanagerMock.EXPECT().AlertGroups(test.MatchContext(), gomock.Any()).Return(test.HTTPResponseFromFile("fixtures/alertmanager_alert_groups.json"), nil)
+
+	test.APIRequest{
+		Headers:          projectAuthHeaders,
+		Method:           "GET",
+		Path:             "/alertmanager/api/v2/alerts/groups",
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/alertmanager_alert_groups_scoped.json",
+	}.Check(t, router)
+}
+
+func TestAlertmanagerSilences(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, alertmanagerMock := setupAlertmanagerTest(t, ctrl)
+
+	// neither the silence for a foreign project nor the negative matcher are within the scope
+	alertmanagerMock.EXPECT().Silences(test.MatchContext(), gomock.Any()).Return(test.HTTPResponseFromFile("fixtures/alertmanager_silences.json"), nil)
+
+	test.APIRequest{
+		Headers:          projectAuthHeaders,
+		Method:           "GET",
+		Path:             "/alertmanager/api/v2/silences",
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/alertmanager_silences_scoped.json",
+	}.Check(t, router)
+}
+
+func TestAlertmanagerSilence_otherTenant(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, alertmanagerMock := setupAlertmanagerTest(t, ctrl)
+
+	alertmanagerMock.EXPECT().Silence(test.MatchContext(), otherSilenceID).Return(test.HTTPResponseFromFile("fixtures/alertmanager_silence_other.json"), nil)
+
+	expectedBody := `"silence not found"`
+	test.APIRequest{
+		Headers:          projectAuthHeaders,
+		Method:           "GET",
+		Path:             "/alertmanager/api/v2/silence/" + otherSilenceID,
+		ExpectStatusCode: http.StatusNotFound,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestAlertmanagerPostSilence(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, alertmanagerMock := setupAlertmanagerTestWithContext(t, ctrl, projectAdminContext)
+
+	// the project_id matcher is added for the project of the request
+	alertmanagerMock.EXPECT().PostSilence(test.MatchContext(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, body []byte) (*http.Response, error) {
+			var silence alertmanager.Silence
+			assert.NoError(t, json.Unmarshal(body, &silence))
+			assert.Equal(t, []alertmanager.Matcher{{Name: "alertname", Value: "InstanceDown"}, {Name: "project_id", Value: "12345"}}, silence.Matchers)
+			return test.HTTPResponseFromFile("fixtures/alertmanager_post_silence.json"), nil
+		})
+
+	test.APIRequest{
+		Headers: projectAuthHeaders,
+		Method:  "POST",
+		Path:    "/alertmanager/api/v2/silences",
+		RequestJSON: map[string]any{
+			"matchers":  []map[string]any{{"name": "alertname", "value": "InstanceDown", "isRegex": false}},
+			"startsAt":  "2017-07-01T20:00:00Z",
+			"endsAt":    "2017-07-02T20:00:00Z",
+			"createdBy": "testuser",
+			"comment":   "maintenance",
+		},
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/alertmanager_post_silence.json",
+	}.Check(t, router)
+}
+
+func TestAlertmanagerPostSilence_errorOutOfScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, _ := setupAlertmanagerTestWithContext(t, ctrl, projectAdminContext)
+
+	expectedBody := `"the project_id matchers of the silence must be restricted to projects within your scope"`
+	test.APIRequest{
+		Headers: projectAuthHeaders,
+		Method:  "POST",
+		Path:    "/alertmanager/api/v2/silences",
+		RequestJSON: map[string]any{
+			"matchers":  []map[string]any{{"name": "project_id", "value": "12345|67890", "isRegex": true}},
+			"startsAt":  "2017-07-01T20:00:00Z",
+			"endsAt":    "2017-07-02T20:00:00Z",
+			"createdBy": "testuser",
+			"comment":   "maintenance",
+		},
+		ExpectStatusCode: http.StatusForbidden,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestAlertmanagerPostSilence_errorInvalidID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, _ := setupAlertmanagerTestWithContext(t, ctrl, projectAdminContext)
+
+	// the ID must not reach the path of the upstream request
+	expectedBody := `"invalid silence ID \"../status\""`
+	test.APIRequest{
+		Headers: projectAuthHeaders,
+		Method:  "POST",
+		Path:    "/alertmanager/api/v2/silences",
+		RequestJSON: map[string]any{
+			"id":        "../status",
+			"matchers":  []map[string]any{{"name": "alertname", "value": "InstanceDown", "isRegex": false}},
+			"startsAt":  "2017-07-01T20:00:00Z",
+			"endsAt":    "2017-07-02T20:00:00Z",
+			"createdBy": "testuser",
+			"comment":   "maintenance",
+		},
+		ExpectStatusCode: http.StatusBadRequest,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestAlertmanagerPostSilence_failAuthorization(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, _ := setupAlertmanagerTest(t, ctrl)
+
+	// viewers may list silences but not create them
+	test.APIRequest{
+		Headers: projectAuthHeaders,
+		Method:  "POST",
+		Path:    "/alertmanager/api/v2/silences",
+		RequestJSON: map[string]any{
+			"matchers":  []map[string]any{{"name": "alertname", "value": "InstanceDown", "isRegex": false}},
+			"startsAt":  "2017-07-01T20:00:00Z",
+			"endsAt":    "2017-07-02T20:00:00Z",
+			"createdBy": "testuser",
+			"comment":   "maintenance",
+		},
+		ExpectStatusCode: http.StatusForbidden,
+	}.Check(t, router)
+}
+
+func TestAlertmanagerExpireSilence(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, alertmanagerMock := setupAlertmanagerTestWithContext(t, ctrl, projectAdminContext)
+
+	alertmanagerMock.EXPECT().Silence(test.MatchContext(), ownSilenceID).Return(test.HTTPResponseFromFile("fixtures/alertmanager_silence_own.json"), nil)
+	alertmanagerMock.EXPECT().ExpireSilence(test.MatchContext(), ownSilenceID).Return(&http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}}, nil)
+
+	test.APIRequest{
+		Headers:          projectAuthHeaders,
+		Method:           "DELETE",
+		Path:             "/alertmanager/api/v2/silence/" + ownSilenceID,
+		ExpectStatusCode: http.StatusOK,
+	}.Check(t, router)
+}
+
+func TestAlertmanagerExpireSilence_failAuthorization(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, _ := setupAlertmanagerTest(t, ctrl)
+
+	test.APIRequest{
+		Headers:          projectAuthHeaders,
+		Method:           "DELETE",
+		Path:             "/alertmanager/api/v2/silence/" + ownSilenceID,
+		ExpectStatusCode: http.StatusForbidden,
+	}.Check(t, router)
+}
+
+func TestAlertmanagerExpireSilence_errorInvalidID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, _ := setupAlertmanagerTestWithContext(t, ctrl, projectAdminContext)
+
+	test.APIRequest{
+		Headers:          projectAuthHeaders,
+		Method:           "DELETE",
+		Path:             "/alertmanager/api/v2/silence/own",
+		ExpectStatusCode: http.StatusBadRequest,
+	}.Check(t, router)
+}
+
+func TestAlertmanagerExpireSilence_otherTenant(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, alertmanagerMock := setupAlertmanagerTestWithContext(t, ctrl, projectAdminContext)
+
+	alertmanagerMock.EXPECT().Silence(test.MatchContext(), otherSilenceID).Return(test.HTTPResponseFromFile("fixtures/alertmanager_silence_other.json"), nil)
+
+	test.APIRequest{
+		Headers:          projectAuthHeaders,
+		Method:           "DELETE",
+		Path:             "/alertmanager/api/v2/silence/" + otherSilenceID,
+		ExpectStatusCode: http.StatusNotFound,
+	}.Check(t, router)
+}
+
+func TestSilenceInScope(t *testing.T) {
+	projects := []string{"12345", "67890", "a.b"}
+	no := false
+	cases := []struct {
+		matchers []alertmanager.Matcher
+		expected bool
+	}{
+		{[]alertmanager.Matcher{{Name: "project_id", Value: "12345"}}, true},
+		{[]alertmanager.Matcher{{Name: "project_id", Value: "12345|67890", IsRegex: true}}, true},
+		{[]alertmanager.Matcher{{Name: "project_id", Value: "12345|.*", IsRegex: true}}, false},
+		{[]alertmanager.Matcher{{Name: "project_id", Value: "(12345|67890)", IsRegex: true}}, true},
+		{[]alertmanager.Matcher{{Name: "project_id", Value: "1234.", IsRegex: true}}, false},
+		{[]alertmanager.Matcher{{Name: "project_id", Value: "1234[5-6]", IsRegex: true}}, false},
+		{[]alertmanager.Matcher{{Name: "project_id", Value: "(?i)a.b", IsRegex: true}}, false},
+		// escaped project IDs as written by scopeMatcher are in scope
+		{[]alertmanager.Matcher{{Name: "project_id", Value: `a\.b|12345`, IsRegex: true}}, true},
+		{[]alertmanager.Matcher{{Name: "project_id", Value: `a.b`, IsRegex: true}}, false},
+		{[]alertmanager.Matcher{{Name: "project_id", Value: "99999"}}, false},
+		{[]alertmanager.Matcher{{Name: "project_id", Value: "12345", IsEqual: &no}}, false},
+		{[]alertmanager.Matcher{{Name: "alertname", Value: "InstanceDown"}}, false},
+		// narrowing down an out-of-scope matcher is fine
+		{[]alertmanager.Matcher{{Name: "project_id", Value: ".*", IsRegex: true}, {Name: "project_id", Value: "67890"}}, true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expected, silenceInScope(alertmanager.Silence{Matchers: c.matchers}, projects), "%v", c.matchers)
+	}
+}
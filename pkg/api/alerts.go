@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/keystone"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/storage"
+)
+
+// Alerts lists the pending and firing alerts whose labels carry the project or domain of the request.
+func (p *v1Provider) Alerts(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		ReturnPromError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+	scope := alertScope(req, ks)
+
+	resp, err := p.storage.Alerts(req.Context())
+	if err != nil {
+		ReturnStorageError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		ReturnResponse(w, resp)
+		return
+	}
+	defer resp.Body.Close()
+
+	var alerts storage.AlertsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&alerts); err != nil {
+		ReturnPromError(w, fmt.Errorf("cannot process alerts response: %w", err), http.StatusBadGateway)
+		return
+	}
+
+	result := storage.AlertsResponse{Status: storage.StatusSuccess, Data: storage.AlertsResult{Alerts: scope.filterAlerts(alerts.Data.Alerts)},
+		Warnings: alerts.Warnings}
+	ReturnJSON(w, 200, &result)
+}
+
+// Rules lists the alerting rules with at least one pending or firing alert within the scope of the request, along
+// with just these alerts. Recording rules and rules without alerts of the tenant are left out, since their
+// definitions are shared by all tenants. Asking for recording rules explicitly with type=record is rejected, rather
+// than answered with an empty list.
+func (p *v1Provider) Rules(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		ReturnPromError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+	scope := alertScope(req, ks)
+
+	queryParams, err := requestParams(req)
+	if err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
+	ruleType := queryParams.Get("type")
+	switch ruleType {
+	case "", "alert":
+	case "record":
+		ReturnPromError(w, errors.New("invalid parameter 'type': recording rules are not available, since they are not scoped to tenants"), http.StatusBadRequest)
+		return
+	default:
+		ReturnPromError(w, fmt.Errorf("invalid parameter 'type': %q is neither alert nor record", ruleType), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := p.storage.Rules(req.Context(), ruleType, queryParams["rule_name[]"], queryParams["rule_group[]"])
+	if err != nil {
+		ReturnStorageError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		ReturnResponse(w, resp)
+		return
+	}
+	defer resp.Body.Close()
+
+	var rules storage.RulesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil {
+		ReturnPromError(w, fmt.Errorf("cannot process rules response: %w", err), http.StatusBadGateway)
+		return
+	}
+
+	result := storage.RulesResponse{Status: storage.StatusSuccess, Data: storage.RulesResult{Groups: []storage.RuleGroup{}}, Warnings: rules.Warnings}
+	for _, group := range rules.Data.Groups {
+		scopedRules := []storage.Rule{}
+		for _, rule := range group.Rules {
+			if rule.Type != "alerting" {
+				continue
+			}
+			rule.Alerts = scope.filterAlerts(rule.Alerts)
+			if len(rule.Alerts) == 0 {
+				continue
+			}
+			// the state of the rule summarizes the remaining alerts
+			rule.State = "pending"
+			if slices.ContainsFunc(rule.Alerts, func(a storage.Alert) bool { return a.State == "firing" }) {
+				rule.State = "firing"
+			}
+			scopedRules = append(scopedRules, rule)
+		}
+		if len(scopedRules) > 0 {
+			group.Rules = scopedRules
+			result.Data.Groups = append(result.Data.Groups, group)
+		}
+	}
+
+	ReturnJSON(w, 200, &result)
+}
+
+// labelScope maps label names to the values that make an alert visible in the scope of a request
+type labelScope map[model.LabelName][]string
+
+// alertScope determines which alerts are visible in the scope of the request: for project scope, alerts of the project
+// and its children; for domain scope, alerts of the domain and of all projects within it. The global visibility
+// sentinel counts for both labels.
+func alertScope(req *http.Request, keystoneDriver keystone.Driver) labelScope {
+	scope := labelScope{}
+	for _, c := range scopeToLabelConstraint(req, keystoneDriver) {
+		scope[model.LabelName(c.Key)] = c.Values
+	}
+	// with maia.domain_scope_projects, the constraints contain the projects of a domain already
+	if req.Header.Get("X-Project-Id") == "" && !domainScopeProjects {
+		// the top-level projects of a domain are its children in the project hierarchy
+		domainID := req.Header.Get("X-Domain-Id")
+		children, err := keystoneDriver.ChildProjects(req.Context(), domainID)
+		if err != nil {
+			panic(err)
+		}
+		for _, name := range scopeLabels.project {
+			scope[model.LabelName(name)] = appendSentinelValue(children)
+		}
+	}
+	return scope
+}
+
+// contains checks whether any of the labels carries one of the values of the scope
+func (s labelScope) contains(labels model.LabelSet) bool {
+	for name, values := range s {
+		if value, ok := labels[name]; ok && slices.Contains(values, string(value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterAlerts returns the alerts within the scope
+func (s labelScope) filterAlerts(alerts []storage.Alert) []storage.Alert {
+	result := []storage.Alert{}
+	for _, a := range alerts {
+		if s.contains(a.Labels) {
+			result = append(result, a)
+		}
+	}
+	return result
+}
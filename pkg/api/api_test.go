@@ -4,16 +4,30 @@
 package api
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/base64"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"errors"
 
 	policy "github.com/databus23/goslo.policy"
+	"github.com/golang/snappy"
 	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -21,6 +35,7 @@ import (
 	"github.com/SAP-cloud-infrastructure/maia/pkg/keystone"
 	"github.com/SAP-cloud-infrastructure/maia/pkg/storage"
 	"github.com/SAP-cloud-infrastructure/maia/pkg/test"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/util"
 )
 
 var projectContext = &policy.Context{Request: map[string]string{"project_id": "12345", "domain_id": "77777", "user_id": "u12345"},
@@ -33,6 +48,7 @@ var projectInsufficientRolesContext = &policy.Context{Request: map[string]string
 		"project_domain_name": "testdomain", "project_domain_id": "77777",
 		"user_id": "u12345", "user_name": "testuser", "user_domain_name": "testdomain", "user_domain_id": "77777"},
 	Roles: []string{"member"}}
+var projectAdminContext = &policy.Context{Request: projectContext.Request, Auth: projectContext.Auth, Roles: []string{"monitoring_admin"}}
 var projectHeader = map[string]string{"X-User-Id": projectContext.Auth["user_id"], "X-User-Name": projectContext.Auth["user_name"],
 	"X-User-Domain-Name": projectContext.Auth["user_domain_name"],
 	"X-Project-Id":       projectContext.Auth["project_id"], "X-Project-Name": projectContext.Auth["project_name"]}
@@ -40,6 +56,7 @@ var domainContext = &policy.Context{Request: map[string]string{"project_id": "12
 	Auth: map[string]string{"domain_id": "77777", "domain_name": "testdomain",
 		"user_id": "u12345", "user_name": "testuser", "user_domain_name": "testdomain", "user_domain_id": "77777"},
 	Roles: []string{"monitoring_viewer"}}
+var domainAdminContext = &policy.Context{Request: domainContext.Request, Auth: domainContext.Auth, Roles: []string{"monitoring_admin"}}
 var domainHeader = map[string]string{"X-User-Id": domainContext.Auth["user_id"], "X-User-Name": domainContext.Auth["user_name"],
 	"X-User-Domain-Name": domainContext.Auth["user_domain_name"],
 	"X-Domain-Id":        domainContext.Auth["domain_id"], "X-Domain-Name": domainContext.Auth["domain_name"]}
@@ -48,7 +65,15 @@ func setupTest(t *testing.T, controller *gomock.Controller) (router http.Handler
 	// load test policy (where everything is allowed)
 	viper.Set("keystone.policy_file", "../test/policy.json")
 	viper.Set("maia.label_value_ttl", "72h")
+	viper.Set("maia.label_values_mode", "")
 	sentinelValue = "" // reset sentinel for each test
+	queryLimits = util.TenantQueryLimits{}
+	seriesBudget = nil
+	scopeVerification = ""
+	scopeLabels = defaultScopeLabels
+	domainScopeProjects = false
+	alertmanagerInstance = nil
+	remoteWrite = nil
 
 	// create test driver with the domains and projects from start-data.sql
 	keystoneDriver = keystone.NewMockDriver(controller)
@@ -108,7 +133,7 @@ func TestFederate(t *testing.T) {
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
 
 	expectAuthByDomainName(keystoneMock)
-	storageMock.EXPECT().Federate([]string{"{vmware_name=\"win_cifs_13\",domain_id=\"77777\"}"}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{"{vmware_name=\"win_cifs_13\",domain_id=\"77777\"}"}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
 
 	test.APIRequest{
 		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.PlainText},
@@ -119,6 +144,24 @@ func TestFederate(t *testing.T) {
 	}.Check(t, router)
 }
 
+func TestFederate_post(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByDomainName(keystoneMock)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{"{vmware_name=\"win_cifs_13\",domain_id=\"77777\"}"}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.PlainText},
+		Method:           "POST",
+		Path:             "/federate",
+		RequestForm:      url.Values{"match[]": {`{vmware_name="win_cifs_13"}`}},
+		ExpectStatusCode: http.StatusOK,
+		ExpectFile:       "fixtures/federate.txt",
+	}.Check(t, router)
+}
+
 func TestFederate_withSentinel(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -126,7 +169,7 @@ func TestFederate_withSentinel(t *testing.T) {
 	sentinelValue = "all"
 
 	expectAuthByDomainName(keystoneMock)
-	storageMock.EXPECT().Federate([]string{`{vmware_name="win_cifs_13",domain_id=~"77777|all"}`}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{`{vmware_name="win_cifs_13",domain_id=~"77777|all"}`}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
 
 	test.APIRequest{
 		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.PlainText},
@@ -173,7 +216,7 @@ func TestFederate_errorBackendFailed(t *testing.T) {
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
 
 	expectAuthByDomainName(keystoneMock)
-	storageMock.EXPECT().Federate([]string{"{vmware_name=\"win_cifs_13\",domain_id=\"77777\"}"}, storage.PlainText).Return(nil, errors.New("testerror"))
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{"{vmware_name=\"win_cifs_13\",domain_id=\"77777\"}"}, storage.PlainText).Return(nil, errors.New("testerror"))
 
 	test.APIRequest{
 		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.PlainText},
@@ -183,13 +226,140 @@ func TestFederate_errorBackendFailed(t *testing.T) {
 	}.Check(t, router)
 }
 
+func TestFederate_compressed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByDomainName(keystoneMock)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{"{vmware_name=\"win_cifs_13\",domain_id=\"77777\"}"}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/federate?match[]={vmware_name=%22win_cifs_13%22}", http.NoBody)
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")))
+	req.Header.Set("Accept", storage.PlainText)
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	resp := recorder.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	reader, err := gzip.NewReader(resp.Body)
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	expected, err := os.ReadFile("fixtures/federate.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), string(body))
+}
+
+func TestFederate_openMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	// Prometheus answers with the text format, which Maia converts
+	expectAuthByDomainName(keystoneMock)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{"{vmware_name=\"win_cifs_13\",domain_id=\"77777\"}"}, storage.OpenMetrics+";q=0.8,"+storage.P8SProtoBuf).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/federate?match[]={vmware_name=%22win_cifs_13%22}", http.NoBody)
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")))
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), storage.OpenMetrics), recorder.Header().Get("Content-Type"))
+	assert.True(t, strings.HasSuffix(recorder.Body.String(), "# EOF\n"), recorder.Body.String())
+}
+
+func TestFederate_errorContentType(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	// a JSON response is not what the client asked for
+	expectAuthByDomainName(keystoneMock)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{"{vmware_name=\"win_cifs_13\",domain_id=\"77777\"}"}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.PlainText},
+		Method:           "GET",
+		Path:             "/federate?match[]={vmware_name=%22win_cifs_13%22}",
+		ExpectStatusCode: http.StatusBadGateway,
+	}.Check(t, router)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                       "",
+		"deflate":                "",
+		"gzip, deflate, br":      "gzip",
+		"gzip;q=0.5, zstd;q=0.8": "zstd",
+		"zstd;q=0, gzip":         "gzip",
+		"*":                      "gzip",
+		"identity, gzip;q=0":     "",
+		" ZSTD , gzip;q=1.0":     "zstd",
+	}
+	for acceptEncoding, expected := range cases {
+		assert.Equal(t, expected, negotiateEncoding(acceptEncoding), "Accept-Encoding: %q", acceptEncoding)
+	}
+}
+
+func TestReturnResponse(t *testing.T) {
+	response := test.HTTPResponseFromFile("fixtures/query.json")
+	response.Header.Add("Link", "<http://prometheus.local/a>")
+	response.Header.Add("Link", "<http://prometheus.local/b>")
+	response.Header.Set("Transfer-Encoding", "chunked")
+
+	recorder := httptest.NewRecorder()
+	ReturnResponse(recorder, response)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []string{"<http://prometheus.local/a>", "<http://prometheus.local/b>"}, recorder.Header().Values("Link"))
+	assert.Empty(t, recorder.Header().Get("Transfer-Encoding"))
+	expected, err := os.ReadFile("fixtures/query.json")
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), recorder.Body.String())
+}
+
+func TestReturnResponse_sizeLimit(t *testing.T) {
+	viper.Set("maia.max_response_size", "100b")
+	t.Cleanup(func() { viper.Set("maia.max_response_size", nil) })
+
+	// the size is known in advance: clean error
+	response := test.HTTPResponseFromFile("fixtures/query.json")
+	response.ContentLength = 1000
+	recorder := httptest.NewRecorder()
+	ReturnResponse(recorder, response)
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `"errorType":"execution"`)
+
+	// the size is unknown: the response is aborted
+	response = test.HTTPResponseFromFile("fixtures/query.json")
+	response.ContentLength = -1
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		ReturnResponse(httptest.NewRecorder(), response)
+	})
+
+	// small responses are not affected
+	response = &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(`{"status":"success"}`)), ContentLength: -1}
+	recorder = httptest.NewRecorder()
+	ReturnResponse(recorder, response)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `{"status":"success"}`, recorder.Body.String())
+}
+
 func TestSeries(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
 
 	expectAuthWithChildren(keystoneMock)
-	storageMock.EXPECT().Series([]string{"{component!=\"\",project_id=~\"12345|67890\"}"}, "2017-07-01T20:10:30.781Z", "2017-07-02T04:00:00.000Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
+	storageMock.EXPECT().Series(test.MatchContext(), []string{"{component!=\"\",project_id=~\"12345|67890\"}"}, "2017-07-01T20:10:30.781Z", "2017-07-02T04:00:00.000Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
 
 	test.APIRequest{
 		Headers:          map[string]string{"X-Auth-Token": "someverylongtokenideed", "Accept": storage.JSON},
@@ -207,7 +377,7 @@ func TestSeries_withSentinel(t *testing.T) {
 	sentinelValue = "all"
 
 	expectAuthWithChildren(keystoneMock)
-	storageMock.EXPECT().Series([]string{`{component!="",project_id=~"12345|67890|all"}`}, "2017-07-01T20:10:30.781Z", "2017-07-02T04:00:00.000Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
+	storageMock.EXPECT().Series(test.MatchContext(), []string{`{component!="",project_id=~"12345|67890|all"}`}, "2017-07-01T20:10:30.781Z", "2017-07-02T04:00:00.000Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
 
 	test.APIRequest{
 		Headers:          map[string]string{"X-Auth-Token": "someverylongtokenideed", "Accept": storage.JSON},
@@ -253,6 +423,7 @@ func TestLabels(t *testing.T) {
 
 	expectAuthWithChildren(keystoneMock)
 	storageMock.EXPECT().Labels(
+		test.MatchContext(),
 		"2017-07-01T20:10:30.781Z",
 		"2017-07-02T04:00:00.000Z",
 		[]string{"{component!=\"\",project_id=~\"12345|67890\"}"},
@@ -276,6 +447,7 @@ func TestLabels_withSentinel(t *testing.T) {
 
 	expectAuthWithChildren(keystoneMock)
 	storageMock.EXPECT().Labels(
+		test.MatchContext(),
 		"2017-07-01T20:10:30.781Z",
 		"2017-07-02T04:00:00.000Z",
 		[]string{`{component!="",project_id=~"12345|67890|all"}`},
@@ -298,6 +470,7 @@ func TestLabels_domainScope(t *testing.T) {
 
 	expectAuthByDomainName(keystoneMock)
 	storageMock.EXPECT().Labels(
+		test.MatchContext(),
 		"2017-07-01T20:10:30.781Z",
 		"2017-07-02T04:00:00.000Z",
 		[]string{"{component!=\"\",domain_id=\"77777\"}"},
@@ -350,6 +523,7 @@ func TestLabels_errorBackendFailed(t *testing.T) {
 
 	expectAuthWithChildren(keystoneMock)
 	storageMock.EXPECT().Labels(
+		test.MatchContext(),
 		"2017-07-01T20:10:30.781Z",
 		"2017-07-02T04:00:00.000Z",
 		[]string{"{component!=\"\",project_id=~\"12345|67890\"}"},
@@ -398,9 +572,115 @@ func TestLabelValues(t *testing.T) {
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
 
 	expectAuthByProjectID(keystoneMock)
-	// Maia's label-values implementation uses the series API and a time-based filter stale series out. The exact start
-	// and end date of the filter cannot be predicted, therefore we accept anything that is a parsable date.
-	storageMock.EXPECT().QueryRange("count by (service) ({project_id=\"12345\",service!=\"\"})", test.TimeStringMatcher{}, test.TimeStringMatcher{}, viper.Get("maia.label_value_ttl"), "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_query_range.json"), nil)
+	// without start parameter, series older than maia.label_value_ttl are filtered out. The exact start and end date
+	// of the filter cannot be predicted, therefore we accept anything that is a parsable date.
+	expectMatchProbe(storageMock, "fixtures/label_values_empty.json")
+	storageMock.EXPECT().LabelValues(test.MatchContext(), "service", []string{`{service!="",project_id="12345"}`}, test.TimeStringMatcher{}, test.TimeStringMatcher{}, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/label/service/values",
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/label_values.json",
+	}.Check(t, router)
+}
+
+func TestLabelValues_matchAndLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+	expectMatchProbe(storageMock, "fixtures/label_values_empty.json")
+	storageMock.EXPECT().LabelValues(test.MatchContext(), "service", []string{`{region="eu",__name__="openstack_api_requests",project_id="12345"}`, `{job="api",project_id="12345"}`},
+		"2017-07-01T20:10:30Z", "2017-07-03T20:10:00Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values.json"), nil)
+
+	expectedBody := `{"status":"success","data":["barbican","dns","glance"]}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/label/service/values?match[]=" + url.QueryEscape(`openstack_api_requests{region="eu"}`) + "&match[]=" + url.QueryEscape(`{job="api"}`) + "&start=2017-07-01T20:10:30Z&end=2017-07-03T20:10:00Z&limit=3",
+		ExpectStatusCode: http.StatusOK,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestLabelValues_errorInvalidSelector(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/label/service/values?match[]=" + url.QueryEscape("sum(up)"),
+		ExpectStatusCode: http.StatusBadRequest,
+	}.Check(t, router)
+}
+
+// expectMatchProbe expects the check whether the backend honours match[] in the label values API, where the probe
+// selector yields the given fixture
+func expectMatchProbe(storageMock *storage.MockDriver, probeFixture string) {
+	storageMock.EXPECT().LabelValues(test.MatchContext(), "__name__", nil, test.TimeStringMatcher{}, test.TimeStringMatcher{}, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_names.json"), nil)
+	storageMock.EXPECT().LabelValues(test.MatchContext(), "__name__", []string{labelValuesProbeSelector}, test.TimeStringMatcher{}, test.TimeStringMatcher{}, storage.JSON).Return(test.HTTPResponseFromFile(probeFixture), nil)
+}
+
+func TestLabelValues_matchUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	// the backend lists metric names for a selector that matches nothing, so the values are determined by a query
+	expectAuthByProjectID(keystoneMock)
+	expectAuthByProjectID(keystoneMock)
+	expectMatchProbe(storageMock, "fixtures/label_values_names.json")
+	storageMock.EXPECT().QueryRange(test.MatchContext(), `count by (service) ({service!="",project_id="12345"})`, test.TimeStringMatcher{}, test.TimeStringMatcher{}, "259200", storage.JSON).DoAndReturn(
+		func(_ context.Context, _, _, _, _, _ string) (*http.Response, error) {
+			return test.HTTPResponseFromFile("fixtures/label_values_query_range.json"), nil
+		}).Times(2)
+
+	for range 2 {
+		// the outcome of the check is remembered
+		test.APIRequest{
+			Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+			Method:           "GET",
+			Path:             "/api/v1/label/service/values",
+			ExpectStatusCode: http.StatusOK,
+			ExpectJSON:       "fixtures/label_values.json",
+		}.Check(t, router)
+	}
+}
+
+func TestLabelValues_matchProbeFailed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	// when the check fails, label values are determined by a query
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().LabelValues(test.MatchContext(), "__name__", nil, test.TimeStringMatcher{}, test.TimeStringMatcher{}, storage.JSON).Return(nil, errors.New("connection refused"))
+	storageMock.EXPECT().QueryRange(test.MatchContext(), `count by (service) ({service!="",project_id="12345"})`, test.TimeStringMatcher{}, test.TimeStringMatcher{}, "259200", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_query_range.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/label/service/values",
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/label_values.json",
+	}.Check(t, router)
+}
+
+func TestLabelValues_queryFallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	viper.Set("maia.label_values_mode", "query")
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().QueryRange(test.MatchContext(), `count by (service) ({service!="",project_id="12345"})`, test.TimeStringMatcher{}, test.TimeStringMatcher{}, "259200", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_query_range.json"), nil)
 
 	test.APIRequest{
 		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
@@ -416,9 +696,10 @@ func TestLabelValues_sentinelNames(t *testing.T) {
 
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
 	sentinelValue = "all"
+	viper.Set("maia.label_values_mode", "query")
 
 	expectAuthByProjectID(keystoneMock)
-	storageMock.EXPECT().QueryRange(`count by (__name__) ({__name__!="",project_id=~"12345|all"})`, test.TimeStringMatcher{}, test.TimeStringMatcher{}, viper.Get("maia.label_value_ttl"), "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_sentinel_names_query_range.json"), nil)
+	storageMock.EXPECT().QueryRange(test.MatchContext(), `count by (__name__) ({__name__!="",project_id=~"12345|all"})`, test.TimeStringMatcher{}, test.TimeStringMatcher{}, "259200", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_sentinel_names_query_range.json"), nil)
 
 	expectedBody := `{"status":"success","data":["kube_node_info","tenant_metric"]}`
 	test.APIRequest{
@@ -435,9 +716,10 @@ func TestLabelValues_sentinelLabel(t *testing.T) {
 
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
 	sentinelValue = "all"
+	viper.Set("maia.label_values_mode", "query")
 
 	expectAuthByProjectID(keystoneMock)
-	storageMock.EXPECT().QueryRange(`count by (node) ({node!="",project_id=~"12345|all"})`, test.TimeStringMatcher{}, test.TimeStringMatcher{}, viper.Get("maia.label_value_ttl"), "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_sentinel_node_query_range.json"), nil)
+	storageMock.EXPECT().QueryRange(test.MatchContext(), `count by (node) ({node!="",project_id=~"12345|all"})`, test.TimeStringMatcher{}, test.TimeStringMatcher{}, "259200", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_sentinel_node_query_range.json"), nil)
 
 	expectedBody := `{"status":"success","data":["worker-1","worker-2"]}`
 	test.APIRequest{
@@ -458,12 +740,14 @@ func TestLabelValues_errorNonMatrixResult(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	viper.Set("maia.label_values_mode", "query")
 
 	expectAuthByProjectID(keystoneMock)
 	storageMock.EXPECT().QueryRange(
-		"count by (service) ({project_id=\"12345\",service!=\"\"})",
+		test.MatchContext(),
+		`count by (service) ({service!="",project_id="12345"})`,
 		test.TimeStringMatcher{}, test.TimeStringMatcher{},
-		viper.Get("maia.label_value_ttl"), "", storage.JSON,
+		"259200", storage.JSON,
 	).Return(test.HTTPResponseFromFile("fixtures/label_values_query_range_vector.json"), nil)
 
 	test.APIRequest{
@@ -481,7 +765,7 @@ func TestQuery(t *testing.T) {
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
 
 	expectAuthByProjectID(keystoneMock)
-	storageMock.EXPECT().Query("sum(blackbox_api_status_gauge{check=~\"keystone\",project_id=\"12345\"})", "2017-07-01T20:10:30.781Z", "24m", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
+	storageMock.EXPECT().Query(test.MatchContextWithDeadline(24*time.Minute), "sum(blackbox_api_status_gauge{check=~\"keystone\",project_id=\"12345\"})", "2017-07-01T20:10:30.781Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
 
 	test.APIRequest{
 		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
@@ -492,6 +776,42 @@ func TestQuery(t *testing.T) {
 	}.Check(t, router)
 }
 
+func TestQuery_post(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Query(test.MatchContextWithDeadline(10*time.Second), "sum(blackbox_api_status_gauge{project_id=\"12345\"})", "2017-07-02T04:00:00.000Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "POST",
+		Path:             "/api/v1/query?timeout=10s",
+		RequestForm:      url.Values{"query": {"sum(blackbox_api_status_gauge)"}, "time": {"2017-07-02T04:00:00.000Z"}},
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/query.json",
+	}.Check(t, router)
+}
+
+func TestSeries_post(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Series(test.MatchContext(), []string{"{component!=\"\",project_id=\"12345\"}"}, "2017-07-01T20:10:30.781Z", "2017-07-03T20:10:00.000Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "POST",
+		Path:             "/api/v1/series",
+		RequestForm:      url.Values{"match[]": {`{component!=""}`}, "start": {"2017-07-01T20:10:30.781Z"}, "end": {"2017-07-03T20:10:00.000Z"}},
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/series.json",
+	}.Check(t, router)
+}
+
 func TestQuery_withSentinel(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -499,7 +819,7 @@ func TestQuery_withSentinel(t *testing.T) {
 	sentinelValue = "all"
 
 	expectAuthByProjectID(keystoneMock)
-	storageMock.EXPECT().Query(`sum(blackbox_api_status_gauge{check=~"keystone",project_id=~"12345|all"})`, "2017-07-01T20:10:30.781Z", "24m", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
+	storageMock.EXPECT().Query(test.MatchContextWithDeadline(24*time.Minute), `sum(blackbox_api_status_gauge{check=~"keystone",project_id=~"12345|all"})`, "2017-07-01T20:10:30.781Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
 
 	test.APIRequest{
 		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
@@ -519,7 +839,7 @@ func TestQuery_sentinelDisabled(t *testing.T) {
 
 	expectAuthByProjectID(keystoneMock)
 	// With sentinel disabled, single project should use exact match (=) not regex (=~)
-	storageMock.EXPECT().Query("sum(blackbox_api_status_gauge{check=~\"keystone\",project_id=\"12345\"})", "2017-07-01T20:10:30.781Z", "24m", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
+	storageMock.EXPECT().Query(test.MatchContextWithDeadline(24*time.Minute), "sum(blackbox_api_status_gauge{check=~\"keystone\",project_id=\"12345\"})", "2017-07-01T20:10:30.781Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
 
 	test.APIRequest{
 		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
@@ -546,44 +866,840 @@ func TestQuery_syntaxError(t *testing.T) {
 	}.Check(t, router)
 }
 
-func TestQueryRange(t *testing.T) {
+func TestQuery_errorInvalidTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query?query=sum(blackbox_api_status_gauge)&timeout=forever",
+		ExpectStatusCode: http.StatusBadRequest,
+	}.Check(t, router)
+}
+
+func TestQuery_errorQueryLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+	queryLimits = util.TenantQueryLimits{Default: util.QueryLimits{MaxRange: 7 * 24 * time.Hour, DenyNameOnlySelectors: true}}
+
+	expectAuthByProjectID(keystoneMock)
+
+	expectedBody := `{"status":"error","errorType":"bad_data","error":"range [30d] of http_requests_total exceeds the maximum range of 1w"}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query?query=" + url.QueryEscape("sum(rate(http_requests_total[30d]))"),
+		ExpectStatusCode: http.StatusBadRequest,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestQuery_queryLimitsProjectOverride(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	queryLimits = util.TenantQueryLimits{
+		Default:  util.QueryLimits{MaxRange: 7 * 24 * time.Hour},
+		Projects: map[string]util.QueryLimits{"12345": {MaxRange: 31 * 24 * time.Hour}},
+	}
 
+	// the project may use longer ranges than the other tenants
 	expectAuthByProjectID(keystoneMock)
-	storageMock.EXPECT().QueryRange("sum({__name__=\"blackbox_api_status_gauge\",check=~\"keystone\",project_id=\"12345\"})", "2017-07-01T20:10:30.781Z", "2017-07-02T04:00:00.000Z", "5m", "90s", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query_range.json"), nil)
+	storageMock.EXPECT().Query(test.MatchContext(), `sum(rate(http_requests_total{project_id="12345"}[30d]))`, "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
 
 	test.APIRequest{
 		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
 		Method:           "GET",
-		Path:             "/api/v1/query_range?query=sum(%7B__name__%3D%22blackbox_api_status_gauge%22%2Ccheck%3D~%22keystone%22%2Cproject_id%3D%2212345%22%7D)&end=2017-07-02T04:00:00.000Z&start=2017-07-01T20:10:30.781Z&step=5m&timeout=90s",
+		Path:             "/api/v1/query?query=" + url.QueryEscape("sum(rate(http_requests_total[30d]))"),
 		ExpectStatusCode: http.StatusOK,
-		ExpectJSON:       "fixtures/query_range.json",
+		ExpectJSON:       "fixtures/query.json",
 	}.Check(t, router)
 }
 
-func TestQueryRange_withSentinel(t *testing.T) {
+func TestFederate_errorQueryLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+	queryLimits = util.TenantQueryLimits{Default: util.QueryLimits{DenyNameOnlySelectors: true}}
+
+	// the scope constraint must not make a name-only selector acceptable
+	expectAuthByDomainName(keystoneMock)
+
+	expectedBody := `{"status":"error","errorType":"bad_data","error":"selector {__name__=~\".+\"} must contain a label matcher other than __name__ or an exact metric name"}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/federate?match[]=" + url.QueryEscape(`{__name__=~".+"}`),
+		ExpectStatusCode: http.StatusBadRequest,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestSeries_errorQueryLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+	queryLimits = util.TenantQueryLimits{Default: util.QueryLimits{DenyNameOnlySelectors: true}}
+
+	for _, path := range []string{"/api/v1/series", "/api/v1/labels", "/api/v1/label/job/values"} {
+		expectAuthByProjectID(keystoneMock)
+		test.APIRequest{
+			Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+			Method:           "GET",
+			Path:             path + "?match[]=" + url.QueryEscape(`{__name__=~".+"}`),
+			ExpectStatusCode: http.StatusBadRequest,
+		}.Check(t, router)
+	}
+}
+
+func TestQueryLimitsFromConfig(t *testing.T) {
+	viper.Set("maia.query_limits", map[string]any{
+		"max_range":         "7d",
+		"min_subquery_step": "1m",
+		"projects":          map[string]any{"12345": map[string]any{"max_range": "30d"}},
+		"domains":           map[string]any{"77777": map[string]any{"deny_name_only_selectors": true}},
+	})
+	defer viper.Set("maia.query_limits", nil)
+
+	limits := queryLimitsFromConfig()
+	assert.Equal(t, util.QueryLimits{MaxRange: 7 * 24 * time.Hour, MinSubqueryStep: time.Minute}, limits.Default)
+	// overrides inherit the limits they do not set
+	assert.Equal(t, util.QueryLimits{MaxRange: 30 * 24 * time.Hour, MinSubqueryStep: time.Minute}, limits.For("12345", "77777"))
+	assert.Equal(t, util.QueryLimits{MaxRange: 7 * 24 * time.Hour, MinSubqueryStep: time.Minute, DenyNameOnlySelectors: true}, limits.For("67890", "77777"))
+}
+
+func TestQueryRange_errorQueryLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+	queryLimits = util.TenantQueryLimits{Default: util.QueryLimits{MaxPoints: 11000}}
+
+	expectAuthByProjectID(keystoneMock)
+
+	expectedBody := `{"status":"error","errorType":"bad_data","error":"query_range with step 1s would return 86401 points per series, ` +
+		`more than the maximum of 11000: increase the step or shorten the time range"}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query_range?query=up&start=0&end=86400&step=1",
+		ExpectStatusCode: http.StatusBadRequest,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestQuery_errorSeriesBudget(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
 	router, keystoneMock, storageMock := setupTest(t, ctrl)
-	sentinelValue = "all"
+	seriesBudget = newSeriesBudgetCheck(1000, false, time.Minute)
 
 	expectAuthByProjectID(keystoneMock)
-	// When the user's query already contains project_id="12345", the injected
-	// project_id=~"12345|all" is still added (different matcher type). Prometheus
-	// ANDs them, so the regex is a harmless superset of the exact match.
-	storageMock.EXPECT().QueryRange(`sum({__name__="blackbox_api_status_gauge",check=~"keystone",project_id="12345",project_id=~"12345|all"})`, "2017-07-01T20:10:30.781Z", "2017-07-02T04:00:00.000Z", "5m", "90s", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query_range.json"), nil)
+	storageMock.EXPECT().Query(test.MatchContext(), `count({__name__="http_requests_total",project_id="12345"})`, "2017-07-01T20:10:30.781Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series_count.json"), nil)
 
+	expectedBody := `{"status":"error","errorType":"execution","error":"query would touch about 1500 series, more than the budget of 1000 series: ` +
+		`add label matchers to narrow down the selection"}`
 	test.APIRequest{
 		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
 		Method:           "GET",
-		Path:             "/api/v1/query_range?query=sum(%7B__name__%3D%22blackbox_api_status_gauge%22%2Ccheck%3D~%22keystone%22%2Cproject_id%3D%2212345%22%7D)&end=2017-07-02T04:00:00.000Z&start=2017-07-01T20:10:30.781Z&step=5m&timeout=90s",
+		Path:             "/api/v1/query?query=" + url.QueryEscape("sum(rate(http_requests_total[5m]))") + "&time=2017-07-01T20:10:30.781Z",
+		ExpectStatusCode: http.StatusUnprocessableEntity,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestQueryRange_seriesBudget(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	seriesBudget = newSeriesBudgetCheck(10000, false, time.Minute)
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Query(test.MatchContext(), `count({__name__="blackbox_api_status_gauge",check=~"keystone",project_id="12345"})`, "2017-07-02T04:00:00.000Z", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series_count.json"), nil)
+	storageMock.EXPECT().QueryRange(test.MatchContext(), "sum(blackbox_api_status_gauge{check=~\"keystone\",project_id=\"12345\"})", "2017-07-01T20:10:30.781Z", "2017-07-02T04:00:00.000Z", "300", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query_range.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query_range?query=sum(blackbox_api_status_gauge{check%3D~%22keystone%22})&start=2017-07-01T20:10:30.781Z&end=2017-07-02T04:00:00.000Z&step=300",
 		ExpectStatusCode: http.StatusOK,
 		ExpectJSON:       "fixtures/query_range.json",
 	}.Check(t, router)
 }
 
+func TestQuery_errorTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Query(test.MatchContextWithDeadline(10*time.Second), "sum(blackbox_api_status_gauge{project_id=\"12345\"})", "", storage.JSON).
+		Return(nil, context.DeadlineExceeded)
+
+	before := testutil.ToFloat64(promCancellationsCounter.WithLabelValues("timeout"))
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query?query=sum(blackbox_api_status_gauge)&timeout=10",
+		ExpectStatusCode: http.StatusServiceUnavailable,
+	}.Check(t, router)
+	assert.InDelta(t, before+1, testutil.ToFloat64(promCancellationsCounter.WithLabelValues("timeout")), 0.1)
+}
+
+func TestScopeLabelsFromConfig(t *testing.T) {
+	defer viper.Set("maia.scope_labels", nil)
+
+	// without configuration, the labels are the same as before
+	assert.Equal(t, defaultScopeLabels, scopeLabelsFromConfig())
+
+	viper.Set("maia.scope_labels", map[string]any{"project": []string{"project_id", "tenant_id"}})
+	assert.Equal(t, tenancyLabels{project: []string{"project_id", "tenant_id"}, domain: []string{"domain_id"}}, scopeLabelsFromConfig())
+
+	viper.Set("maia.scope_labels", map[string]any{"project": []string{}})
+	assert.Panics(t, func() { scopeLabelsFromConfig() })
+	viper.Set("maia.scope_labels", map[string]any{"project": []string{"os-project"}})
+	assert.Panics(t, func() { scopeLabelsFromConfig() })
+	viper.Set("maia.scope_labels", map[string]any{"project": []string{"project_id"}, "domain": []string{"project_id"}})
+	assert.Panics(t, func() { scopeLabelsFromConfig() })
+}
+
+func TestQuery_scopeLabels(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	scopeLabels = tenancyLabels{project: []string{"project_id", "os_project_id"}, domain: []string{"domain_id"}}
+
+	// every selector is expanded into a union over the project labels
+	expectAuthWithChildren(keystoneMock)
+	storageMock.EXPECT().Query(test.MatchContext(), `sum by (check) ((rate(http_requests_total{project_id=~"12345|67890"}[5m]) or rate(http_requests_total{os_project_id=~"12345|67890"}[5m])))`, "", storage.JSON).
+		Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query?query=" + url.QueryEscape("sum by (check) (rate(http_requests_total[5m]))"),
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/query.json",
+	}.Check(t, router)
+}
+
+func TestSeries_scopeLabels(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	scopeLabels = tenancyLabels{project: []string{"project_id", "tenant_id"}, domain: []string{"domain_id"}}
+
+	// the match[] parameters are OR-combined by Prometheus
+	expectAuthWithChildren(keystoneMock)
+	storageMock.EXPECT().Series(test.MatchContext(), []string{`{component!="",project_id=~"12345|67890"}`, `{component!="",tenant_id=~"12345|67890"}`}, "", "", storage.JSON).
+		Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"X-Auth-Token": "someverylongtokenideed", "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/series?match[]={component!=%22%22}",
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/series.json",
+	}.Check(t, router)
+}
+
+func TestQuery_domainScopeProjectsRangeSelector(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	domainScopeProjects = true
+
+	// a range selector cannot be OR-combined, so it is queried once per label and the results are merged
+	expectAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{"12345", "67890"}, nil)
+	storageMock.EXPECT().Query(test.MatchContext(), `up{domain_id="77777"}[5m]`, "", storage.JSON).Return(storageResponse(storage.JSON,
+		`{"status":"success","data":{"resultType":"matrix","result":[`+
+			`{"metric":{"__name__":"up","domain_id":"77777"},"values":[[1500000000,"1"]]},`+
+			`{"metric":{"__name__":"up","domain_id":"77777","project_id":"12345"},"values":[[1500000000,"2"]]}]}}`), nil)
+	storageMock.EXPECT().Query(test.MatchContext(), `up{project_id=~"12345|67890"}[5m]`, "", storage.JSON).Return(storageResponse(storage.JSON,
+		`{"status":"success","data":{"resultType":"matrix","result":[`+
+			`{"metric":{"__name__":"up","domain_id":"77777","project_id":"12345"},"values":[[1500000000,"2"]]},`+
+			`{"metric":{"__name__":"up","project_id":"67890"},"values":[[1500000000,"3"]]}]},"warnings":["partial"]}`), nil)
+
+	expectedBody := `{"status":"success","data":{"resultType":"matrix","result":[` +
+		`{"metric":{"__name__":"up","domain_id":"77777"},"values":[[1500000000,"1"]]},` +
+		`{"metric":{"__name__":"up","domain_id":"77777","project_id":"12345"},"values":[[1500000000,"2"]]},` +
+		`{"metric":{"__name__":"up","project_id":"67890"},"values":[[1500000000,"3"]]}]},"warnings":["partial"]}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query?query=" + url.QueryEscape("up[5m]"),
+		ExpectStatusCode: http.StatusOK,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestQueryRange(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().QueryRange(test.MatchContextWithDeadline(90*time.Second), "sum({__name__=\"blackbox_api_status_gauge\",check=~\"keystone\",project_id=\"12345\"})", "2017-07-01T20:10:30.781Z", "2017-07-02T04:00:00.000Z", "5m", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query_range.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query_range?query=sum(%7B__name__%3D%22blackbox_api_status_gauge%22%2Ccheck%3D~%22keystone%22%2Cproject_id%3D%2212345%22%7D)&end=2017-07-02T04:00:00.000Z&start=2017-07-01T20:10:30.781Z&step=5m&timeout=90s",
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/query_range.json",
+	}.Check(t, router)
+}
+
+func TestQueryRange_withSentinel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	sentinelValue = "all"
+
+	expectAuthByProjectID(keystoneMock)
+	// When the user's query already contains project_id="12345", the injected
+	// project_id=~"12345|all" is still added (different matcher type). Prometheus
+	// ANDs them, so the regex is a harmless superset of the exact match.
+	storageMock.EXPECT().QueryRange(test.MatchContextWithDeadline(90*time.Second), `sum({__name__="blackbox_api_status_gauge",check=~"keystone",project_id="12345",project_id=~"12345|all"})`, "2017-07-01T20:10:30.781Z", "2017-07-02T04:00:00.000Z", "5m", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query_range.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query_range?query=sum(%7B__name__%3D%22blackbox_api_status_gauge%22%2Ccheck%3D~%22keystone%22%2Cproject_id%3D%2212345%22%7D)&end=2017-07-02T04:00:00.000Z&start=2017-07-01T20:10:30.781Z&step=5m&timeout=90s",
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/query_range.json",
+	}.Check(t, router)
+}
+
+func TestQueryExemplars(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().QueryExemplars(test.MatchContext(), `http_request_duration_seconds_bucket{project_id="12345"}`, "1600096900", "1600097000", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query_exemplars.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query_exemplars?query=http_request_duration_seconds_bucket&start=1600096900&end=1600097000",
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/query_exemplars.json",
+	}.Check(t, router)
+}
+
+func TestMetadata(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+	// the metric names within the scope are determined like the values of the __name__ label
+	expectMatchProbe(storageMock, "fixtures/label_values_empty.json")
+	storageMock.EXPECT().LabelValues(test.MatchContext(), "__name__", []string{`{__name__!="",project_id="12345"}`}, test.TimeStringMatcher{}, test.TimeStringMatcher{}, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_names.json"), nil)
+	storageMock.EXPECT().Metadata(test.MatchContext(), "", "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/metadata_upstream.json"), nil)
+
+	// other_tenant_metric does not exist in the project
+	expectedBody := `{"status":"success","data":{"kube_node_info":[{"type":"gauge","help":"Information about a cluster node.","unit":""}],` +
+		`"tenant_metric":[{"type":"counter","help":"Some metric of the tenant.","unit":"seconds"}]}}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/metadata",
+		ExpectStatusCode: http.StatusOK,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestMetadata_limit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+	expectMatchProbe(storageMock, "fixtures/label_values_empty.json")
+	storageMock.EXPECT().LabelValues(test.MatchContext(), "__name__", []string{`{__name__!="",project_id="12345"}`}, test.TimeStringMatcher{}, test.TimeStringMatcher{}, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values_names.json"), nil)
+	storageMock.EXPECT().Metadata(test.MatchContext(), "", "1", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/metadata_upstream.json"), nil)
+
+	expectedBody := `{"status":"success","data":{"kube_node_info":[{"type":"gauge","help":"Information about a cluster node.","unit":""}]}}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/metadata?limit=1&limit_per_metric=1",
+		ExpectStatusCode: http.StatusOK,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestRemoteRead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	request := prompb.ReadRequest{Queries: []*prompb.Query{{StartTimestampMs: 1000, EndTimestampMs: 2000, Matchers: []*prompb.LabelMatcher{
+		{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+	}}}}
+	encodedRequest, err := request.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Queries[0].Matchers = append(request.Queries[0].Matchers, &prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: "project_id", Value: "12345"})
+	expectedRequest, err := request.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().RemoteRead(test.MatchContext(), snappy.Encode(nil, expectedRequest)).Return(&http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {"application/x-protobuf"}, "Content-Encoding": {"snappy"}},
+		Body:          io.NopCloser(strings.NewReader("response")),
+		ContentLength: -1,
+	}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/read", bytes.NewReader(snappy.Encode(nil, encodedRequest)))
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")))
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "snappy", recorder.Header().Get("Content-Encoding"))
+	assert.Equal(t, "response", recorder.Body.String())
+}
+
+func TestRemoteRead_invalidRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password"))},
+		Method:           "POST",
+		Path:             "/api/v1/read",
+		RequestJSON:      "not snappy",
+		ExpectStatusCode: http.StatusBadRequest,
+	}.Check(t, router)
+}
+
+func TestQuery_domainScopeProjects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	domainScopeProjects = true
+	sentinelValue = "all"
+
+	// series of the domain or of any of its projects are visible
+	expectAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{"12345", "67890"}, nil)
+	storageMock.EXPECT().Query(test.MatchContext(), `sum by (project_id) ((up{domain_id=~"77777|all"} or up{project_id=~"12345|67890|all"}))`, "", storage.JSON).
+		Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query?query=" + url.QueryEscape("sum by (project_id) (up)"),
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/query.json",
+	}.Check(t, router)
+}
+
+func TestFederate_domainScopeProjectsWithoutProjects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	domainScopeProjects = true
+
+	// a domain without projects must not match series without project_id
+	expectAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{}, nil)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{`{vmware_name="win_cifs_13",domain_id="77777"}`}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.PlainText},
+		Method:           "GET",
+		Path:             "/federate?match[]={vmware_name=%22win_cifs_13%22}",
+		ExpectStatusCode: http.StatusOK,
+		ExpectFile:       "fixtures/federate.txt",
+	}.Check(t, router)
+}
+
+func TestQuery_withoutChildren(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	// the child projects are not even looked up
+	httpReqMatcher := test.HTTPRequestMatcher{InjectHeader: projectHeader}
+	keystoneMock.EXPECT().AuthenticateRequest(test.MatchContext(), httpReqMatcher, false).Return(projectContext, nil)
+	storageMock.EXPECT().Query(test.MatchContext(), `sum(up{project_id="12345"})`, "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query?include_children=false&query=" + url.QueryEscape("sum(up)"),
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/query.json",
+	}.Check(t, router)
+}
+
+func TestSeries_childProjects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	// the selection is validated against the children, which are looked up only once
+	expectAuthWithChildren(keystoneMock)
+	storageMock.EXPECT().Series(test.MatchContext(), []string{`{component="objectstore",project_id="67890"}`}, "", "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON, "X-Child-Projects": "67890"},
+		Method:           "GET",
+		Path:             "/api/v1/series?match[]=" + url.QueryEscape(`{component="objectstore"}`),
+		ExpectStatusCode: http.StatusOK,
+		ExpectJSON:       "fixtures/series.json",
+	}.Check(t, router)
+}
+
+func TestSeries_errorChildProjects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+	expectAuthWithChildren(keystoneMock)
+
+	// projects outside of the hierarchy of the token's project are rejected
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/series?child_projects=67890,99999&match[]=" + url.QueryEscape(`{component="objectstore"}`),
+		ExpectStatusCode: http.StatusForbidden,
+	}.Check(t, router)
+}
+
+func TestQuery_errorChildProjectsDomainScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+	expectAuthByDomainName(keystoneMock)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/query?include_children=false&query=up",
+		ExpectStatusCode: http.StatusBadRequest,
+	}.Check(t, router)
+}
+
+func TestQuery_errorIncludeChildren(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+	httpReqMatcher := test.HTTPRequestMatcher{InjectHeader: projectHeader}
+	keystoneMock.EXPECT().AuthenticateRequest(test.MatchContext(), httpReqMatcher, false).Return(projectContext, nil).Times(2)
+
+	for _, path := range []string{"/api/v1/query?include_children=maybe&query=up", "/api/v1/query?include_children=false&child_projects=67890&query=up"} {
+		test.APIRequest{
+			Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+			Method:           "GET",
+			Path:             path,
+			ExpectStatusCode: http.StatusBadRequest,
+		}.Check(t, router)
+	}
+}
+
+// remoteReadDomainScopeProjects sends a remote read request for up with a domain token and returns the response. The
+// storage is expected to be asked once for the series of the domain and once for those of its projects.
+func remoteReadDomainScopeProjects(t *testing.T, acceptedResponseTypes []prompb.ReadRequest_ResponseType) *httptest.ResponseRecorder {
+	t.Helper()
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	domainScopeProjects = true
+
+	query := func(constraint ...*prompb.LabelMatcher) *prompb.Query {
+		return &prompb.Query{StartTimestampMs: 1000, EndTimestampMs: 2000, Matchers: append([]*prompb.LabelMatcher{
+			{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+		}, constraint...)}
+	}
+	request := prompb.ReadRequest{Queries: []*prompb.Query{query()}, AcceptedResponseTypes: acceptedResponseTypes}
+	encodedRequest, err := request.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upSeries := func(samples int64, labelPairs ...string) *prompb.TimeSeries {
+		ts := &prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: "up"}}}
+		for i := 0; i < len(labelPairs); i += 2 {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: labelPairs[i], Value: labelPairs[i+1]})
+		}
+		for i := range samples {
+			ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: 1000 + 15000*i, Value: 1})
+		}
+		return ts
+	}
+	expectRead := func(constraint *prompb.LabelMatcher, series ...*prompb.TimeSeries) {
+		expected, err := (&prompb.ReadRequest{
+			Queries:               []*prompb.Query{query(constraint)},
+			AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_SAMPLES},
+		}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := (&prompb.ReadResponse{Results: []*prompb.QueryResult{{Timeseries: series}}}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		storageMock.EXPECT().RemoteRead(test.MatchContext(), snappy.Encode(nil, expected)).Return(&http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/x-protobuf"}, "Content-Encoding": {"snappy"}},
+			Body:       io.NopCloser(bytes.NewReader(snappy.Encode(nil, response))),
+		}, nil)
+	}
+
+	// the series with both labels is returned for both of them
+	expectAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{"12345", "67890"}, nil)
+	expectRead(&prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: "domain_id", Value: "77777"},
+		upSeries(1, "domain_id", "77777"), upSeries(2, "domain_id", "77777", "project_id", "12345"))
+	expectRead(&prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: "project_id", Value: "12345|67890"},
+		upSeries(2, "domain_id", "77777", "project_id", "12345"), upSeries(1, "project_id", "67890"))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/read", bytes.NewReader(snappy.Encode(nil, encodedRequest)))
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")))
+	req.Header.Set("Content-Encoding", "snappy")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func labelsString(ls []prompb.Label) string {
+	b := labels.NewScratchBuilder(len(ls))
+	for _, l := range ls {
+		b.Add(l.Name, l.Value)
+	}
+	return b.Labels().String()
+}
+
+func TestRemoteRead_domainScopeProjects(t *testing.T) {
+	recorder := remoteReadDomainScopeProjects(t, nil)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "snappy", recorder.Header().Get("Content-Encoding"))
+	decoded, err := snappy.Decode(nil, recorder.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response prompb.ReadResponse
+	if err := response.Unmarshal(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, response.Results, 1) {
+		var series []string
+		for _, ts := range response.Results[0].Timeseries {
+			series = append(series, fmt.Sprintf("%s: %d samples", labelsString(ts.Labels), len(ts.Samples)))
+		}
+		assert.Equal(t, []string{
+			`{__name__="up", domain_id="77777"}: 1 samples`,
+			`{__name__="up", domain_id="77777", project_id="12345"}: 2 samples`,
+			`{__name__="up", project_id="67890"}: 1 samples`,
+		}, series)
+	}
+}
+
+func TestRemoteRead_domainScopeProjectsStreamed(t *testing.T) {
+	recorder := remoteReadDomainScopeProjects(t, []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS})
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse", recorder.Header().Get("Content-Type"))
+	reader := remote.NewChunkedReader(recorder.Body, 1<<20, nil)
+	var series []string
+	for {
+		var frame prompb.ChunkedReadResponse
+		err := reader.NextProto(&frame)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cs := range frame.ChunkedSeries {
+			series = append(series, fmt.Sprintf("%d %s", frame.QueryIndex, labelsString(cs.Labels)))
+		}
+	}
+	assert.Equal(t, []string{
+		`0 {__name__="up", domain_id="77777"}`,
+		`0 {__name__="up", domain_id="77777", project_id="12345"}`,
+		`0 {__name__="up", project_id="67890"}`,
+	}, series)
+}
+
+func TestAlerts_domainScopeLabels(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	scopeLabels = tenancyLabels{project: []string{"tenant_id", "project_id"}, domain: []string{"domain_id"}}
+
+	expectAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{"67890"}, nil)
+	storageMock.EXPECT().Alerts(test.MatchContext()).Return(test.HTTPResponseFromFile("fixtures/alerts_upstream.json"), nil)
+
+	expectedBody := `{"status":"success","data":{"alerts":[` +
+		`{"labels":{"alertname":"InstanceDown","project_id":"67890","severity":"warning"},"annotations":{"summary":"Instance down"},"state":"pending","activeAt":"2017-07-01T20:12:30.781Z","value":"0e+00"},` +
+		`{"labels":{"alertname":"QuotaExceeded","domain_id":"77777"},"annotations":{},"state":"firing","activeAt":"2017-07-01T20:00:00Z","value":"1.2e+00"}]}}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/alerts",
+		ExpectStatusCode: http.StatusOK,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestAlerts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	sentinelValue = "all"
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Alerts(test.MatchContext()).Return(test.HTTPResponseFromFile("fixtures/alerts_upstream.json"), nil)
+
+	expectedBody := `{"status":"success","data":{"alerts":[` +
+		`{"labels":{"alertname":"InstanceDown","project_id":"12345","severity":"warning"},"annotations":{"summary":"Instance down"},"state":"firing","activeAt":"2017-07-01T20:10:30.781Z","value":"0e+00"},` +
+		`{"labels":{"alertname":"Maintenance","project_id":"all"},"annotations":{},"state":"firing","activeAt":"2017-07-01T19:00:00Z","value":"1e+00"}]}}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/alerts",
+		ExpectStatusCode: http.StatusOK,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestAlerts_domainScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{"67890"}, nil)
+	storageMock.EXPECT().Alerts(test.MatchContext()).Return(test.HTTPResponseFromFile("fixtures/alerts_upstream.json"), nil)
+
+	expectedBody := `{"status":"success","data":{"alerts":[` +
+		`{"labels":{"alertname":"InstanceDown","project_id":"67890","severity":"warning"},"annotations":{"summary":"Instance down"},"state":"pending","activeAt":"2017-07-01T20:12:30.781Z","value":"0e+00"},` +
+		`{"labels":{"alertname":"QuotaExceeded","domain_id":"77777"},"annotations":{},"state":"firing","activeAt":"2017-07-01T20:00:00Z","value":"1.2e+00"}]}}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/alerts",
+		ExpectStatusCode: http.StatusOK,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestAlerts_domainScopeProjects(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	domainScopeProjects = true
+
+	// the projects of the domain are only looked up once
+	expectAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{"67890"}, nil)
+	storageMock.EXPECT().Alerts(test.MatchContext()).Return(test.HTTPResponseFromFile("fixtures/alerts_upstream.json"), nil)
+
+	expectedBody := `{"status":"success","data":{"alerts":[` +
+		`{"labels":{"alertname":"InstanceDown","project_id":"67890","severity":"warning"},"annotations":{"summary":"Instance down"},"state":"pending","activeAt":"2017-07-01T20:12:30.781Z","value":"0e+00"},` +
+		`{"labels":{"alertname":"QuotaExceeded","domain_id":"77777"},"annotations":{},"state":"firing","activeAt":"2017-07-01T20:00:00Z","value":"1.2e+00"}]}}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic u12345|@77777:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/alerts",
+		ExpectStatusCode: http.StatusOK,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestAlerts_failAuthorization(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+
+	expectAuthAndDenyAuthorization(keystoneMock)
+
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/alerts",
+		ExpectStatusCode: http.StatusForbidden,
+	}.Check(t, router)
+}
+
+func TestRules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Rules(test.MatchContext(), "alert", []string{"InstanceDown", "ClusterDown"}, nil).Return(test.HTTPResponseFromFile("fixtures/rules_upstream.json"), nil)
+
+	// the state of the rule only reflects the alert of the project, the recording rule and rules without alerts of the project are left out
+	expectedBody := `{"status":"success","data":{"groups":[{"name":"instances","file":"/etc/prometheus/rules/instances.yml","rules":[` +
+		`{"state":"pending","name":"InstanceDown","query":"up == 0","duration":300,"labels":{"severity":"warning"},"annotations":{"summary":"Instance down"},"alerts":[` +
+		`{"labels":{"alertname":"InstanceDown","project_id":"12345","severity":"warning"},"annotations":{"summary":"Instance down"},"state":"pending","activeAt":"2017-07-01T20:12:30.781Z","value":"0e+00"}],` +
+		`"health":"ok","evaluationTime":0.001,"lastEvaluation":"2017-07-01T20:13:00Z","type":"alerting"}],` +
+		`"interval":60,"limit":0,"evaluationTime":0.002,"lastEvaluation":"2017-07-01T20:13:00Z"}]}}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/rules?type=alert&rule_name[]=InstanceDown&rule_name[]=ClusterDown",
+		ExpectStatusCode: http.StatusOK,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestRules_errorInvalidType(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+
+	expectedBody := `{"status":"error","errorType":"bad_data","error":"invalid parameter 'type': \"alerting\" is neither alert nor record"}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/rules?type=alerting",
+		ExpectStatusCode: http.StatusBadRequest,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
+func TestRules_errorRecordingRules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	router, keystoneMock, _ := setupTest(t, ctrl)
+
+	expectAuthByProjectID(keystoneMock)
+
+	expectedBody := `{"status":"error","errorType":"bad_data","error":"invalid parameter 'type': recording rules are not available, since they are not scoped to tenants"}`
+	test.APIRequest{
+		Headers:          map[string]string{"Authorization": base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")), "Accept": storage.JSON},
+		Method:           "GET",
+		Path:             "/api/v1/rules?type=record",
+		ExpectStatusCode: http.StatusBadRequest,
+		ExpectBody:       &expectedBody,
+	}.Check(t, router)
+}
+
 func TestAPIMetadata(t *testing.T) {
 	ctrl := gomock.NewController(t)
 
@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/viper"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/storage"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/util"
+)
+
+// seriesEstimateHeader returns the estimated number of series touched by a query to the client
+const seriesEstimateHeader = "X-Maia-Series-Estimate"
+
+// maxSeriesEstimates bounds the number of selectors kept in the cache of a seriesBudget
+const maxSeriesEstimates = 10000
+
+var seriesBudgetExceededCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "maia_series_budget_exceeded_count",
+	Help: "Number of queries estimated to touch more series than the configured series budget"},
+	[]string{"action"})
+
+func init() {
+	prometheus.MustRegister(seriesBudgetExceededCounter)
+}
+
+// seriesBudget is the optional pre-flight check which estimates the number of series touched by a query before it is
+// forwarded. It is nil when disabled.
+var seriesBudget *seriesBudgetCheck
+
+// seriesBudgetCheck counts the series of every selector of a query with a scoped count() query. The counts are cached
+// per selector, so that dashboards refreshing the same panels do not double the load on the storage.
+type seriesBudgetCheck struct {
+	maxSeries int64
+	warnOnly  bool
+	cacheTTL  time.Duration
+
+	mutex     sync.Mutex
+	estimates map[string]seriesEstimate
+	now       func() time.Time
+}
+
+type seriesEstimate struct {
+	count   int64
+	expires time.Time
+}
+
+// seriesBudgetFromConfig reads the pre-flight check settings from the [maia.series_budget] section
+func seriesBudgetFromConfig() *seriesBudgetCheck {
+	maxSeries := viper.GetInt64("maia.series_budget.max_series")
+	if maxSeries <= 0 {
+		return nil
+	}
+
+	mode := viper.GetString("maia.series_budget.mode")
+	if mode == "" {
+		mode = "reject"
+	}
+	if mode != "reject" && mode != "warn" {
+		panic(fmt.Errorf("invalid maia.series_budget.mode setting %q: must be reject or warn", mode))
+	}
+	ttl := 5 * time.Minute
+	if s := viper.GetString("maia.series_budget.cache_ttl"); s != "" {
+		d, err := model.ParseDuration(s)
+		if err != nil {
+			panic(fmt.Errorf("invalid maia.series_budget.cache_ttl setting: %w", err))
+		}
+		ttl = time.Duration(d)
+	}
+
+	logg.Info("Series budget: max_series=%d mode=%s cache_ttl=%s", maxSeries, mode, model.Duration(ttl))
+	return newSeriesBudgetCheck(maxSeries, mode == "warn", ttl)
+}
+
+func newSeriesBudgetCheck(maxSeries int64, warnOnly bool, cacheTTL time.Duration) *seriesBudgetCheck {
+	return &seriesBudgetCheck{
+		maxSeries: maxSeries,
+		warnOnly:  warnOnly,
+		cacheTTL:  cacheTTL,
+		estimates: make(map[string]seriesEstimate),
+		now:       time.Now,
+	}
+}
+
+// Check estimates the number of series touched by an already scoped expression at the given evaluation time and
+// reports the estimate in the response header. If the budget is exceeded, an error is returned, or, in warn mode,
+// a Warning header is added. Since the check is only a safeguard, queries are passed on when no estimate is possible.
+func (c *seriesBudgetCheck) Check(ctx context.Context, w http.ResponseWriter, driver storage.Driver, expression, timestamp string) error {
+	estimate, err := c.estimate(ctx, driver, expression, timestamp)
+	if err != nil {
+		logg.Info("Could not estimate the number of series of query %s: %s", expression, err.Error())
+		return nil
+	}
+	w.Header().Set(seriesEstimateHeader, strconv.FormatInt(estimate, 10))
+	if estimate <= c.maxSeries {
+		return nil
+	}
+
+	err = fmt.Errorf("query would touch about %d series, more than the budget of %d series: add label matchers to narrow down the selection",
+		estimate, c.maxSeries)
+	if c.warnOnly {
+		seriesBudgetExceededCounter.WithLabelValues("warn").Inc()
+		w.Header().Add("Warning", fmt.Sprintf("199 maia %q", err.Error()))
+		return nil
+	}
+	seriesBudgetExceededCounter.WithLabelValues("reject").Inc()
+	return err
+}
+
+// estimate sums up the series counts of all distinct selectors of the expression
+func (c *seriesBudgetCheck) estimate(ctx context.Context, driver storage.Driver, expression, timestamp string) (int64, error) {
+	selectors, err := util.ExtractSelectors(expression)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	seen := make(map[string]bool)
+	for _, matchers := range selectors {
+		parts := make([]string, len(matchers))
+		for i, m := range matchers {
+			parts[i] = m.String()
+		}
+		slices.Sort(parts)
+		selector := "{" + strings.Join(parts, ",") + "}"
+		if seen[selector] {
+			continue
+		}
+		seen[selector] = true
+
+		count, err := c.countSeries(ctx, driver, selector, timestamp)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// countSeries returns the number of series matching a selector, either from the cache or with a count() query
+func (c *seriesBudgetCheck) countSeries(ctx context.Context, driver storage.Driver, selector, timestamp string) (int64, error) {
+	now := c.now()
+	c.mutex.Lock()
+	cached, found := c.estimates[selector]
+	c.mutex.Unlock()
+	if found && now.Before(cached.expires) {
+		return cached.count, nil
+	}
+
+	resp, err := driver.Query(ctx, "count("+selector+")", timestamp, storage.JSON)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("count query failed with status %s", resp.Status)
+	}
+	var qr storage.QueryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+		return 0, err
+	}
+	// an empty result means that no series match
+	var count int64
+	if vector, ok := qr.Data.Value.(model.Vector); ok && len(vector) > 0 {
+		count = int64(vector[0].Value)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.estimates) >= maxSeriesEstimates {
+		for key, e := range c.estimates {
+			if !now.Before(e.expires) {
+				delete(c.estimates, key)
+			}
+		}
+		// still full: start over rather than growing without bounds
+		if len(c.estimates) >= maxSeriesEstimates {
+			clear(c.estimates)
+		}
+	}
+	c.estimates[selector] = seriesEstimate{count: count, expires: now.Add(c.cacheTTL)}
+	return count, nil
+}
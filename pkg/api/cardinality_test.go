@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/storage"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/test"
+)
+
+func TestSeriesBudgetCheck(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	storageMock := storage.NewMockDriver(ctrl)
+
+	now := time.Unix(1500000000, 0)
+	check := newSeriesBudgetCheck(2000, true, time.Minute)
+	check.now = func() time.Time { return now }
+
+	// the selector appears twice in the expression but is counted once, the empty result of the second selector counts as 0
+	storageMock.EXPECT().Query(gomock.Any(), `count({__name__="up",project_id="12345"})`, "", storage.JSON).DoAndReturn(
+		func(context.Context, string, string, string) (*http.Response, error) {
+			return test.HTTPResponseFromFile("fixtures/series_count.json"), nil
+		}).Times(2)
+	storageMock.EXPECT().Query(gomock.Any(), `count({__name__="down",project_id="12345"})`, "", storage.JSON).DoAndReturn(
+		func(context.Context, string, string, string) (*http.Response, error) {
+			return test.HTTPResponseFromFile("fixtures/series_count_empty.json"), nil
+		}).Times(2)
+	expression := `up{project_id="12345"} / up{project_id="12345"} or down{project_id="12345"}`
+
+	w := httptest.NewRecorder()
+	assert.NoError(t, check.Check(context.Background(), w, storageMock, expression, ""))
+	assert.Equal(t, "1500", w.Header().Get(seriesEstimateHeader))
+	assert.Empty(t, w.Header().Get("Warning"))
+
+	// cached within the TTL, even with a lower budget
+	check.maxSeries = 1000
+	w = httptest.NewRecorder()
+	assert.NoError(t, check.Check(context.Background(), w, storageMock, expression, ""))
+	assert.Equal(t, "1500", w.Header().Get(seriesEstimateHeader))
+	assert.Contains(t, w.Header().Get("Warning"), "more than the budget of 1000 series")
+
+	// counted again after the TTL, and rejected when not in warn mode
+	now = now.Add(2 * time.Minute)
+	check.warnOnly = false
+	w = httptest.NewRecorder()
+	assert.Error(t, check.Check(context.Background(), w, storageMock, expression, ""))
+	assert.Equal(t, "1500", w.Header().Get(seriesEstimateHeader))
+}
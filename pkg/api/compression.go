@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/sapcc/go-bits/logg"
+)
+
+// compressors are reused across requests since creating them is expensive (zstd in particular)
+var gzipWriterPool = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+var zstdWriterPool = sync.Pool{New: func() any {
+	w, err := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		panic(err)
+	}
+	return w
+}}
+
+// compressResponses compresses responses with zstd or gzip, if the client accepts one of them. Responses
+// which are already compressed (e.g. by the /metrics handler) are passed on unchanged.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressingResponseWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks the preferred compression method from an Accept-Encoding header (zstd before gzip)
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				q = f
+			}
+		}
+		accepted[name] = q > 0
+	}
+
+	switch {
+	case accepted["zstd"]:
+		return "zstd"
+	case accepted["gzip"], accepted["*"]:
+		return "gzip"
+	default:
+		return ""
+	}
+}
+
+// compressingResponseWriter compresses everything written to it. The decision whether to compress is
+// taken when the header is written, so that handlers can still opt out by setting Content-Encoding.
+type compressingResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	wroteHeader bool
+	// writer is nil if the response is not compressed
+	writer interface {
+		io.Writer
+		Flush() error
+		Close() error
+	}
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (cw *compressingResponseWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	h := cw.Header()
+	if h.Get("Content-Encoding") == "" && code != http.StatusNoContent && code != http.StatusNotModified {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		switch cw.encoding {
+		case "zstd":
+			zw := zstdWriterPool.Get().(*zstd.Encoder) //nolint:errcheck // the pool only contains encoders
+			zw.Reset(cw.ResponseWriter)
+			cw.writer = zw
+		default:
+			gw := gzipWriterPool.Get().(*gzip.Writer) //nolint:errcheck // the pool only contains gzip writers
+			gw.Reset(cw.ResponseWriter)
+			cw.writer = gw
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the http.ResponseWriter interface.
+func (cw *compressingResponseWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.writer == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.writer.Write(b)
+}
+
+// Flush implements the http.Flusher interface.
+func (cw *compressingResponseWriter) Flush() {
+	if cw.writer != nil {
+		if err := cw.writer.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close writes the remaining compressed data and returns the compressor to its pool
+func (cw *compressingResponseWriter) close() {
+	if cw.writer == nil {
+		return
+	}
+	if err := cw.writer.Close(); err != nil {
+		logg.Debug("Could not complete compressed response: %s", err.Error())
+	}
+	switch w := cw.writer.(type) {
+	case *zstd.Encoder:
+		w.Reset(io.Discard)
+		zstdWriterPool.Put(w)
+	case *gzip.Writer:
+		w.Reset(io.Discard)
+		gzipWriterPool.Put(w)
+	}
+	cw.writer = nil
+}
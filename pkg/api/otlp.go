@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/util"
+)
+
+// content types of OTLP/HTTP
+const (
+	otlpProtobuf = "application/x-protobuf"
+	otlpJSON     = "application/json"
+)
+
+var otlpDroppedDataPointsCounter = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "maia_otlp_dropped_data_points_count",
+	Help: "Number of OTLP data points that cannot be represented as Prometheus samples"})
+
+func init() {
+	prometheus.MustRegister(otlpDroppedDataPointsCounter)
+}
+
+// OTLPMetrics implements the OTLP/HTTP metrics receiver (POST /v1/metrics). The metrics are converted to Prometheus
+// series (see util.OTLPToWriteRequest) and written like a remote write request, i.e. their project_id and domain_id
+// labels are set from the scope of the request.
+func (g *remoteWriteGateway) OTLPMetrics(w http.ResponseWriter, req *http.Request) {
+	// OTLP clients expect the response in the encoding of the request
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || (contentType != otlpProtobuf && contentType != otlpJSON) {
+		http.Error(w, fmt.Sprintf("unsupported content type %q: OTLP metrics must be sent as %s or %s",
+			req.Header.Get("Content-Type"), otlpProtobuf, otlpJSON), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		returnOTLPError(w, contentType, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := readOTLPBody(w, req)
+	if err != nil {
+		returnOTLPError(w, contentType, err, http.StatusBadRequest)
+		return
+	}
+	// MetricsData is encoded like the ExportMetricsServiceRequest of OTLP/HTTP
+	var metrics metricspb.MetricsData
+	if contentType == otlpJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, &metrics)
+	} else {
+		err = proto.Unmarshal(body, &metrics)
+	}
+	if err != nil {
+		returnOTLPError(w, contentType, fmt.Errorf("invalid OTLP metrics request: %w", err), http.StatusBadRequest)
+		return
+	}
+
+	request, dropped := util.OTLPToWriteRequest(&metrics)
+	otlpDroppedDataPointsCounter.Add(float64(dropped))
+	if len(request.Timeseries) > 0 {
+		resp, err := g.push(req, ks, &request)
+		if err != nil {
+			var rejected rejectedWriteError
+			if errors.As(err, &rejected) {
+				returnOTLPError(w, contentType, err, http.StatusBadRequest)
+			} else {
+				returnOTLPError(w, contentType, err, http.StatusServiceUnavailable)
+			}
+			return
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			// the status code tells the client whether to retry
+			message, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			if err != nil || len(message) == 0 {
+				message = []byte(resp.Status)
+			}
+			returnOTLPError(w, contentType, fmt.Errorf("remote write target rejected the metrics: %s", strings.TrimSpace(string(message))), resp.StatusCode)
+			return
+		}
+	}
+
+	var message string
+	if dropped > 0 {
+		message = fmt.Sprintf("%d data points with delta temporality or of exponential histograms cannot be stored as Prometheus samples", dropped)
+	}
+	returnOTLPResponse(w, contentType, dropped, message)
+}
+
+// readOTLPBody reads the (optionally gzip-compressed) body of an OTLP request up to maxRemoteWriteRequestSize
+func readOTLPBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	var reader io.Reader = http.MaxBytesReader(w, req.Body, maxRemoteWriteRequestSize)
+	switch req.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip-compressed OTLP request: %w", err)
+		}
+		defer gz.Close()
+		reader = io.LimitReader(gz, maxRemoteWriteRequestSize+1)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", req.Header.Get("Content-Encoding"))
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxRemoteWriteRequestSize {
+		return nil, errors.New("oversized OTLP request")
+	}
+	return body, nil
+}
+
+// returnOTLPResponse writes an ExportMetricsServiceResponse, with a partial success if data points were rejected
+func returnOTLPResponse(w http.ResponseWriter, contentType string, rejected int64, message string) {
+	if contentType == otlpJSON {
+		response := map[string]any{}
+		if rejected > 0 {
+			// int64 fields are encoded as strings in the JSON mapping of protobuf
+			response["partialSuccess"] = map[string]any{"rejectedDataPoints": strconv.FormatInt(rejected, 10), "errorMessage": message}
+		}
+		ReturnJSON(w, http.StatusOK, response)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if rejected == 0 {
+		return
+	}
+	// ExportMetricsServiceResponse { ExportMetricsPartialSuccess partial_success = 1; }
+	// ExportMetricsPartialSuccess { int64 rejected_data_points = 1; string error_message = 2; }
+	var partialSuccess []byte
+	partialSuccess = protowire.AppendTag(partialSuccess, 1, protowire.VarintType)
+	partialSuccess = protowire.AppendVarint(partialSuccess, uint64(rejected)) //nolint:gosec // counts are not negative
+	partialSuccess = protowire.AppendTag(partialSuccess, 2, protowire.BytesType)
+	partialSuccess = protowire.AppendString(partialSuccess, message)
+	response := protowire.AppendTag(nil, 1, protowire.BytesType)
+	response = protowire.AppendBytes(response, partialSuccess)
+	if _, err := w.Write(response); err != nil {
+		logg.Error("Could not write OTLP response: %s", err.Error())
+	}
+}
+
+// returnOTLPError reports an error as google.rpc.Status, like OTLP/HTTP requires
+func returnOTLPError(w http.ResponseWriter, contentType string, err error, httpCode int) {
+	if httpCode >= 500 {
+		logg.Error(err.Error())
+	}
+	rpcCode := code.Code_INTERNAL
+	switch httpCode {
+	case http.StatusBadRequest:
+		rpcCode = code.Code_INVALID_ARGUMENT
+	case http.StatusTooManyRequests:
+		rpcCode = code.Code_RESOURCE_EXHAUSTED
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		rpcCode = code.Code_UNAVAILABLE
+	}
+	st := &status.Status{Code: int32(rpcCode), Message: err.Error()}
+
+	var body []byte
+	if contentType == otlpJSON {
+		body, err = protojson.Marshal(st)
+	} else {
+		body, err = proto.Marshal(st)
+	}
+	if err != nil {
+		http.Error(w, st.GetMessage(), httpCode)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(httpCode)
+	if _, err := w.Write(body); err != nil {
+		logg.Error("Could not write OTLP response: %s", err.Error())
+	}
+}
@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/test"
+)
+
+// otlpGauge creates OTLP metrics with a single gauge data point and the given resource attributes
+func otlpGauge(resourceAttributes ...*commonpb.KeyValue) *metricspb.MetricsData {
+	return &metricspb.MetricsData{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: resourceAttributes},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+			{Name: "backup.age", Unit: "s", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{
+				Attributes: []*commonpb.KeyValue{
+					{Key: "vm", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "db1"}}},
+					{Key: "project_id", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "99999"}}},
+				},
+				TimeUnixNano: 1500000000 * 1e9,
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 3600},
+			}}}}},
+		}}},
+	}}}
+}
+
+func otlpRequest(router http.Handler, authorization, contentType string, body []byte, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString([]byte(authorization)))
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func marshalOTLP(t *testing.T, metrics *metricspb.MetricsData) []byte {
+	body, err := proto.Marshal(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestOTLPMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, remoteWriteMock := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	forwarded := expectWrite(t, remoteWriteMock)
+
+	// the project_id attribute is overwritten, the domain_id is added
+	recorder := otlpRequest(router, "Basic user_id|12345:password", otlpProtobuf, marshalOTLP(t, otlpGauge()), nil)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, otlpProtobuf, recorder.Header().Get("Content-Type"))
+	assert.Empty(t, recorder.Body.Bytes())
+	assert.Equal(t, []prompb.TimeSeries{{
+		Labels: []prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "domain_id", Value: "77777"},
+			{Name: "project_id", Value: "12345"}, {Name: "vm", Value: "db1"}},
+		Samples: []prompb.Sample{{Value: 3600, Timestamp: 1500000000000}},
+	}}, *forwarded)
+}
+
+func TestOTLPMetrics_jsonPartialSuccess(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, remoteWriteMock := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	forwarded := expectWrite(t, remoteWriteMock)
+
+	// data points with delta temporality are dropped and reported as partial success
+	metrics := otlpGauge()
+	metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics = append(metrics.ResourceMetrics[0].ScopeMetrics[0].Metrics,
+		&metricspb.Metric{Name: "requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+			DataPoints:             []*metricspb.NumberDataPoint{{TimeUnixNano: 1500000000 * 1e9}},
+		}}})
+	encoded, err := protojson.Marshal(metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err = gz.Write(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := otlpRequest(router, "Basic user_id|12345:password", otlpJSON, compressed.Bytes(), map[string]string{"Content-Encoding": "gzip"})
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"partialSuccess":{"rejectedDataPoints":"1","errorMessage":"1 data points with delta temporality or of exponential histograms cannot be stored as Prometheus samples"}}`,
+		recorder.Body.String())
+	assert.Len(t, *forwarded, 1)
+}
+
+func TestOTLPMetrics_errorReservedLabel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, _ := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	// the job label derived from service.name is reserved in this test
+	expectWriteAuthByProjectID(keystoneMock)
+	metrics := otlpGauge(&commonpb.KeyValue{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "backup"}}})
+	recorder := otlpRequest(router, "Basic user_id|12345:password", otlpProtobuf, marshalOTLP(t, metrics), nil)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	var st status.Status
+	assert.NoError(t, proto.Unmarshal(recorder.Body.Bytes(), &st))
+	assert.Equal(t, int32(code.Code_INVALID_ARGUMENT), st.GetCode())
+	assert.Contains(t, st.GetMessage(), `reserved label "job"`)
+}
+
+func TestOTLPMetrics_errorTarget(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, remoteWriteMock := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	remoteWriteMock.EXPECT().Write(test.MatchContext(), gomock.Any()).Return(nil, errors.New("connection refused"))
+
+	// clients retry when the target is unavailable
+	recorder := otlpRequest(router, "Basic user_id|12345:password", otlpJSON, []byte(protojson.Format(otlpGauge())), nil)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	var st status.Status
+	assert.NoError(t, protojson.Unmarshal(recorder.Body.Bytes(), &st))
+	assert.Equal(t, int32(code.Code_UNAVAILABLE), st.GetCode())
+}
+
+func TestOTLPMetrics_errorContentType(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, _ := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	recorder := otlpRequest(router, "Basic user_id|12345:password", "text/plain", []byte("backup_age_seconds 3600"), nil)
+	assert.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+}
+
+func TestOTLPMetrics_failAuthorization(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, _ := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	// viewers must not write metrics
+	expectWriteAuth(keystoneMock, projectContext)
+	recorder := otlpRequest(router, "Basic user_id|12345:password", otlpProtobuf, marshalOTLP(t, otlpGauge()), nil)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	promstorage "github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/util"
+)
+
+// maxBytesInFrame is the size of the frames of streamed remote read responses (like the default of Prometheus)
+const maxBytesInFrame = 1 << 20
+
+// remoteReadUnion answers a remote read request restricted to several scope labels. Since the matchers of a query
+// cannot be OR-combined, the request is sent once per label and the series of the responses are merged. The storage
+// is asked for sampled responses, which can be decoded, and the result is returned in the response type negotiated
+// with the client.
+func (p *v1Provider) remoteReadUnion(w http.ResponseWriter, req *http.Request, request *prompb.ReadRequest, constraints []util.LabelConstraint) {
+	responseType, err := remote.NegotiateResponseType(request.AcceptedResponseTypes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*prompb.QueryResult, len(request.Queries))
+	seen := make([]map[string]bool, len(request.Queries))
+	for i := range results {
+		results[i] = &prompb.QueryResult{}
+		seen[i] = map[string]bool{}
+	}
+	b := labels.NewScratchBuilder(0)
+	for _, c := range constraints {
+		subRequest := prompb.ReadRequest{AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_SAMPLES}}
+		for _, query := range request.Queries {
+			q := *query
+			q.Matchers = slices.Clone(query.Matchers)
+			subRequest.Queries = append(subRequest.Queries, &q)
+		}
+		if err := util.AddLabelConstraintToReadRequest(&subRequest, c.Key, c.Values); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		encoded, err := subRequest.Marshal()
+		if err != nil {
+			ReturnPromError(w, err, http.StatusInternalServerError)
+			return
+		}
+		resp, err := p.storage.RemoteRead(req.Context(), snappy.Encode(nil, encoded))
+		if err != nil {
+			ReturnStorageError(w, err, http.StatusServiceUnavailable)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			ReturnResponse(w, resp)
+			return
+		}
+		response, err := decodeReadResponse(resp, len(subRequest.Queries))
+		if err != nil {
+			ReturnStorageError(w, err, http.StatusBadGateway)
+			return
+		}
+		for i, result := range response.Results {
+			// series carrying several of the labels are returned for each of them
+			for _, ts := range result.Timeseries {
+				key := ts.ToLabels(&b, nil).String()
+				if !seen[i][key] {
+					seen[i][key] = true
+					results[i].Timeseries = append(results[i].Timeseries, ts)
+				}
+			}
+		}
+	}
+
+	if responseType == prompb.ReadRequest_SAMPLES {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Header().Set("Content-Encoding", "snappy")
+		w.WriteHeader(http.StatusOK)
+		if err := remote.EncodeReadResponse(&prompb.ReadResponse{Results: results}, w); err != nil {
+			logg.Info("Could not write remote read response: %s", err.Error())
+		}
+		return
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported by the response writer", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-streamed-protobuf; proto=prometheus.ChunkedReadResponse")
+	w.WriteHeader(http.StatusOK)
+	stream := remote.NewChunkedWriter(w, f)
+	marshalPool := &sync.Pool{}
+	for i, result := range results {
+		series := promstorage.NewSeriesSetToChunkSet(remote.FromQueryResult(true, result))
+		if _, err := remote.StreamChunkedReadResponses(stream, int64(i), series, nil, maxBytesInFrame, marshalPool); err != nil {
+			logg.Info("Could not stream remote read response: %s", err.Error())
+			return
+		}
+	}
+}
+
+// decodeReadResponse decodes a sampled remote read response with a result for each of the queries
+func decodeReadResponse(resp *http.Response, queries int) (*prompb.ReadResponse, error) {
+	defer resp.Body.Close()
+	compressed, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode the remote read response: %w", err)
+	}
+	var response prompb.ReadResponse
+	if err := response.Unmarshal(decoded); err != nil {
+		return nil, fmt.Errorf("cannot decode the remote read response: %w", err)
+	}
+	if len(response.Results) != queries {
+		return nil, errors.New("the remote read response does not contain a result for every query")
+	}
+	return &response, nil
+}
@@ -0,0 +1,381 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/viper"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/keystone"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/remotewrite"
+)
+
+// maxRemoteWriteRequestSize limits the size of a decompressed remote write request
+const maxRemoteWriteRequestSize = 16 << 20
+
+var remoteWriteRejectedSeriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "maia_remote_write_rejected_series_count",
+	Help: "Number of series in rejected remote write requests"},
+	[]string{"reason"})
+
+func init() {
+	prometheus.MustRegister(remoteWriteRejectedSeriesCounter)
+}
+
+// remoteWrite is the remote write gateway behind /api/v1/write. It is nil when no remote write target is configured.
+var remoteWrite *remoteWriteGateway
+
+// remoteWriteGateway accepts remote write requests of tenants, ties their series to the project or domain of the
+// request and forwards them to the remote write target.
+type remoteWriteGateway struct {
+	target remotewrite.Driver
+	// validate rejects series with project_id or domain_id labels outside of the scope instead of overwriting them
+	validate       bool
+	reservedLabels []string
+	// limiter is nil when the number of series is not limited
+	limiter *seriesLimiter
+}
+
+// remoteWriteFromConfig reads the gateway settings from the [maia.remote_write] section
+func remoteWriteFromConfig() *remoteWriteGateway {
+	if viper.GetString("maia.remote_write.url") == "" {
+		return nil
+	}
+
+	mode := viper.GetString("maia.remote_write.label_mode")
+	if mode == "" {
+		mode = "overwrite"
+	}
+	if mode != "overwrite" && mode != "validate" {
+		panic(fmt.Errorf("invalid maia.remote_write.label_mode setting %q: must be overwrite or validate", mode))
+	}
+	ttl := time.Hour
+	if s := viper.GetString("maia.remote_write.series_ttl"); s != "" {
+		d, err := model.ParseDuration(s)
+		if err != nil {
+			panic(fmt.Errorf("invalid maia.remote_write.series_ttl setting: %w", err))
+		}
+		ttl = time.Duration(d)
+	}
+	maxSeries := viper.GetInt("maia.remote_write.max_series_per_project")
+	reservedLabels := viper.GetStringSlice("maia.remote_write.reserved_labels")
+
+	logg.Info("Remote write gateway: label_mode=%s reserved_labels=%v max_series_per_project=%d series_ttl=%s",
+		mode, reservedLabels, maxSeries, model.Duration(ttl))
+	return newRemoteWriteGateway(remotewrite.NewRemoteWriteDriver(), mode == "validate", reservedLabels, maxSeries, ttl)
+}
+
+func newRemoteWriteGateway(target remotewrite.Driver, validate bool, reservedLabels []string, maxSeries int, seriesTTL time.Duration) *remoteWriteGateway {
+	g := &remoteWriteGateway{target: target, validate: validate, reservedLabels: reservedLabels}
+	if maxSeries > 0 {
+		g.limiter = newSeriesLimiter(maxSeries, seriesTTL)
+	}
+	return g
+}
+
+// writeScope holds the label values that a remote write request may use
+type writeScope struct {
+	projectID string
+	domainID  string
+	// projects lists the values accepted for the project_id label
+	projects []string
+}
+
+// scope determines the labels of the series of a request: for project scope, the project and its domain; for domain
+// scope, the domain and, optionally, one of its projects. The global visibility sentinel is never accepted.
+func (g *remoteWriteGateway) scope(req *http.Request, keystoneDriver keystone.Driver) writeScope {
+	ctx := req.Context()
+	if projectID := req.Header.Get("X-Project-Id"); projectID != "" {
+		scope := writeScope{projectID: projectID, domainID: req.Header.Get("X-Project-Domain-Id"), projects: []string{projectID}}
+		if g.validate {
+			children, err := keystoneDriver.ChildProjects(ctx, projectID)
+			if err != nil {
+				panic(err)
+			}
+			scope.projects = append(scope.projects, children...)
+		}
+		return scope
+	}
+	domainID := req.Header.Get("X-Domain-Id")
+	if domainID == "" {
+		panic(errors.New("missing OpenStack scope attributes in request header"))
+	}
+	children, err := keystoneDriver.ChildProjects(ctx, domainID)
+	if err != nil {
+		panic(err)
+	}
+	return writeScope{domainID: domainID, projects: children}
+}
+
+// Write implements the remote write API of Prometheus (version 1.0). The project_id and domain_id labels of every
+// series are set or checked according to the scope of the request, and the number of series per project is limited,
+// before the request is forwarded to the remote write target.
+func (g *remoteWriteGateway) Write(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		http.Error(w, "keystone context not available", http.StatusInternalServerError)
+		return
+	}
+
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		_, params, err := mime.ParseMediaType(contentType)
+		if err == nil && params["proto"] != "" && params["proto"] != "prometheus.WriteRequest" {
+			http.Error(w, fmt.Sprintf("unsupported remote write message %q: only prometheus.WriteRequest (remote write 1.0) is accepted", params["proto"]),
+				http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+
+	compressed, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRemoteWriteRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n, err := snappy.DecodedLen(compressed); err != nil || n > maxRemoteWriteRequestSize {
+		http.Error(w, "invalid or oversized snappy-compressed remote write request", http.StatusBadRequest)
+		return
+	}
+	decoded, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var request prompb.WriteRequest
+	if err := request.Unmarshal(decoded); err != nil {
+		http.Error(w, fmt.Sprintf("invalid remote write request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := g.push(req, ks, &request)
+	if err != nil {
+		var rejected rejectedWriteError
+		if errors.As(err, &rejected) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			ReturnStorageError(w, err, http.StatusServiceUnavailable)
+		}
+		return
+	}
+	ReturnResponse(w, resp)
+}
+
+// rejectedWriteError explains why the series of a write request must not be written. It is reported with 400 Bad
+// Request, which clients do not retry.
+type rejectedWriteError struct {
+	error
+}
+
+// push scopes the series of a write request, limits their number and forwards the request to the remote write
+// target. Series that must not be written are reported as rejectedWriteError, all other errors come from the target.
+func (g *remoteWriteGateway) push(req *http.Request, keystoneDriver keystone.Driver, request *prompb.WriteRequest) (*http.Response, error) {
+	scope := g.scope(req, keystoneDriver)
+	series := make(map[string][]uint64)
+	var builder labels.ScratchBuilder
+	for i := range request.Timeseries {
+		ts := &request.Timeseries[i]
+		if reason, err := g.scopeSeries(ts, scope); err != nil {
+			remoteWriteRejectedSeriesCounter.WithLabelValues(reason).Add(float64(len(request.Timeseries)))
+			return nil, rejectedWriteError{err}
+		}
+		lset := ts.ToLabels(&builder, nil)
+		tenant := lset.Get(scopeLabels.project[0])
+		if tenant == "" {
+			tenant = lset.Get(scopeLabels.domain[0])
+		}
+		series[tenant] = append(series[tenant], lset.Hash())
+	}
+	rollback := func() {}
+	if g.limiter != nil {
+		var err error
+		rollback, err = g.limiter.admit(series)
+		if err != nil {
+			remoteWriteRejectedSeriesCounter.WithLabelValues("series_limit").Add(float64(len(request.Timeseries)))
+			return nil, rejectedWriteError{err}
+		}
+	}
+
+	encoded, err := request.Marshal()
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	resp, err := g.target.Write(req.Context(), snappy.Encode(nil, encoded))
+	if err != nil {
+		rollback()
+		return nil, err
+	}
+	// series count towards the limit only when the target has accepted them
+	if resp.StatusCode/100 != 2 {
+		rollback()
+	}
+	return resp, nil
+}
+
+// scopeSeries rejects reserved labels and sets or checks the project_id and domain_id labels of a series. On error,
+// the reason for the rejection is returned as well. The first of the configured project and domain labels is always
+// set, further ones are only checked or overwritten when the series carries them, since they make a series visible
+// in a scope just like the first one.
+func (g *remoteWriteGateway) scopeSeries(ts *prompb.TimeSeries, scope writeScope) (string, error) {
+	projectLabels := map[string]string{scopeLabels.project[0]: ""}
+	domainLabels := map[string]string{scopeLabels.domain[0]: ""}
+	rest := ts.Labels[:0]
+	for _, l := range ts.Labels {
+		switch {
+		case slices.Contains(scopeLabels.project, l.Name):
+			projectLabels[l.Name] = l.Value
+		case slices.Contains(scopeLabels.domain, l.Name):
+			domainLabels[l.Name] = l.Value
+		case strings.HasPrefix(l.Name, "__") && l.Name != model.MetricNameLabel, slices.Contains(g.reservedLabels, l.Name):
+			return "reserved_label", fmt.Errorf("series %s uses the reserved label %q", seriesName(ts), l.Name)
+		default:
+			rest = append(rest, l)
+		}
+	}
+
+	for name, projectID := range projectLabels {
+		switch {
+		case projectID == "" || (!g.validate && scope.projectID != ""):
+			projectID = scope.projectID
+		case !slices.Contains(scope.projects, projectID):
+			return "scope", fmt.Errorf("series %s has %s %q outside of your scope", seriesName(ts), name, projectID)
+		}
+		if projectID != "" {
+			rest = append(rest, prompb.Label{Name: name, Value: projectID})
+		}
+	}
+	for name, domainID := range domainLabels {
+		switch {
+		case domainID == "" || !g.validate:
+			domainID = scope.domainID
+		case domainID != scope.domainID:
+			return "scope", fmt.Errorf("series %s has %s %q outside of your scope", seriesName(ts), name, domainID)
+		}
+		if domainID != "" {
+			rest = append(rest, prompb.Label{Name: name, Value: domainID})
+		}
+	}
+	// remote write receivers expect sorted labels
+	slices.SortFunc(rest, func(a, b prompb.Label) int { return strings.Compare(a.Name, b.Name) })
+	ts.Labels = rest
+	return "", nil
+}
+
+// seriesName returns the metric name of a series for error messages
+func seriesName(ts *prompb.TimeSeries) string {
+	for _, l := range ts.Labels {
+		if l.Name == model.MetricNameLabel {
+			return l.Value
+		}
+	}
+	return "{}"
+}
+
+// seriesLimiter limits the number of active series per project. Series are active until they have not been written
+// for the TTL.
+type seriesLimiter struct {
+	maxSeries int
+	ttl       time.Duration
+
+	mutex  sync.Mutex
+	series map[string]map[uint64]time.Time
+	now    func() time.Time
+}
+
+func newSeriesLimiter(maxSeries int, ttl time.Duration) *seriesLimiter {
+	return &seriesLimiter{
+		maxSeries: maxSeries,
+		ttl:       ttl,
+		series:    make(map[string]map[uint64]time.Time),
+		now:       time.Now,
+	}
+}
+
+// admit records the series hashes of a request per project. If a project would exceed the limit, nothing is
+// recorded and an error is returned. Otherwise, the returned function undoes the recording, so that series which
+// could not be written do not count towards the limit.
+func (l *seriesLimiter) admit(series map[string][]uint64) (rollback func(), err error) {
+	now := l.now()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for tenant, hashes := range series {
+		active := l.series[tenant]
+		if len(active)+l.countNew(active, hashes) <= l.maxSeries {
+			continue
+		}
+		// only clean up when close to the limit
+		for h, lastSeen := range active {
+			if now.Sub(lastSeen) > l.ttl {
+				delete(active, h)
+			}
+		}
+		if len(active)+l.countNew(active, hashes) > l.maxSeries {
+			return nil, fmt.Errorf("the request would exceed the limit of %d active series for project %s", l.maxSeries, tenant)
+		}
+	}
+
+	// the previous state of the recorded series, where a zero time marks a new series
+	previous := make(map[string]map[uint64]time.Time, len(series))
+	for tenant, hashes := range series {
+		active := l.series[tenant]
+		if active == nil {
+			active = make(map[uint64]time.Time)
+			l.series[tenant] = active
+		}
+		previous[tenant] = make(map[uint64]time.Time, len(hashes))
+		for _, h := range hashes {
+			if _, ok := previous[tenant][h]; !ok {
+				previous[tenant][h] = active[h]
+			}
+			active[h] = now
+		}
+	}
+
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		for tenant, hashes := range previous {
+			active := l.series[tenant]
+			for h, lastSeen := range hashes {
+				switch {
+				case active[h] != now:
+					// written again in the meantime
+				case lastSeen.IsZero():
+					delete(active, h)
+				default:
+					active[h] = lastSeen
+				}
+			}
+			if len(active) == 0 {
+				delete(l.series, tenant)
+			}
+		}
+	}, nil
+}
+
+// countNew counts the distinct hashes that are not active yet
+func (l *seriesLimiter) countNew(active map[uint64]time.Time, hashes []uint64) int {
+	seen := make(map[uint64]bool, len(hashes))
+	for _, h := range hashes {
+		if _, ok := active[h]; !ok {
+			seen[h] = true
+		}
+	}
+	return len(seen)
+}
@@ -0,0 +1,266 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	policy "github.com/databus23/goslo.policy"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/keystone"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/remotewrite"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/test"
+)
+
+func setupRemoteWriteTest(t *testing.T, ctrl *gomock.Controller, validate bool, maxSeries int) (http.Handler, *keystone.MockDriver, *remotewrite.MockDriver) {
+	_, keystoneMock, storageMock := setupTest(t, ctrl)
+	remoteWriteMock := remotewrite.NewMockDriver(ctrl)
+	remoteWrite = newRemoteWriteGateway(remoteWriteMock, validate, []string{"job"}, maxSeries, time.Hour)
+
+	// the router of setupTest has no remote write gateway, so build another one with fresh metrics
+	prometheus.DefaultRegisterer = prometheus.NewPedanticRegistry()
+	return setupRouter(keystoneMock, nil, storageMock), keystoneMock, remoteWriteMock
+}
+
+func expectWriteAuthByProjectID(keystoneMock *keystone.MockDriver) {
+	expectWriteAuth(keystoneMock, projectAdminContext)
+}
+
+func expectWriteAuth(keystoneMock *keystone.MockDriver, policyContext *policy.Context) {
+	header := maps.Clone(projectHeader)
+	header["X-Project-Domain-Id"] = projectContext.Auth["project_domain_id"]
+	keystoneMock.EXPECT().AuthenticateRequest(test.MatchContext(), test.HTTPRequestMatcher{InjectHeader: header}, false).Return(policyContext, nil)
+}
+
+func expectWriteAuthByDomainName(keystoneMock *keystone.MockDriver) {
+	keystoneMock.EXPECT().AuthenticateRequest(test.MatchContext(), test.HTTPRequestMatcher{InjectHeader: domainHeader}, false).Return(domainAdminContext, nil)
+}
+
+func writeRequest(t *testing.T, router http.Handler, authorization string, series ...[]prompb.Label) *httptest.ResponseRecorder {
+	request := prompb.WriteRequest{}
+	for _, lbls := range series {
+		request.Timeseries = append(request.Timeseries, prompb.TimeSeries{Labels: lbls, Samples: []prompb.Sample{{Value: 1, Timestamp: 1500000000000}}})
+	}
+	encoded, err := request.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(snappy.Encode(nil, encoded)))
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString([]byte(authorization)))
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// expectWrite expects a forwarded request and returns the series of it
+func expectWrite(t *testing.T, remoteWriteMock *remotewrite.MockDriver) *[]prompb.TimeSeries {
+	var forwarded []prompb.TimeSeries
+	remoteWriteMock.EXPECT().Write(test.MatchContext(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, body []byte) (*http.Response, error) {
+			decoded, err := snappy.Decode(nil, body)
+			assert.NoError(t, err)
+			var request prompb.WriteRequest
+			assert.NoError(t, request.Unmarshal(decoded))
+			forwarded = request.Timeseries
+			return &http.Response{StatusCode: http.StatusNoContent, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+		})
+	return &forwarded
+}
+
+func TestRemoteWrite(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, remoteWriteMock := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	forwarded := expectWrite(t, remoteWriteMock)
+
+	// the project_id is overwritten, the domain_id is added
+	recorder := writeRequest(t, router, "Basic user_id|12345:password",
+		[]prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "project_id", Value: "99999"}, {Name: "vm", Value: "db1"}})
+
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, []prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "domain_id", Value: "77777"},
+		{Name: "project_id", Value: "12345"}, {Name: "vm", Value: "db1"}}, (*forwarded)[0].Labels)
+}
+
+func TestRemoteWrite_errorReservedLabel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, _ := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	recorder := writeRequest(t, router, "Basic user_id|12345:password",
+		[]prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "job", Value: "prometheus"}})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `reserved label "job"`)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	recorder = writeRequest(t, router, "Basic user_id|12345:password",
+		[]prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "__tmp", Value: "x"}})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestRemoteWrite_validateDomainScope(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, remoteWriteMock := setupRemoteWriteTest(t, ctrl, true, 0)
+
+	// series of projects within the domain are accepted
+	expectWriteAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{"12345"}, nil)
+	forwarded := expectWrite(t, remoteWriteMock)
+	recorder := writeRequest(t, router, "Basic u12345|@77777:password",
+		[]prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "project_id", Value: "12345"}},
+		[]prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}})
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, []prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "domain_id", Value: "77777"},
+		{Name: "project_id", Value: "12345"}}, (*forwarded)[0].Labels)
+	assert.Equal(t, []prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "domain_id", Value: "77777"}}, (*forwarded)[1].Labels)
+
+	// other projects and domains are not
+	expectWriteAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{"12345"}, nil)
+	recorder = writeRequest(t, router, "Basic u12345|@77777:password",
+		[]prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "project_id", Value: "99999"}})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `project_id "99999" outside of your scope`)
+
+	expectWriteAuthByDomainName(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "77777").Return([]string{"12345"}, nil)
+	recorder = writeRequest(t, router, "Basic u12345|@77777:password",
+		[]prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "domain_id", Value: "88888"}})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestRemoteWrite_errorSeriesLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, remoteWriteMock := setupRemoteWriteTest(t, ctrl, false, 1)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	expectWrite(t, remoteWriteMock)
+	recorder := writeRequest(t, router, "Basic user_id|12345:password", []prompb.Label{{Name: "__name__", Value: "a"}})
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	recorder = writeRequest(t, router, "Basic user_id|12345:password", []prompb.Label{{Name: "__name__", Value: "b"}})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "limit of 1 active series for project 12345")
+}
+
+func TestRemoteWrite_failAuthorization(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, _ := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	// viewers can read, but not write metrics
+	expectWriteAuth(keystoneMock, projectContext)
+	recorder := writeRequest(t, router, "Basic user_id|12345:password", []prompb.Label{{Name: "__name__", Value: "a"}})
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+}
+
+func TestRemoteWrite_seriesLimitAfterFailedWrite(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, remoteWriteMock := setupRemoteWriteTest(t, ctrl, false, 1)
+
+	// series rejected by the target do not count towards the limit
+	expectWriteAuthByProjectID(keystoneMock)
+	remoteWriteMock.EXPECT().Write(test.MatchContext(), gomock.Any()).Return(
+		&http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("down"))}, nil)
+	recorder := writeRequest(t, router, "Basic user_id|12345:password", []prompb.Label{{Name: "__name__", Value: "a"}})
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	remoteWriteMock.EXPECT().Write(test.MatchContext(), gomock.Any()).Return(nil, errors.New("connection refused"))
+	recorder = writeRequest(t, router, "Basic user_id|12345:password", []prompb.Label{{Name: "__name__", Value: "b"}})
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	expectWrite(t, remoteWriteMock)
+	recorder = writeRequest(t, router, "Basic user_id|12345:password", []prompb.Label{{Name: "__name__", Value: "c"}})
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+}
+
+func TestRemoteWrite_errorVersion2(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, _ := setupRemoteWriteTest(t, ctrl, false, 0)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", strings.NewReader(""))
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")))
+	req.Header.Set("Content-Type", "application/x-protobuf;proto=io.prometheus.write.v2.Request")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, recorder.Code)
+}
+
+func TestSeriesLimiter(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	limiter := newSeriesLimiter(2, time.Hour)
+	limiter.now = func() time.Time { return now }
+
+	admit := func(series map[string][]uint64) error {
+		_, err := limiter.admit(series)
+		return err
+	}
+	assert.NoError(t, admit(map[string][]uint64{"a": {1, 1, 2}, "b": {1}}))
+	// known series are always accepted
+	assert.NoError(t, admit(map[string][]uint64{"a": {1, 2}}))
+	assert.Error(t, admit(map[string][]uint64{"a": {3}, "b": {2}}))
+	// a rejected request is not recorded for any project
+	assert.NoError(t, admit(map[string][]uint64{"b": {2}}))
+
+	// series expire after the TTL
+	now = now.Add(30 * time.Minute)
+	assert.NoError(t, admit(map[string][]uint64{"a": {1}}))
+	now = now.Add(45 * time.Minute)
+	assert.NoError(t, admit(map[string][]uint64{"a": {3}}))
+	assert.Error(t, admit(map[string][]uint64{"a": {4}}))
+
+	// a rollback forgets new series and restores the expiry of known series
+	now = now.Add(time.Minute)
+	rollback, err := limiter.admit(map[string][]uint64{"c": {1, 2}, "a": {3}})
+	assert.NoError(t, err)
+	rollback()
+	_, found := limiter.series["c"]
+	assert.False(t, found)
+	assert.Equal(t, now.Add(-time.Minute), limiter.series["a"][3])
+}
+
+func TestRemoteWrite_scopeLabels(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, remoteWriteMock := setupRemoteWriteTest(t, ctrl, true, 0)
+	scopeLabels = tenancyLabels{project: []string{"project_id", "tenant_id"}, domain: []string{"domain_id"}}
+
+	// the first label is always set, the others are checked when present
+	expectWriteAuthByProjectID(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "12345").Return([]string{}, nil)
+	forwarded := expectWrite(t, remoteWriteMock)
+	recorder := writeRequest(t, router, "Basic user_id|12345:password",
+		[]prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "tenant_id", Value: "12345"}})
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+	assert.Equal(t, []prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "domain_id", Value: "77777"},
+		{Name: "project_id", Value: "12345"}, {Name: "tenant_id", Value: "12345"}}, (*forwarded)[0].Labels)
+
+	expectWriteAuthByProjectID(keystoneMock)
+	keystoneMock.EXPECT().ChildProjects(test.MatchContext(), "12345").Return([]string{}, nil)
+	recorder = writeRequest(t, router, "Basic user_id|12345:password",
+		[]prompb.Label{{Name: "__name__", Value: "backup_age_seconds"}, {Name: "tenant_id", Value: "99999"}})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `tenant_id "99999" outside of your scope`)
+}
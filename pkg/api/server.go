@@ -14,32 +14,50 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
 	"github.com/rs/cors"
 	"github.com/spf13/viper"
 
 	"github.com/sapcc/go-bits/logg"
 
+	"github.com/SAP-cloud-infrastructure/maia/pkg/alertmanager"
 	"github.com/SAP-cloud-infrastructure/maia/pkg/keystone"
 	"github.com/SAP-cloud-infrastructure/maia/pkg/storage"
 	"github.com/SAP-cloud-infrastructure/maia/pkg/ui"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/util"
 )
 
 var storageInstance storage.Driver
 var keystoneInstance keystone.Driver
 var globalKeystoneInstance keystone.Driver
 
+// alertmanagerInstance is the Alertmanager behind the /alertmanager/api/v2 proxy, nil when not configured
+var alertmanagerInstance alertmanager.Driver
+
 // sentinelValue is the configured global visibility sentinel, resolved once at
 // startup. When non-empty, it is appended to project_id/domain_id scope
 // constraints so that metrics carrying this value are visible to all tenants.
 var sentinelValue string
 
+// queryLimits are the guardrails applied to the PromQL queries and selectors of the tenants, resolved once at startup
+var queryLimits util.TenantQueryLimits
+
+// scopeLabels are the names of the labels that assign a series to a project or domain, resolved once at startup
+var scopeLabels = defaultScopeLabels
+
+// domainScopeProjects makes the series of all projects within a domain visible in the scope of the domain, in addition
+// to the series labelled with the domain itself
+var domainScopeProjects bool
+
 // Server initializes and starts the API server, hooking it up to the API router
 func Server(ctx context.Context) error {
 	prometheusAPIURL := viper.GetString("maia.prometheus_url")
-	if prometheusAPIURL == "" {
+	if prometheusAPIURL == "" && viper.GetString("maia.storage_driver") == "prometheus" {
 		panic(errors.New("prometheus endpoint not configured (maia.prometheus_url / MAIA_PROMETHEUS_URL)"))
 	}
 
@@ -63,8 +81,29 @@ func Server(ctx context.Context) error {
 		logg.Info("Global metric visibility sentinel configured: %q (appended to project_id/domain_id scope constraints)", sentinelValue)
 	}
 
+	if mode := viper.GetString("maia.label_values_mode"); mode != "" && mode != "auto" && mode != "native" && mode != "query" {
+		panic(fmt.Errorf("invalid maia.label_values_mode setting %q: must be auto, native or query", mode))
+	}
+
+	queryLimits = queryLimitsFromConfig()
+	scopeLabels = scopeLabelsFromConfig()
+	domainScopeProjects = viper.GetBool("maia.domain_scope_projects")
+	seriesBudget = seriesBudgetFromConfig()
+	scopeVerification = scopeVerificationFromConfig()
+	remoteWrite = remoteWriteFromConfig()
+
+	if viper.IsSet("maia.alertmanager.url") {
+		alertmanagerInstance = alertmanager.NewAlertmanagerDriver()
+	}
+
 	// The main router dispatches all incoming requests
-	mainRouter := setupRouter(keystoneDriver, globalKeystone, storage.NewPrometheusDriver(prometheusAPIURL, map[string]string{}))
+	storageDriver := storage.NewPrometheusDriver(prometheusAPIURL, map[string]string{}, scopeLabels.all())
+	defer func() {
+		if err := storageDriver.Close(); err != nil {
+			logg.Error("Could not close storage driver: %s", err.Error())
+		}
+	}()
+	mainRouter := setupRouter(keystoneDriver, globalKeystone, storageDriver)
 
 	bindAddress := viper.GetString("maia.bind_address")
 	logg.Info("listening on %s", bindAddress)
@@ -79,6 +118,54 @@ func Server(ctx context.Context) error {
 	return http.ListenAndServe(bindAddress, handler) //nolint:gosec // TODO: use httpext.ListenAndServeContext() from go-bits
 }
 
+// queryLimitsFromConfig reads the query guardrails from the [maia.query_limits] section, with overrides for individual
+// projects and domains from its projects.<id> and domains.<id> subsections
+func queryLimitsFromConfig() util.TenantQueryLimits {
+	limits := util.TenantQueryLimits{
+		Default:  queryLimitsFromSection("maia.query_limits", util.QueryLimits{}),
+		Projects: map[string]util.QueryLimits{},
+		Domains:  map[string]util.QueryLimits{},
+	}
+	logg.Info("Query limits: %s", formatQueryLimits(limits.Default))
+	for kind, overrides := range map[string]map[string]util.QueryLimits{"projects": limits.Projects, "domains": limits.Domains} {
+		for id := range viper.GetStringMap("maia.query_limits." + kind) {
+			overrides[id] = queryLimitsFromSection("maia.query_limits."+kind+"."+id, limits.Default)
+			logg.Info("Query limits for %s %s: %s", strings.TrimSuffix(kind, "s"), id, formatQueryLimits(overrides[id]))
+		}
+	}
+	return limits
+}
+
+// queryLimitsFromSection reads the query guardrails of a config section, taking the unset ones from base
+func queryLimitsFromSection(section string, base util.QueryLimits) util.QueryLimits {
+	limits := base
+	if viper.IsSet(section + ".max_points") {
+		limits.MaxPoints = viper.GetInt64(section + ".max_points")
+	}
+	if viper.IsSet(section + ".denied_functions") {
+		limits.DeniedFunctions = viper.GetStringSlice(section + ".denied_functions")
+	}
+	if viper.IsSet(section + ".deny_name_only_selectors") {
+		limits.DenyNameOnlySelectors = viper.GetBool(section + ".deny_name_only_selectors")
+	}
+	for key, target := range map[string]*time.Duration{"max_range": &limits.MaxRange, "min_subquery_step": &limits.MinSubqueryStep, "default_subquery_step": &limits.DefaultSubqueryStep} {
+		if s := viper.GetString(section + "." + key); s != "" {
+			d, err := model.ParseDuration(s)
+			if err != nil {
+				panic(fmt.Errorf("invalid %s.%s setting: %w", section, key, err))
+			}
+			*target = time.Duration(d)
+		}
+	}
+	return limits
+}
+
+func formatQueryLimits(limits util.QueryLimits) string {
+	return fmt.Sprintf("max_range=%s min_subquery_step=%s default_subquery_step=%s max_points=%d denied_functions=%v deny_name_only_selectors=%t",
+		model.Duration(limits.MaxRange), model.Duration(limits.MinSubqueryStep), model.Duration(limits.DefaultSubqueryStep),
+		limits.MaxPoints, limits.DeniedFunctions, limits.DenyNameOnlySelectors)
+}
+
 // setupRouter initializes the main http router
 func setupRouter(keystoneDriver, globalKeystoneDriver keystone.Driver, storageDriver storage.Driver) http.Handler {
 	storageInstance = storageDriver
@@ -104,12 +191,27 @@ func setupRouter(keystoneDriver, globalKeystoneDriver keystone.Driver, storageDr
 	// hook up the v1 API (this code is structured so that a newer API version can
 	// be added easily later)
 	v1Handler := NewV1Handler(keystoneDriver, storageDriver)
+	// the remote write gateway is only served when a remote write target is configured
+	if remoteWrite != nil {
+		apiRouter.Methods(http.MethodPost).Path("/v1/write").HandlerFunc(authorize(observeDuration(remoteWrite.Write, "write"), false, "metric:write"))
+	}
 	apiRouter.PathPrefix("/v1/").Handler(http.StripPrefix("/api/v1", v1Handler))
 
+	// tenant-aware proxy for the Alertmanager v2 API
+	if alertmanagerInstance != nil {
+		alertmanagerHandler := NewAlertmanagerHandler(keystoneDriver, alertmanagerInstance)
+		mainRouter.PathPrefix("/alertmanager/api/v2/").Handler(http.StripPrefix("/alertmanager/api/v2", alertmanagerHandler))
+	}
+
 	// other endpoints
 	// maia's federate endpoint
-	mainRouter.Methods(http.MethodGet).Path("/federate").HandlerFunc(
+	mainRouter.Methods(http.MethodGet, http.MethodPost).Path("/federate").HandlerFunc(
 		authorize(observeDuration(Federate, "federate"), false, "metric:show"))
+	// OTLP/HTTP metrics receiver, writing through the remote write gateway
+	if remoteWrite != nil {
+		mainRouter.Methods(http.MethodPost).Path("/v1/metrics").HandlerFunc(
+			authorize(observeDuration(remoteWrite.OTLPMetrics, "otlp"), false, "metric:write"))
+	}
 	// expression browser
 	mainRouter.Methods(http.MethodGet).PathPrefix("/static/").HandlerFunc(serveStaticContent)
 	mainRouter.Methods(http.MethodGet).PathPrefix("/favicon.ico").HandlerFunc(serveStaticContent)
@@ -121,8 +223,8 @@ func setupRouter(keystoneDriver, globalKeystoneDriver keystone.Driver, storageDr
 	mainRouter.Methods(http.MethodGet).Path("/{domain}/graph").HandlerFunc(authorize(observeDuration(observeResponseSize(graph, "graph"), "graph"), true, "metric:show"))
 	mainRouter.Methods(http.MethodGet).Path("/{domain}").HandlerFunc(redirectToDomainRootPage)
 
-	// provide the inflight metrics for all paths
-	return gaugeInflight(mainRouter)
+	// provide the inflight metrics for all paths and compress responses
+	return gaugeInflight(compressResponses(mainRouter))
 }
 
 var validDomain = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
@@ -226,17 +328,31 @@ func Federate(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	selectors, err := buildSelectors(req, ks)
+	constraints := scopeToLabelConstraint(req, ks)
+	selectors, err := buildScopedSelectors(req, constraints)
 	if err != nil {
 		logg.Info("Invalid request params %s", req.URL)
 		ReturnPromError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	response, err := storageInstance.Federate(*selectors, req.Header.Get("Accept"))
+	// the client may ask for OpenMetrics, which Prometheus does not offer on /federate
+	format := expfmt.NegotiateIncludingOpenMetrics(req.Header)
+	response, err := storageInstance.Federate(req.Context(), *selectors, storage.FederateAccept(format))
 	if err != nil {
 		logg.Error("Could not get metrics for %s", selectors)
-		ReturnPromError(w, err, http.StatusServiceUnavailable)
+		ReturnStorageError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	response, err = newScopeVerifier("federate", constraints).verifyFederate(response)
+	if err != nil {
+		ReturnStorageError(w, err, http.StatusBadGateway)
+		return
+	}
+	response, err = storage.ConvertFederateResponse(response, format)
+	if err != nil {
+		logg.Error("Could not convert metrics for %s: %s", selectors, err.Error())
+		ReturnStorageError(w, err, http.StatusBadGateway)
 		return
 	}
 
@@ -12,7 +12,10 @@ import (
 	"html"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +23,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/model"
 	"github.com/spf13/viper"
 
 	"github.com/sapcc/go-bits/logg"
@@ -69,9 +73,12 @@ var authFailuresCounter = prometheus.NewCounter(prometheus.CounterOpts{
 	Name: "maia_logon_failures_count", Help: "Number of logon attempts failed due to wrong credentials"})
 var promErrorsCounter = prometheus.NewCounter(prometheus.CounterOpts{
 	Name: "maia_tsdb_errors_count", Help: "Number of technical errors occurred when accessing Maia's underlying TSDB (i.e. Prometheus)"})
+var promCancellationsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "maia_tsdb_cancellations_count", Help: "Number of requests to Maia's underlying TSDB aborted because the client disconnected (reason=canceled) or the timeout expired (reason=timeout)"},
+	[]string{"reason"})
 
 func init() {
-	prometheus.MustRegister(authErrorsCounter, authFailuresCounter, promErrorsCounter)
+	prometheus.MustRegister(authErrorsCounter, authFailuresCounter, promErrorsCounter, promCancellationsCounter)
 }
 
 // provides version data
@@ -93,21 +100,55 @@ func versionData() VersionData {
 	}
 }
 
-// ReturnResponse basically forwards a received Response.
+// hopByHopHeaders only apply to the connection between Maia and Prometheus and are not forwarded
+var hopByHopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// ReturnResponse streams a received Response to the client. If the response exceeds maia.max_response_size,
+// a Prometheus error is returned instead, provided that the size is known in advance. Otherwise the status
+// has already been sent when the limit is hit, so the response is aborted to not pass off a truncated body
+// as complete.
 func ReturnResponse(w http.ResponseWriter, response *http.Response) {
 	defer response.Body.Close()
 
-	// copy headers
-	for k, v := range response.Header {
-		w.Header().Set(k, strings.Join(v, ";"))
+	limit := int64(viper.GetSizeInBytes("maia.max_response_size"))
+	if limit > 0 && response.ContentLength > limit {
+		logg.Info("Rejecting response of %d bytes exceeding maia.max_response_size", response.ContentLength)
+		ReturnPromError(w, responseTooLargeError(limit), http.StatusUnprocessableEntity)
+		return
 	}
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(response.Body) //nolint:errcheck // TODO go-bits replacement?
 
-	body := buf.String()
+	// copy headers
+	for k, values := range response.Header {
+		if slices.Contains(hopByHopHeaders, k) {
+			continue
+		}
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
 	w.WriteHeader(response.StatusCode)
 
-	io.WriteString(w, body) //nolint:errcheck // TODO go-bits? otherwise I can make return response return an err
+	if limit <= 0 {
+		if _, err := io.Copy(w, response.Body); err != nil {
+			logg.Info("Could not forward response: %s", err.Error())
+		}
+		return
+	}
+
+	_, err := io.Copy(w, io.LimitReader(response.Body, limit))
+	if err != nil {
+		logg.Info("Could not forward response: %s", err.Error())
+		return
+	}
+	// the body must end exactly at the limit
+	if n, _ := io.ReadFull(response.Body, make([]byte, 1)); n > 0 {
+		logg.Info("Aborting response: %s", responseTooLargeError(limit).Error())
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func responseTooLargeError(limit int64) error {
+	return fmt.Errorf("response exceeds the maximum size of %d bytes, please narrow down the query", limit)
 }
 
 // ReturnJSON is a convenience function for HTTP handlers returning JSON data.
@@ -152,12 +193,220 @@ func ReturnPromError(w http.ResponseWriter, err error, code int) {
 	ReturnJSON(w, code, jsonErr)
 }
 
-func scopeToLabelConstraint(req *http.Request, keystoneDriver keystone.Driver) (string, []string) { //nolint:gocritic
+// ReturnStorageError produces a Prometheus error Response for a failed request to the TSDB. Requests that
+// have been aborted because the client disconnected or the timeout expired are not counted as technical errors.
+func ReturnStorageError(w http.ResponseWriter, err error, code int) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		logg.Debug("TSDB request cancelled: %s", err.Error())
+		promCancellationsCounter.WithLabelValues(string(storage.ErrorCanceled)).Add(1)
+		jsonErr := storage.Response{Status: storage.StatusError, ErrorType: storage.ErrorCanceled, Error: err.Error()}
+		ReturnJSON(w, http.StatusServiceUnavailable, jsonErr)
+	case errors.Is(err, context.DeadlineExceeded):
+		logg.Info("TSDB request timed out: %s", err.Error())
+		promCancellationsCounter.WithLabelValues(string(storage.ErrorTimeout)).Add(1)
+		jsonErr := storage.Response{Status: storage.StatusError, ErrorType: storage.ErrorTimeout, Error: err.Error()}
+		ReturnJSON(w, http.StatusServiceUnavailable, jsonErr)
+	default:
+		ReturnPromError(w, err, code)
+	}
+}
+
+// requestParams returns the parameters of a Prometheus API request. Like Prometheus, Maia accepts them in the
+// URL as well as in a form-encoded body (POST), which is needed for queries exceeding URL length limits.
+func requestParams(req *http.Request) (url.Values, error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, fmt.Errorf("invalid request parameters: %w", err)
+	}
+	return req.Form, nil
+}
+
+// contextWithQueryTimeout derives the context for a TSDB request from the incoming request. The timeout
+// parameter of the Prometheus query API (a duration like "30s" or a number of seconds) becomes a deadline.
+func contextWithQueryTimeout(req *http.Request) (context.Context, context.CancelFunc, error) {
+	params, err := requestParams(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	timeout := params.Get("timeout")
+	if timeout == "" {
+		ctx, cancel := context.WithCancel(req.Context())
+		return ctx, cancel, nil
+	}
+
+	var d time.Duration
+	if f, err := strconv.ParseFloat(timeout, 64); err == nil {
+		d = time.Duration(f * float64(time.Second))
+	} else {
+		md, err := model.ParseDuration(timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid parameter 'timeout': cannot parse %q to a valid duration", timeout)
+		}
+		d = time.Duration(md)
+	}
+	if d <= 0 {
+		return nil, nil, fmt.Errorf("invalid parameter 'timeout': %q is not a positive duration", timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), d)
+	return ctx, cancel, nil
+}
+
+// checkQueryRangeLimits applies the query guardrails to the expression and the resolution of a query_range request.
+// Unparsable time parameters are left to Prometheus to report.
+func checkQueryRangeLimits(req *http.Request, params url.Values) error {
+	limits := requestQueryLimits(req)
+	if err := limits.CheckExpression(params.Get("query")); err != nil {
+		return err
+	}
+	start, err := util.ParseTime(params.Get("start"))
+	if err != nil {
+		return nil //nolint:nilerr
+	}
+	end, err := util.ParseTime(params.Get("end"))
+	if err != nil {
+		return nil //nolint:nilerr
+	}
+	step, err := util.ParseDuration(params.Get("step"))
+	if err != nil {
+		return nil //nolint:nilerr
+	}
+	return limits.CheckRange(start, end, step)
+}
+
+// requestQueryLimits returns the query guardrails for the project or domain of the request
+func requestQueryLimits(req *http.Request) util.QueryLimits {
+	domainID := req.Header.Get("X-Domain-Id")
+	if domainID == "" {
+		domainID = req.Header.Get("X-Project-Domain-Id")
+	}
+	return queryLimits.For(req.Header.Get("X-Project-Id"), domainID)
+}
+
+// tenancyLabels are the names of the labels that assign a series to a project or domain. Series carrying any of the
+// labels of a scope type are visible in the scope. The first label of each type is the one set by Maia itself, e.g.
+// on remote write.
+type tenancyLabels struct {
+	project []string
+	domain  []string
+}
+
+var defaultScopeLabels = tenancyLabels{project: []string{"project_id"}, domain: []string{"domain_id"}}
+
+// scopeLabelsFromConfig reads the label names from the [maia.scope_labels] section
+func scopeLabelsFromConfig() tenancyLabels {
+	result := defaultScopeLabels
+	for kind, names := range map[string]*[]string{"project": &result.project, "domain": &result.domain} {
+		key := "maia.scope_labels." + kind
+		if !viper.IsSet(key) {
+			continue
+		}
+		*names = viper.GetStringSlice(key)
+		if len(*names) == 0 {
+			panic(fmt.Errorf("invalid %s setting: at least one label name is required", key))
+		}
+		for _, name := range *names {
+			if !model.LabelName(name).IsValidLegacy() || strings.HasPrefix(name, "__") {
+				panic(fmt.Errorf("invalid %s setting: %q is not a valid label name", key, name))
+			}
+		}
+	}
+	if slices.ContainsFunc(result.project, func(name string) bool { return slices.Contains(result.domain, name) }) {
+		panic(errors.New("invalid maia.scope_labels settings: the project and domain labels must be different"))
+	}
+	if !slices.Equal(result.project, defaultScopeLabels.project) || !slices.Equal(result.domain, defaultScopeLabels.domain) {
+		logg.Info("Scope labels: project %v, domain %v", result.project, result.domain)
+	}
+	return result
+}
+
+// all returns the names of all project and domain labels
+func (t tenancyLabels) all() []string {
+	return slices.Concat(t.project, t.domain)
+}
+
+// scopeProjectsHeader carries the projects to which a request has limited its project scope. It is only set by
+// selectScopeProjects after validating them against the project hierarchy, never taken from the client.
+const scopeProjectsHeader = "X-Maia-Scope-Projects"
+
+// selectScopeProjects lets requests with a project scope leave out the child projects (include_children=false) or
+// select some of them (child_projects). Like the global flag, both options can be passed as URL parameters or as
+// headers (X-Include-Children, X-Child-Projects), the parameters taking precedence. It returns an HTTP status code
+// along with the error.
+func selectScopeProjects(keystoneDriver keystone.Driver, req *http.Request) (int, error) {
+	req.Header.Del(scopeProjectsHeader)
+	params, err := requestParams(req)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	includeChildren := true
+	if param := params.Get("include_children"); param != "" {
+		includeChildren, err = parseBoolean(param, "include_children parameter")
+	} else if header := req.Header.Get("X-Include-Children"); header != "" {
+		includeChildren, err = parseBoolean(header, "X-Include-Children header")
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	selected := splitProjectIDs(params["child_projects"])
+	if len(selected) == 0 {
+		selected = splitProjectIDs(req.Header.Values("X-Child-Projects"))
+	}
+	if includeChildren && len(selected) == 0 {
+		return http.StatusOK, nil
+	}
+
+	projectID := req.Header.Get("X-Project-Id")
+	switch {
+	case projectID == "":
+		return http.StatusBadRequest, errors.New("include_children and child_projects require a project scope")
+	case !includeChildren && len(selected) > 0:
+		return http.StatusBadRequest, errors.New("child_projects cannot be combined with include_children=false")
+	case !includeChildren:
+		req.Header.Set(scopeProjectsHeader, projectID)
+		return http.StatusOK, nil
+	}
+
+	children, err := keystoneDriver.ChildProjects(req.Context(), projectID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, id := range selected {
+		if id != projectID && !slices.Contains(children, id) {
+			return http.StatusForbidden, fmt.Errorf("project %s is not within the project hierarchy of %s", id, projectID)
+		}
+	}
+	req.Header.Set(scopeProjectsHeader, strings.Join(selected, ","))
+	return http.StatusOK, nil
+}
+
+// splitProjectIDs splits comma-separated lists of project IDs, dropping empty entries and duplicates
+func splitProjectIDs(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for id := range strings.SplitSeq(value, ",") {
+			if id = strings.TrimSpace(id); id != "" && !slices.Contains(result, id) {
+				result = append(result, id)
+			}
+		}
+	}
+	return result
+}
+
+// scopeToLabelConstraint determines the label constraints for the project or domain scope of the request. Series are
+// in the scope if they match any of the constraints. With maia.domain_scope_projects, the scope of a domain includes
+// the series of all enabled projects within it. Requests can limit a project scope with selectScopeProjects.
+func scopeToLabelConstraint(req *http.Request, keystoneDriver keystone.Driver) []util.LabelConstraint {
 	ctx := req.Context()
 	logg.Debug("[SCOPE_DEBUG] Starting scope resolution")
 
 	if projectID := req.Header.Get("X-Project-Id"); projectID != "" {
 		logg.Debug("[SCOPE_DEBUG] Found X-Project-Id: %s", projectID)
+		if selected := req.Header.Get(scopeProjectsHeader); selected != "" {
+			logg.Debug("[SCOPE_DEBUG] Scope limited to projects: %s", selected)
+			return labelConstraints(scopeLabels.project, appendSentinelValue(strings.Split(selected, ",")))
+		}
 		children, err := keystoneDriver.ChildProjects(ctx, projectID)
 		if err != nil {
 			logg.Error("[SCOPE_DEBUG] ChildProjects failed for %s: %v", projectID, err)
@@ -166,16 +415,39 @@ func scopeToLabelConstraint(req *http.Request, keystoneDriver keystone.Driver) (
 		logg.Debug("[SCOPE_DEBUG] ChildProjects for %s returned: %v", projectID, children)
 		allProjects := append([]string{projectID}, children...)
 		logg.Debug("[SCOPE_DEBUG] Final project list: %v", allProjects)
-		return "project_id", appendSentinelValue(allProjects)
+		return labelConstraints(scopeLabels.project, appendSentinelValue(allProjects))
 	} else if domainID := req.Header.Get("X-Domain-Id"); domainID != "" {
 		logg.Debug("[SCOPE_DEBUG] Found X-Domain-Id: %s", domainID)
-		return "domain_id", appendSentinelValue([]string{domainID})
+		constraints := labelConstraints(scopeLabels.domain, appendSentinelValue([]string{domainID}))
+		if !domainScopeProjects {
+			return constraints
+		}
+		// the top-level projects of a domain are its children in the project hierarchy
+		projects, err := keystoneDriver.ChildProjects(ctx, domainID)
+		if err != nil {
+			logg.Error("[SCOPE_DEBUG] ChildProjects failed for domain %s: %v", domainID, err)
+			panic(err)
+		}
+		logg.Debug("[SCOPE_DEBUG] Projects of domain %s: %v", domainID, projects)
+		if len(projects) > 0 {
+			constraints = append(constraints, labelConstraints(scopeLabels.project, appendSentinelValue(projects))...)
+		}
+		return constraints
 	}
 
 	logg.Error("[SCOPE_DEBUG] No X-Project-Id or X-Domain-Id found in headers")
 	panic(errors.New("missing OpenStack scope attributes in request header"))
 }
 
+// labelConstraints creates a constraint for each of the label names with the same values
+func labelConstraints(names, values []string) []util.LabelConstraint {
+	result := make([]util.LabelConstraint, len(names))
+	for i, name := range names {
+		result[i] = util.LabelConstraint{Key: name, Values: values}
+	}
+	return result
+}
+
 // appendSentinelValue appends the configured global visibility sentinel to the label values list.
 func appendSentinelValue(labelValues []string) []string {
 	if sentinelValue != "" {
@@ -187,24 +459,36 @@ func appendSentinelValue(labelValues []string) []string {
 // buildSelectors takes the selectors contained in the "match[]" URL query parameter(s)
 // and extends them with a label-constrained for the project/domain scope
 func buildSelectors(req *http.Request, keystoneDriver keystone.Driver) (*[]string, error) {
-	labelKey, labelValues := scopeToLabelConstraint(req, keystoneDriver)
+	return buildScopedSelectors(req, scopeToLabelConstraint(req, keystoneDriver))
+}
 
-	queryParams := req.URL.Query()
+// buildScopedSelectors works like buildSelectors for label constraints that have already been determined. With
+// several constraints, every selector is replaced by one selector per constraint.
+func buildScopedSelectors(req *http.Request, constraints []util.LabelConstraint) (*[]string, error) {
+	queryParams, err := requestParams(req)
+	if err != nil {
+		return nil, err
+	}
 	selectors := queryParams["match[]"]
 	if selectors == nil {
 		// behave like Prometheus, but do not proxy through
 		return nil, errors.New("no match[] parameter provided")
 	}
 	// enrich all match statements
-	for i, sel := range selectors {
-		newSel, err := util.AddLabelConstraintToSelector(sel, labelKey, labelValues)
+	limits := requestQueryLimits(req)
+	result := make([]string, 0, len(selectors)*len(constraints))
+	for _, sel := range selectors {
+		if err := limits.CheckSelector(sel); err != nil {
+			return nil, err
+		}
+		newSels, err := util.AddLabelConstraintsToSelector(sel, constraints)
 		if err != nil {
 			return nil, err
 		}
-		selectors[i] = newSel
+		result = append(result, newSels...)
 	}
 
-	return &selectors, nil
+	return &result, nil
 }
 
 func policyEngine() *policy.Enforcer {
@@ -337,6 +621,12 @@ func authorizeRules(keystoneDriver keystone.Driver, w http.ResponseWriter, req *
 		return false
 	}
 
+	// 4. apply the optional selection of child projects
+	if code, err := selectScopeProjects(keystoneDriver, req); err != nil {
+		http.Error(w, html.EscapeString(err.Error()), code)
+		return false
+	}
+
 	// set cookie
 	setAuthCookies(req, w)
 
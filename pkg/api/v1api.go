@@ -7,13 +7,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/golang/snappy"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/storage/remote"
 	"github.com/sapcc/go-bits/logg"
 	"github.com/spf13/viper"
 
@@ -26,8 +30,19 @@ import (
 type v1Provider struct {
 	keystone keystone.Driver
 	storage  storage.Driver
+	// matchSupport caches whether the backend honours match[] in the label values API (see matchSupported)
+	matchSupport atomic.Int32
 }
 
+const (
+	matchSupportUnknown int32 = iota
+	matchSupportYes
+	matchSupportNo
+)
+
+// labelValuesProbeSelector selects no series at all, so a backend which honours match[] returns no metric names for it
+const labelValuesProbeSelector = `{__name__="__maia_match_probe__"}`
+
 // NewV1Handler creates a http.Handler that serves the Maia v1 API.
 // It also returns the VersionData for this API version which is needed for the
 // version advertisement on "GET /".
@@ -41,22 +56,36 @@ func NewV1Handler(keystoneDriver keystone.Driver, storageDriver storage.Driver)
 	// Note: Keystone resolution is handled by keystoneResolutionMiddleware at router level
 	// This eliminates race conditions by ensuring consistent keystone selection throughout request lifecycle
 
+	// query, query_range, query_exemplars, labels and series also accept POST with form-encoded parameters, like Prometheus
+
 	// tenant-aware query
-	r.Methods(http.MethodGet).Path("/query").HandlerFunc(authorize(
+	r.Methods(http.MethodGet, http.MethodPost).Path("/query").HandlerFunc(authorize(
 		observeDuration(observeResponseSize(p.Query, "query"), "query"),
 		false,
 		"metric:show"))
 	// tenant-aware query range
-	r.Methods(http.MethodGet).Path("/query_range").HandlerFunc(authorize(
+	r.Methods(http.MethodGet, http.MethodPost).Path("/query_range").HandlerFunc(authorize(
 		observeDuration(observeResponseSize(p.QueryRange, "query_range"), "query_range"),
 		false,
 		"metric:show"))
 	// tenant-aware label value lists
 	r.Methods(http.MethodGet).Path("/label/{name}/values").HandlerFunc(authorize(observeDuration(observeResponseSize(p.LabelValues, "label_values"), "label_values"), false, "metric:list"))
 	// tenant-aware label name lists
-	r.Methods(http.MethodGet).Path("/labels").HandlerFunc(authorize(observeDuration(observeResponseSize(p.Labels, "labels"), "labels"), false, "metric:list"))
+	r.Methods(http.MethodGet, http.MethodPost).Path("/labels").HandlerFunc(authorize(observeDuration(observeResponseSize(p.Labels, "labels"), "labels"), false, "metric:list"))
 	// tenant-aware series metadata
-	r.Methods(http.MethodGet).Path("/series").HandlerFunc(authorize(observeDuration(observeResponseSize(p.Series, "series"), "series"), false, "metric:list"))
+	r.Methods(http.MethodGet, http.MethodPost).Path("/series").HandlerFunc(authorize(observeDuration(observeResponseSize(p.Series, "series"), "series"), false, "metric:list"))
+	// tenant-aware exemplars
+	r.Methods(http.MethodGet, http.MethodPost).Path("/query_exemplars").HandlerFunc(authorize(
+		observeDuration(observeResponseSize(p.QueryExemplars, "query_exemplars"), "query_exemplars"),
+		false,
+		"metric:show"))
+	// metric metadata, restricted to the metrics that exist within the scope
+	r.Methods(http.MethodGet).Path("/metadata").HandlerFunc(authorize(observeDuration(observeResponseSize(p.Metadata, "metadata"), "metadata"), false, "metric:list"))
+	// tenant-aware remote read, e.g. for alerting on project metrics in a separate Prometheus
+	r.Methods(http.MethodPost).Path("/read").HandlerFunc(authorize(observeDuration(observeResponseSize(p.RemoteRead, "read"), "read"), false, "metric:show"))
+	// alerts and alerting rules with pending or firing alerts of the tenant
+	r.Methods(http.MethodGet).Path("/alerts").HandlerFunc(authorize(observeDuration(observeResponseSize(p.Alerts, "alerts"), "alerts"), false, "alert:list"))
+	r.Methods(http.MethodGet).Path("/rules").HandlerFunc(authorize(observeDuration(observeResponseSize(p.Rules, "rules"), "rules"), false, "alert:list"))
 
 	return r
 }
@@ -68,29 +97,106 @@ func (p *v1Provider) Query(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	labelKey, labelValue := scopeToLabelConstraint(req, ks)
+	constraints := scopeToLabelConstraint(req, ks)
 
-	queryParams := req.URL.Query()
+	queryParams, err := requestParams(req)
+	if err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
 	originalQuery := queryParams.Get("query")
 	logg.Debug("[QUERY_DEBUG] Original query: %s", originalQuery)
-	logg.Debug("[QUERY_DEBUG] Label constraint: %s = %v", labelKey, labelValue)
+	logg.Debug("[QUERY_DEBUG] Label constraints: %v", constraints)
+
+	if err := requestQueryLimits(req).CheckExpression(originalQuery); err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
 
-	newQuery, err := util.AddLabelConstraintToExpression(originalQuery, labelKey, labelValue)
+	newQueries, err := util.AddLabelConstraintsToQueries(originalQuery, constraints)
 	if err != nil {
 		logg.Error("[QUERY_DEBUG] Query modification failed: %v", err)
 		ReturnPromError(w, err, http.StatusBadRequest)
 		return
 	}
 
-	logg.Debug("[QUERY_DEBUG] Modified query: %s", newQuery)
-	resp, err := p.storage.Query(newQuery, queryParams.Get("time"), queryParams.Get("timeout"), req.Header.Get("Accept"))
+	ctx, cancel, err := contextWithQueryTimeout(req)
 	if err != nil {
-		logg.Error("[QUERY_DEBUG] Storage query failed: %v", err)
-		ReturnPromError(w, err, http.StatusServiceUnavailable)
+		ReturnPromError(w, err, http.StatusBadRequest)
 		return
 	}
+	defer cancel()
 
-	ReturnResponse(w, resp)
+	// a range selector restricted to several scope labels is queried once per label
+	var responses []*http.Response
+	defer func() {
+		for _, resp := range responses {
+			resp.Body.Close()
+		}
+	}()
+	for _, newQuery := range newQueries {
+		logg.Debug("[QUERY_DEBUG] Modified query: %s", newQuery)
+		if seriesBudget != nil {
+			if err := seriesBudget.Check(ctx, w, p.storage, newQuery, queryParams.Get("time")); err != nil {
+				ReturnPromError(w, err, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		resp, err := p.storage.Query(ctx, newQuery, queryParams.Get("time"), req.Header.Get("Accept"))
+		if err != nil {
+			logg.Error("[QUERY_DEBUG] Storage query failed: %v", err)
+			ReturnStorageError(w, err, http.StatusServiceUnavailable)
+			return
+		}
+		resp, err = newScopeVerifier("query", constraints).verifyQuery(resp, newQuery)
+		if err != nil {
+			ReturnStorageError(w, err, http.StatusBadGateway)
+			return
+		}
+		if resp.StatusCode != http.StatusOK {
+			ReturnResponse(w, resp)
+			return
+		}
+		responses = append(responses, resp)
+	}
+
+	if len(responses) == 1 {
+		ReturnResponse(w, responses[0])
+		return
+	}
+	result, err := mergeMatrixResponses(responses)
+	if err != nil {
+		ReturnStorageError(w, err, http.StatusBadGateway)
+		return
+	}
+	ReturnJSON(w, http.StatusOK, result)
+}
+
+// mergeMatrixResponses merges the results of queries of the same range selector with different scope labels. Series
+// carrying several of the labels are returned by more than one of the queries, but only included once.
+func mergeMatrixResponses(responses []*http.Response) (*storage.QueryResponse, error) {
+	result := storage.QueryResponse{Status: storage.StatusSuccess}
+	matrix := model.Matrix{}
+	seen := map[model.Fingerprint]bool{}
+	for _, resp := range responses {
+		var qr storage.QueryResponse
+		if err := json.NewDecoder(resp.Body).Decode(&qr); err != nil {
+			return nil, fmt.Errorf("cannot decode the query response: %w", err)
+		}
+		streams, ok := qr.Data.Value.(model.Matrix)
+		if !ok {
+			return nil, fmt.Errorf("unexpected result type %s of a range selector", qr.Data.Type)
+		}
+		for _, stream := range streams {
+			if fp := stream.Metric.Fingerprint(); !seen[fp] {
+				seen[fp] = true
+				matrix = append(matrix, stream)
+			}
+		}
+		result.Warnings = append(result.Warnings, qr.Warnings...)
+	}
+	result.Data = storage.QueryResult{Type: model.ValMatrix, Result: matrix, Value: matrix}
+	return &result, nil
 }
 
 func (p *v1Provider) QueryRange(w http.ResponseWriter, req *http.Request) {
@@ -100,34 +206,56 @@ func (p *v1Provider) QueryRange(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	labelKey, labelValue := scopeToLabelConstraint(req, ks)
+	constraints := scopeToLabelConstraint(req, ks)
+
+	queryParams, err := requestParams(req)
+	if err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := checkQueryRangeLimits(req, queryParams); err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
+	newQuery, err := util.AddLabelConstraintsToExpression(queryParams.Get("query"), constraints)
+	if err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
 
-	queryParams := req.URL.Query()
-	newQuery, err := util.AddLabelConstraintToExpression(queryParams.Get("query"), labelKey, labelValue)
+	ctx, cancel, err := contextWithQueryTimeout(req)
 	if err != nil {
 		ReturnPromError(w, err, http.StatusBadRequest)
 		return
 	}
+	defer cancel()
 
-	resp, err := p.storage.QueryRange(newQuery, queryParams.Get("start"), queryParams.Get("end"), queryParams.Get("step"), queryParams.Get("timeout"), req.Header.Get("Accept"))
+	// the series at the end of the range stand in for the whole range
+	if seriesBudget != nil {
+		if err := seriesBudget.Check(ctx, w, p.storage, newQuery, queryParams.Get("end")); err != nil {
+			ReturnPromError(w, err, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	resp, err := p.storage.QueryRange(ctx, newQuery, queryParams.Get("start"), queryParams.Get("end"), queryParams.Get("step"), req.Header.Get("Accept"))
+	if err != nil {
+		ReturnStorageError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	resp, err = newScopeVerifier("query_range", constraints).verifyQuery(resp, newQuery)
 	if err != nil {
-		ReturnPromError(w, err, http.StatusServiceUnavailable)
+		ReturnStorageError(w, err, http.StatusBadGateway)
 		return
 	}
 
 	ReturnResponse(w, resp)
 }
 
-// LabelValues utilizes the query range API in order to implement a tenant-aware list.
-// This is a complex operation.
+// LabelValues lists the values of a label within the project/domain scope of the request. Like Prometheus, it
+// accepts match[], start, end and limit parameters.
 func (p *v1Provider) LabelValues(w http.ResponseWriter, req *http.Request) {
 	name := model.LabelName(mux.Vars(req)["name"])
-	// exclude label values from series that exceed maia.label_value_ttl age limit
-	ttl, err := time.ParseDuration(viper.GetString("maia.label_value_ttl"))
-	if err != nil {
-		ReturnPromError(w, errors.New("invalid Maia configuration (maia.label_value_ttl)"), http.StatusInternalServerError)
-		return
-	}
 
 	ks := getKeystoneFromContext(req.Context())
 	if ks == nil {
@@ -135,61 +263,172 @@ func (p *v1Provider) LabelValues(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// build project_id constraint using project hierarchy
-	labelKey, labelValues := scopeToLabelConstraint(req, ks)
-	// make a broad range query and aggregate by requested label. Use count() as cheap aggregation function.
-	query, err := util.AddLabelConstraintToExpression("count({"+string(name)+"!=\"\"}) BY ("+string(name)+")", labelKey, labelValues)
+	values, code, err := p.scopedLabelValues(req, ks, name)
 	if err != nil {
-		ReturnPromError(w, err, http.StatusBadRequest)
+		ReturnStorageError(w, err, code)
 		return
 	}
+	if limit, err := strconv.Atoi(req.Form.Get("limit")); err == nil && limit > 0 && limit < len(values) {
+		values = values[:limit]
+	}
+
+	result := storage.LabelValuesResponse{Status: storage.StatusSuccess, Data: values}
+	ReturnJSON(w, 200, &result)
+}
+
+// scopedLabelValues returns the sorted values of a label within the project/domain scope of the request, restricted
+// to the series selected by the match[] parameters. Without start parameter, values from series older than
+// maia.label_value_ttl are left out. Along with an error, it returns the HTTP status code that should be reported
+// to the client.
+func (p *v1Provider) scopedLabelValues(req *http.Request, ks keystone.Driver, name model.LabelName) (model.LabelValues, int, error) {
+	// exclude label values from series that exceed maia.label_value_ttl age limit
+	ttl, err := time.ParseDuration(viper.GetString("maia.label_value_ttl"))
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("invalid Maia configuration (maia.label_value_ttl)")
+	}
 
-	start := time.Now().Add(-ttl)
-	end := time.Now()
-	// select step-size to return only two values (minimum and maximum)
-	step := viper.GetString("maia.label_value_ttl")
-	resp, err := p.storage.QueryRange(query, start.Format(time.RFC3339), end.Format(time.RFC3339), step, "", req.Header.Get("Accept"))
+	queryParams, err := requestParams(req)
 	if err != nil {
-		ReturnPromError(w, err, http.StatusBadGateway)
-		return
+		return nil, http.StatusBadRequest, err
+	}
+	end, err := util.ParseTime(queryParams.Get("end"))
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid parameter 'end': %w", err)
+	}
+	start := end.Add(-ttl)
+	if s := queryParams.Get("start"); s != "" {
+		if start, err = util.ParseTime(s); err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid parameter 'start': %w", err)
+		}
+	}
+	if end.Before(start) {
+		return nil, http.StatusBadRequest, errors.New("end timestamp must not be before start time")
 	}
 
-	// read the complete result into memory
-	// could this be avoided (streaming like in Java)?
+	// build project_id constraint using project hierarchy
+	constraints := scopeToLabelConstraint(req, ks)
+	match := queryParams["match[]"]
+	if len(match) == 0 {
+		match = []string{"{" + string(name) + "!=\"\"}"}
+	}
+	limits := requestQueryLimits(req)
+	selectors := make([]string, 0, len(match)*len(constraints))
+	for _, sel := range match {
+		if err := limits.CheckSelector(sel); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		scoped, err := util.AddLabelConstraintsToSelector(sel, constraints)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		selectors = append(selectors, scoped...)
+	}
+
+	var values model.LabelValues
+	switch viper.GetString("maia.label_values_mode") {
+	case "native":
+		values, err = p.labelValuesByAPI(req, name, selectors, start, end)
+	case "query":
+		values, err = p.labelValuesByQuery(req, name, selectors, start, end)
+	default:
+		// the label values API would leak the values of other tenants if the backend ignored match[]
+		if p.matchSupported(req, start, end) {
+			values, err = p.labelValuesByAPI(req, name, selectors, start, end)
+		} else {
+			values, err = p.labelValuesByQuery(req, name, selectors, start, end)
+		}
+	}
+	if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+	// sort the stuff (it's often on the UI)
+	sort.Sort(values)
+
+	return values, http.StatusOK, nil
+}
+
+// matchSupported checks whether the backend honours the match[] parameter of the label values API: it must list
+// metric names without selector, but none for a selector that matches nothing. The outcome is remembered, but an
+// inconclusive or failed check counts as unsupported and is repeated with the next request.
+func (p *v1Provider) matchSupported(req *http.Request, start, end time.Time) bool {
+	switch p.matchSupport.Load() {
+	case matchSupportYes:
+		return true
+	case matchSupportNo:
+		return false
+	}
+
+	all, err := p.labelValuesByAPI(req, model.MetricNameLabel, nil, start, end)
+	if err != nil || len(all) == 0 {
+		return false
+	}
+	none, err := p.labelValuesByAPI(req, model.MetricNameLabel, []string{labelValuesProbeSelector}, start, end)
+	if err != nil {
+		return false
+	}
+	if len(none) > 0 {
+		logg.Info("WARNING: the backend ignores match[] in the label values API, falling back to count() by queries")
+		p.matchSupport.Store(matchSupportNo)
+		return false
+	}
+	p.matchSupport.Store(matchSupportYes)
+	return true
+}
+
+// labelValuesByAPI uses the label values API of Prometheus, which supports match[] parameters since Prometheus 2.24
+func (p *v1Provider) labelValuesByAPI(req *http.Request, name model.LabelName, selectors []string, start, end time.Time) (model.LabelValues, error) {
+	resp, err := p.storage.LabelValues(req.Context(), string(name), selectors, start.Format(time.RFC3339), end.Format(time.RFC3339), storage.JSON)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
-	buf, err := io.ReadAll(resp.Body)
+
+	var lr storage.LabelValuesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil {
+		return nil, err
+	}
+	if lr.Status != storage.StatusSuccess {
+		return nil, fmt.Errorf("cannot determine values of label %s: %s", name, lr.Error)
+	}
+	return lr.Data, nil
+}
+
+// labelValuesByQuery is the fallback for backends that ignore the match[] parameter of the label values API. It
+// aggregates the selected series by the label with a cheap count() range query, choosing the step so that only a
+// single point per value is returned.
+func (p *v1Provider) labelValuesByQuery(req *http.Request, name model.LabelName, selectors []string, start, end time.Time) (model.LabelValues, error) {
+	query := "count by (" + string(name) + ") (" + strings.Join(selectors, " or ") + ")"
+	step := strconv.FormatFloat(max(end.Sub(start).Seconds(), 1), 'f', -1, 64)
+	resp, err := p.storage.QueryRange(req.Context(), query, start.Format(time.RFC3339), end.Format(time.RFC3339), step, storage.JSON)
 	if err != nil {
-		ReturnPromError(w, err, resp.StatusCode)
-		return
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	// unmarshal
 	var sr storage.QueryResponse
-	err = json.Unmarshal(buf, &sr)
-	if err != nil {
-		ReturnPromError(w, err, http.StatusInternalServerError)
-		return
+	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return nil, err
+	}
+	if sr.Status != storage.StatusSuccess {
+		return nil, fmt.Errorf("cannot determine values of label %s: %s", name, sr.Error)
 	}
 	matrix, ok := sr.Data.Value.(model.Matrix)
 	if !ok {
-		ReturnPromError(w, fmt.Errorf("cannot process LabelValues response: expected matrix result type, got %s", sr.Data.Value.Type()), http.StatusBadGateway)
-		return
+		valueType := model.ValNone
+		if sr.Data.Value != nil {
+			valueType = sr.Data.Value.Type()
+		}
+		return nil, fmt.Errorf("cannot process LabelValues response: expected matrix result type, got %s", valueType)
 	}
 
-	// take just the label values from the query result
-	var result storage.LabelValuesResponse
-	result.Status = sr.Status
-	result.Data = make(model.LabelValues, 0, len(matrix))
-	for k := range matrix {
-		metric := matrix[k]
-		if metric != nil {
-			result.Data = append(result.Data, metric.Metric[name])
+	// take just the label values from the query result, series without the label are counted in an empty group
+	values := make(model.LabelValues, 0, len(matrix))
+	for _, stream := range matrix {
+		if stream != nil && stream.Metric[name] != "" {
+			values = append(values, stream.Metric[name])
 		}
 	}
-	// sort the stuff (it's often on the UI)
-	sort.Sort(result.Data)
-
-	ReturnJSON(w, 200, &result)
+	return values, nil
 }
 
 func (p *v1Provider) Series(w http.ResponseWriter, req *http.Request) {
@@ -199,15 +438,22 @@ func (p *v1Provider) Series(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	selectors, err := buildSelectors(req, ks)
+	constraints := scopeToLabelConstraint(req, ks)
+	selectors, err := buildScopedSelectors(req, constraints)
 	if err != nil {
 		ReturnPromError(w, err, http.StatusBadRequest)
 		return
 	}
-	queryParams := req.URL.Query()
-	resp, err := p.storage.Series(*selectors, queryParams.Get("start"), queryParams.Get("end"), req.Header.Get("Accept"))
+	// the parameters have been parsed by buildScopedSelectors
+	queryParams := req.Form
+	resp, err := p.storage.Series(req.Context(), *selectors, queryParams.Get("start"), queryParams.Get("end"), req.Header.Get("Accept"))
+	if err != nil {
+		ReturnStorageError(w, err, http.StatusBadGateway)
+		return
+	}
+	resp, err = newScopeVerifier("series", constraints).verifySeries(resp)
 	if err != nil {
-		ReturnPromError(w, err, http.StatusBadGateway)
+		ReturnStorageError(w, err, http.StatusBadGateway)
 		return
 	}
 
@@ -227,13 +473,153 @@ func (p *v1Provider) Labels(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	queryParams := req.URL.Query()
+	// the parameters have been parsed by buildSelectors
+	queryParams := req.Form
 	start := queryParams.Get("start")
 	end := queryParams.Get("end")
 
-	resp, err := p.storage.Labels(start, end, *match, req.Header.Get("Accept"))
+	resp, err := p.storage.Labels(req.Context(), start, end, *match, req.Header.Get("Accept"))
+	if err != nil {
+		ReturnStorageError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	ReturnResponse(w, resp)
+}
+
+func (p *v1Provider) QueryExemplars(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		ReturnPromError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+
+	constraints := scopeToLabelConstraint(req, ks)
+
+	queryParams, err := requestParams(req)
+	if err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := requestQueryLimits(req).CheckExpression(queryParams.Get("query")); err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
+	newQuery, err := util.AddLabelConstraintsToExpression(queryParams.Get("query"), constraints)
+	if err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	resp, err := p.storage.QueryExemplars(req.Context(), newQuery, queryParams.Get("start"), queryParams.Get("end"), req.Header.Get("Accept"))
+	if err != nil {
+		ReturnStorageError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	ReturnResponse(w, resp)
+}
+
+// Metadata returns the metadata of the metrics that exist within the project/domain scope. Since the metadata
+// API of Prometheus cannot be restricted by labels, the result is filtered by the metric names in scope, so that
+// the names of other tenants' metrics are not disclosed.
+func (p *v1Provider) Metadata(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		ReturnPromError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+
+	queryParams, err := requestParams(req)
+	if err != nil {
+		ReturnPromError(w, err, http.StatusBadRequest)
+		return
+	}
+	// like in Prometheus, limit is the maximum number of metrics to return (0 means no limit)
+	limit := 0
+	if s := queryParams.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil {
+			ReturnPromError(w, fmt.Errorf("invalid parameter 'limit': cannot parse %q to an integer", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	names, code, err := p.scopedLabelValues(req, ks, model.MetricNameLabel)
+	if err != nil {
+		ReturnStorageError(w, err, code)
+		return
+	}
+
+	resp, err := p.storage.Metadata(req.Context(), queryParams.Get("metric"), queryParams.Get("limit_per_metric"), storage.JSON)
+	if err != nil {
+		ReturnStorageError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		ReturnResponse(w, resp)
+		return
+	}
+	defer resp.Body.Close()
+
+	var metadata storage.MetadataResponse
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		ReturnPromError(w, fmt.Errorf("cannot process metadata response: %w", err), http.StatusBadGateway)
+		return
+	}
+
+	result := storage.MetadataResponse{Status: storage.StatusSuccess, Data: map[string][]storage.MetricMetadata{}, Warnings: metadata.Warnings}
+	for _, name := range names {
+		if limit > 0 && len(result.Data) >= limit {
+			break
+		}
+		if entries, ok := metadata.Data[string(name)]; ok {
+			result.Data[string(name)] = entries
+		}
+	}
+
+	ReturnJSON(w, 200, &result)
+}
+
+// maxRemoteReadRequestSize limits the size of a compressed remote read request
+const maxRemoteReadRequestSize = 4 << 20
+
+// RemoteRead implements the remote read API of Prometheus. Every query of the request is restricted to the series
+// of the project/domain scope. Both sampled and streamed (chunked) responses are passed on from Prometheus unchanged.
+// Scopes with several labels need one request per label, whose results are merged (see remoteReadUnion).
+func (p *v1Provider) RemoteRead(w http.ResponseWriter, req *http.Request) {
+	ks := getKeystoneFromContext(req.Context())
+	if ks == nil {
+		ReturnPromError(w, errors.New("keystone context not available"), http.StatusInternalServerError)
+		return
+	}
+
+	constraints := scopeToLabelConstraint(req, ks)
+
+	req.Body = http.MaxBytesReader(w, req.Body, maxRemoteReadRequestSize)
+	request, err := remote.DecodeReadRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(constraints) > 1 {
+		p.remoteReadUnion(w, req, request, constraints)
+		return
+	}
+	err = util.AddLabelConstraintToReadRequest(request, constraints[0].Key, constraints[0].Values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	encoded, err := request.Marshal()
+	if err != nil {
+		ReturnPromError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := p.storage.RemoteRead(req.Context(), snappy.Encode(nil, encoded))
 	if err != nil {
-		ReturnPromError(w, err, http.StatusServiceUnavailable)
+		ReturnStorageError(w, err, http.StatusServiceUnavailable)
 		return
 	}
 
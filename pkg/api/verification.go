@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/viper"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/util"
+)
+
+var scopeViolationsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "maia_scope_violations_total",
+	Help: "Number of series returned by the storage with project/domain labels outside of the scope of the request"},
+	[]string{"handler"})
+
+func init() {
+	prometheus.MustRegister(scopeViolationsCounter)
+}
+
+// scopeVerification is the optional check of storage results against the scope of the request (drop or reject). It
+// is empty when disabled.
+var scopeVerification string
+
+// errScopeViolation is returned instead of a response containing series outside of the scope in reject mode
+var errScopeViolation = errors.New("the storage returned series outside of the scope of the request")
+
+func scopeVerificationFromConfig() string {
+	switch mode := viper.GetString("maia.scope_verification"); mode {
+	case "", "off":
+		return ""
+	case "drop", "reject":
+		logg.Info("Scope verification of storage results: %s", mode)
+		return mode
+	default:
+		panic(fmt.Errorf("invalid maia.scope_verification setting %q: must be off, drop or reject", mode))
+	}
+}
+
+// scopeVerifier checks the series returned by the storage against the label constraints that were added to the
+// request. This does not rely on the label matchers being applied correctly by Maia or the storage, so that a bug in
+// either does not disclose the series of other tenants.
+type scopeVerifier struct {
+	handler     string
+	constraints []util.LabelConstraint
+	// partial is set when some of the scope labels are not returned, so series without any of the constraint labels
+	// may stem from series selected by the missing ones and cannot be verified
+	partial bool
+}
+
+// newScopeVerifier returns nil if scope verification is disabled
+func newScopeVerifier(handler string, constraints []util.LabelConstraint) *scopeVerifier {
+	if scopeVerification == "" {
+		return nil
+	}
+	return &scopeVerifier{handler: handler, constraints: constraints}
+}
+
+func (v *scopeVerifier) inScope(ls model.LabelSet) bool {
+	if v.partial && !slices.ContainsFunc(v.constraints, func(c util.LabelConstraint) bool { return ls[model.LabelName(c.Key)] != "" }) {
+		return true
+	}
+	return slices.ContainsFunc(v.constraints, func(c util.LabelConstraint) bool {
+		value, ok := ls[model.LabelName(c.Key)]
+		return ok && slices.Contains(c.Values, string(value))
+	})
+}
+
+// verify replaces the body of a successful response by the result of filter. filter returns the body without the
+// series outside of the scope and their number, or nil if there are none.
+func (v *scopeVerifier) verify(resp *http.Response, filter func(body []byte) ([]byte, int, error)) (*http.Response, error) {
+	if v == nil || resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered, violations, err := filter(body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot verify the scope of the %s response: %w", v.handler, err)
+	}
+	if violations > 0 {
+		scopeViolationsCounter.WithLabelValues(v.handler).Add(float64(violations))
+		logg.Error("Storage returned %d series outside of the scope %v to %s", violations, v.constraints, v.handler)
+		if scopeVerification == "reject" {
+			return nil, errScopeViolation
+		}
+		body = filtered
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Del("Content-Length")
+	return resp, nil
+}
+
+// verifySeries checks a response of the series API
+func (v *scopeVerifier) verifySeries(resp *http.Response) (*http.Response, error) {
+	return v.verify(resp, func(body []byte) ([]byte, int, error) {
+		return replaceData(body, func(data json.RawMessage) (any, int, error) {
+			var series []model.LabelSet
+			if err := json.Unmarshal(data, &series); err != nil {
+				return nil, 0, err
+			}
+			result := slices.DeleteFunc(slices.Clone(series), func(ls model.LabelSet) bool { return !v.inScope(ls) })
+			return result, len(series) - len(result), nil
+		})
+	})
+}
+
+// verifyQuery checks a response of the query or query_range API against the labels of the scope that the expression
+// keeps. Results of expressions that aggregate away or overwrite all of them cannot be verified and are passed on.
+func (v *scopeVerifier) verifyQuery(resp *http.Response, expression string) (*http.Response, error) {
+	if v == nil {
+		return resp, nil
+	}
+	var preserved []util.LabelConstraint
+	for _, c := range v.constraints {
+		ok, err := util.PreservesLabel(expression, c.Key)
+		if err != nil {
+			return resp, nil //nolint:nilerr // the expression has already been parsed successfully by the storage
+		}
+		if ok {
+			preserved = append(preserved, c)
+		}
+	}
+	if len(preserved) == 0 {
+		return resp, nil
+	}
+	if len(preserved) < len(v.constraints) {
+		v = &scopeVerifier{handler: v.handler, constraints: preserved, partial: true}
+	}
+	return v.verify(resp, func(body []byte) ([]byte, int, error) {
+		return replaceData(body, func(data json.RawMessage) (any, int, error) {
+			var qr struct {
+				Type   model.ValueType `json:"resultType"`
+				Result json.RawMessage `json:"result"`
+			}
+			if err := json.Unmarshal(data, &qr); err != nil {
+				return nil, 0, err
+			}
+			var result any
+			violations := 0
+			switch qr.Type {
+			case model.ValVector:
+				var vector model.Vector
+				if err := json.Unmarshal(qr.Result, &vector); err != nil {
+					return nil, 0, err
+				}
+				filtered := slices.DeleteFunc(slices.Clone(vector), func(s *model.Sample) bool { return !v.inScope(model.LabelSet(s.Metric)) })
+				result, violations = filtered, len(vector)-len(filtered)
+			case model.ValMatrix:
+				var matrix model.Matrix
+				if err := json.Unmarshal(qr.Result, &matrix); err != nil {
+					return nil, 0, err
+				}
+				filtered := slices.DeleteFunc(slices.Clone(matrix), func(s *model.SampleStream) bool { return !v.inScope(model.LabelSet(s.Metric)) })
+				result, violations = filtered, len(matrix)-len(filtered)
+			default:
+				// scalars and strings carry no labels
+				return nil, 0, nil
+			}
+			return map[string]any{"resultType": qr.Type, "result": result}, violations, nil
+		})
+	})
+}
+
+// verifyFederate checks a response of /federate in the Prometheus text or protobuf format
+func (v *scopeVerifier) verifyFederate(resp *http.Response) (*http.Response, error) {
+	format := expfmt.ResponseFormat(resp.Header)
+	return v.verify(resp, func(body []byte) ([]byte, int, error) {
+		if format.FormatType() == expfmt.TypeUnknown {
+			return nil, 0, fmt.Errorf("unexpected content type %q", resp.Header.Get("Content-Type"))
+		}
+		var families []*dto.MetricFamily
+		violations := 0
+		decoder := expfmt.NewDecoder(bytes.NewReader(body), format)
+		for {
+			var mf dto.MetricFamily
+			err := decoder.Decode(&mf)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			n := len(mf.Metric)
+			mf.Metric = slices.DeleteFunc(mf.Metric, func(m *dto.Metric) bool {
+				ls := make(model.LabelSet, len(m.Label))
+				for _, lp := range m.Label {
+					ls[model.LabelName(lp.GetName())] = model.LabelValue(lp.GetValue())
+				}
+				return !v.inScope(ls)
+			})
+			violations += n - len(mf.Metric)
+			if len(mf.Metric) > 0 {
+				families = append(families, &mf)
+			}
+		}
+		if violations == 0 {
+			return nil, 0, nil
+		}
+
+		var buf bytes.Buffer
+		encoder := expfmt.NewEncoder(&buf, format)
+		for _, mf := range families {
+			if err := encoder.Encode(mf); err != nil {
+				return nil, 0, err
+			}
+		}
+		return buf.Bytes(), violations, nil
+	})
+}
+
+// replaceData replaces the data field of a Prometheus API response by the result of filter, keeping all other fields
+// (e.g. warnings) of the response as they are
+func replaceData(body []byte, filter func(data json.RawMessage) (any, int, error)) ([]byte, int, error) {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, 0, err
+	}
+	data, violations, err := filter(envelope["data"])
+	if err != nil || violations == 0 {
+		return nil, 0, err
+	}
+	envelope["data"], err = json.Marshal(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	result, err := json.Marshal(envelope)
+	return result, violations, err
+}
@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/storage"
+	"github.com/SAP-cloud-infrastructure/maia/pkg/test"
+)
+
+// storageResponse creates a successful storage response with the given body
+func storageResponse(contentType, body string) *http.Response {
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Content-Type", contentType)
+	recorder.WriteString(body) //nolint:errcheck
+	return recorder.Result()
+}
+
+// verificationRequest sends a request with the project scope 12345 and returns the response
+func verificationRequest(router http.Handler, path, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+	req.Header.Set("Authorization", base64.StdEncoding.EncodeToString([]byte("Basic user_id|12345:password")))
+	req.Header.Set("Accept", accept)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestScopeVerificationFromConfig(t *testing.T) {
+	defer viper.Set("maia.scope_verification", nil)
+
+	viper.Set("maia.scope_verification", "off")
+	assert.Empty(t, scopeVerificationFromConfig())
+	viper.Set("maia.scope_verification", "drop")
+	assert.Equal(t, "drop", scopeVerificationFromConfig())
+	viper.Set("maia.scope_verification", "log")
+	assert.Panics(t, func() { scopeVerificationFromConfig() })
+}
+
+func TestSeries_scopeVerificationDrop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	scopeVerification = "drop"
+	violations := testutil.ToFloat64(scopeViolationsCounter.WithLabelValues("series"))
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Series(test.MatchContext(), []string{`{__name__="up",project_id="12345"}`}, "", "", storage.JSON).Return(storageResponse(storage.JSON,
+		`{"status":"success","data":[{"__name__":"up","project_id":"12345"},{"__name__":"up","project_id":"99999"},{"__name__":"up"}],"warnings":["partial"]}`), nil)
+
+	recorder := verificationRequest(router, "/api/v1/series?match[]=up", storage.JSON)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"status":"success","data":[{"__name__":"up","project_id":"12345"}],"warnings":["partial"]}`, recorder.Body.String())
+	assert.InDelta(t, violations+2, testutil.ToFloat64(scopeViolationsCounter.WithLabelValues("series")), 0)
+}
+
+func TestQuery_scopeVerificationReject(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	scopeVerification = "reject"
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Query(test.MatchContext(), `up{project_id="12345"}`, "", storage.JSON).Return(storageResponse(storage.JSON,
+		`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"__name__":"up","project_id":"99999"},"value":[1500000000,"1"]}]}}`), nil)
+
+	recorder := verificationRequest(router, "/api/v1/query?query=up", storage.JSON)
+	assert.Equal(t, http.StatusBadGateway, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), errScopeViolation.Error())
+}
+
+func TestQuery_scopeVerificationAggregated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	scopeVerification = "reject"
+
+	// the project_id label is aggregated away, so the result cannot be verified
+	body := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1500000000,"3"]}]}}`
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Query(test.MatchContext(), `sum(up{project_id="12345"})`, "", storage.JSON).Return(storageResponse(storage.JSON, body), nil)
+
+	recorder := verificationRequest(router, "/api/v1/query?query=sum(up)", storage.JSON)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, body, recorder.Body.String())
+}
+
+func TestQuery_scopeVerificationPartiallyAggregated(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	scopeVerification = "drop"
+	scopeLabels = tenancyLabels{project: []string{"project_id", "tenant_id"}, domain: []string{"domain_id"}}
+
+	// tenant_id is aggregated away, so the result is verified against project_id only: series without it may
+	// stem from series with tenant_id="12345"
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Query(test.MatchContext(), `sum by (project_id) ((up{project_id="12345"} or up{tenant_id="12345"}))`, "", storage.JSON).Return(storageResponse(storage.JSON,
+		`{"status":"success","data":{"resultType":"vector","result":[`+
+			`{"metric":{"project_id":"12345"},"value":[1500000000,"1"]},`+
+			`{"metric":{},"value":[1500000000,"2"]},`+
+			`{"metric":{"project_id":"99999"},"value":[1500000000,"3"]}]}}`), nil)
+
+	recorder := verificationRequest(router, "/api/v1/query?query=sum+by+(project_id)(up)", storage.JSON)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"status":"success","data":{"resultType":"vector","result":[`+
+		`{"metric":{"project_id":"12345"},"value":[1500000000,"1"]},`+
+		`{"metric":{},"value":[1500000000,"2"]}]}}`, recorder.Body.String())
+}
+
+func TestQueryRange_scopeVerificationDrop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	scopeVerification = "drop"
+	sentinelValue = "all"
+
+	// series with the sentinel value are visible to everyone
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().QueryRange(test.MatchContext(), `sum by (project_id) (up{project_id=~"12345|all"})`, "1500000000", "1500000060", "60", storage.JSON).Return(storageResponse(storage.JSON,
+		`{"status":"success","data":{"resultType":"matrix","result":[`+
+			`{"metric":{"project_id":"12345"},"values":[[1500000000,"1"],[1500000060,"1"]]},`+
+			`{"metric":{"project_id":"all"},"values":[[1500000000,"2"]]},`+
+			`{"metric":{"project_id":"99999"},"values":[[1500000000,"3"]]}]}}`), nil)
+
+	recorder := verificationRequest(router, "/api/v1/query_range?query=sum+by+(project_id)(up)&start=1500000000&end=1500000060&step=60", storage.JSON)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"status":"success","data":{"resultType":"matrix","result":[`+
+		`{"metric":{"project_id":"12345"},"values":[[1500000000,"1"],[1500000060,"1"]]},`+
+		`{"metric":{"project_id":"all"},"values":[[1500000000,"2"]]}]}}`, recorder.Body.String())
+}
+
+func TestFederate_scopeVerificationDrop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	router, keystoneMock, storageMock := setupTest(t, ctrl)
+	scopeVerification = "drop"
+	violations := testutil.ToFloat64(scopeViolationsCounter.WithLabelValues("federate"))
+
+	expectAuthByProjectID(keystoneMock)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{`{__name__="up",project_id="12345"}`}, storage.PlainText).Return(storageResponse(storage.PlainText,
+		"# TYPE up untyped\nup{project_id=\"12345\"} 1 1500000000000\nup{project_id=\"99999\"} 1 1500000000000\n"+
+			"# TYPE down untyped\ndown{project_id=\"99999\"} 0 1500000000000\n"), nil)
+
+	recorder := verificationRequest(router, "/federate?match[]=up", storage.PlainText)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "# TYPE up untyped\nup{project_id=\"12345\"} 1 1500000000000\n", recorder.Body.String())
+	assert.InDelta(t, violations+2, testutil.ToFloat64(scopeViolationsCounter.WithLabelValues("federate")), 0)
+}
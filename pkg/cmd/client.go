@@ -17,6 +17,7 @@ import (
 	"time"
 
 	"github.com/gophercloud/gophercloud/v2"
+	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/model"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -34,6 +35,7 @@ const (
 
 var maiaURL string
 var selector string
+var openMetrics bool
 var auth = gophercloud.AuthOptions{Scope: new(gophercloud.AuthScope)}
 var authType string
 var scopedDomain string
@@ -170,7 +172,7 @@ func storageInstance() storage.Driver {
 			if useGlobalKeystone {
 				headers["X-Global-Region"] = "true"
 			}
-			storageDriver = storage.NewPrometheusDriver(promURL, headers)
+			storageDriver = storage.NewPrometheusDriver(promURL, headers, nil)
 		case auth.IdentityEndpoint != "":
 			// authenticate and set maiaURL if missing
 			fetchToken(ctx)
@@ -179,7 +181,13 @@ func storageInstance() storage.Driver {
 			if useGlobalKeystone {
 				headers["X-Global-Region"] = "true"
 			}
-			storageDriver = storage.NewPrometheusDriver(maiaURL, headers)
+			if noChildren {
+				headers["X-Include-Children"] = "false"
+			}
+			if len(childProjects) > 0 {
+				headers["X-Child-Projects"] = strings.Join(childProjects, ",")
+			}
+			storageDriver = storage.NewPrometheusDriver(maiaURL, headers, nil)
 		default:
 			panic(errors.New("either --os-auth-url or --prometheus-url need to be specified"))
 		}
@@ -188,6 +196,14 @@ func storageInstance() storage.Driver {
 	return storageDriver
 }
 
+// commandContext returns the context of a command, which is unset when the command is invoked directly
+func commandContext(cmd *cobra.Command) context.Context {
+	if cmd != nil && cmd.Context() != nil {
+		return cmd.Context()
+	}
+	return context.Background()
+}
+
 // keystoneInstance creates a new keystone driver instance lazily
 func keystoneInstance() keystone.Driver {
 	if keystoneDriver == nil {
@@ -224,7 +240,7 @@ func printValues(resp *http.Response) {
 			} else {
 				panic(fmt.Errorf("unsupported --format value for this command: %s", outputFormat))
 			}
-		} else if strings.HasPrefix(contentType, "text/plain") {
+		} else if strings.HasPrefix(contentType, "text/plain") || strings.HasPrefix(contentType, expfmt.OpenMetricsType) {
 			if strings.EqualFold(outputFormat, "value") {
 				fmt.Print(string(body))
 			} else {
@@ -492,9 +508,19 @@ func Snapshot(cmd *cobra.Command, args []string) (ret error) {
 
 	prometheus := storageInstance()
 
+	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+	if openMetrics {
+		format = expfmt.NewFormat(expfmt.TypeOpenMetrics)
+	}
+
 	var resp *http.Response
-	resp, err := prometheus.Federate([]string{"{" + selector + "}"}, storage.PlainText)
+	resp, err := prometheus.Federate(commandContext(cmd), []string{"{" + selector + "}"}, storage.FederateAccept(format))
 	checkResponse(err, resp)
+	// Prometheus itself does not serve OpenMetrics
+	resp, err = storage.ConvertFederateResponse(resp, format)
+	if err != nil {
+		panic(err)
+	}
 
 	printValues(resp)
 
@@ -517,7 +543,7 @@ func LabelValues(cmd *cobra.Command, args []string) (ret error) {
 	prometheus := storageInstance()
 
 	var resp *http.Response
-	resp, err := prometheus.LabelValues(labelName, storage.JSON)
+	resp, err := prometheus.LabelValues(commandContext(cmd), labelName, nil, "", "", storage.JSON)
 	checkResponse(err, resp)
 
 	printValues(resp)
@@ -537,7 +563,7 @@ func Series(cmd *cobra.Command, args []string) (ret error) {
 	prometheus := storageInstance()
 
 	var resp *http.Response
-	resp, err := prometheus.Series([]string{"{" + selector + "}"}, starttime, endtime, storage.JSON)
+	resp, err := prometheus.Series(commandContext(cmd), []string{"{" + selector + "}"}, starttime, endtime, storage.JSON)
 	checkResponse(err, resp)
 
 	printTable(resp)
@@ -588,13 +614,15 @@ func Query(cmd *cobra.Command, args []string) (ret error) {
 	}
 	queryExpr := args[0]
 
-	var timeoutStr, stepStr string
+	ctx := commandContext(cmd)
 	if timeout > 0 {
-		// workaround parsing issues
-		timeoutStr = fmt.Sprintf("%ds", int(timeout.Seconds()))
-	} else {
-		timeoutStr = ""
+		// the deadline is passed on as timeout parameter of the query
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
+
+	var stepStr string
 	if stepsize > 0 {
 		stepStr = fmt.Sprintf("%ds", int(stepsize.Seconds()))
 	} else {
@@ -624,13 +652,13 @@ func Query(cmd *cobra.Command, args []string) (ret error) {
 			}
 			stepStr = fmt.Sprintf("%ds", int(sz.Seconds()))
 		}
-		resp, err = prometheus.QueryRange(queryExpr, starttime, endtime, stepStr, timeoutStr, storage.JSON)
+		resp, err = prometheus.QueryRange(ctx, queryExpr, starttime, endtime, stepStr, storage.JSON)
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
 	} else {
-		resp, err = prometheus.Query(queryExpr, timestamp, timeoutStr, storage.JSON)
+		resp, err = prometheus.Query(ctx, queryExpr, timestamp, storage.JSON)
 		if err != nil {
 			return err
 		}
@@ -683,7 +711,7 @@ func checkResponse(err error, resp *http.Response) {
 }
 
 var snapshotCmd = &cobra.Command{
-	Use:   "snapshot [ --selector <vector-selector> ]",
+	Use:   "snapshot [ --selector <vector-selector> ] [ --openmetrics ]",
 	Short: "Get a Snapshot of the actual metric values for a project/domain.",
 	Long:  "Displays the current values of all metric Series. The Series can filtered using vector-selectors (label constraints).",
 	RunE:  Snapshot,
@@ -767,6 +795,7 @@ func init() {
 	}
 
 	snapshotCmd.Flags().StringVarP(&selector, "selector", "l", "", "Prometheus label-selector to restrict the amount of metrics")
+	snapshotCmd.Flags().BoolVar(&openMetrics, "openmetrics", false, "Print the snapshot in OpenMetrics format, including exemplars and _created series")
 
 	queryCmd.Flags().StringVar(&starttime, "start", "", "Range query: start timestamp (RFC3339 or Unix format; default: 3h before)")
 	queryCmd.Flags().StringVar(&endtime, "end", "", "Range query: end timestamp (RFC3339 or Unix format; default: now)")
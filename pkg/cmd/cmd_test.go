@@ -16,6 +16,7 @@ import (
 
 	policy "github.com/databus23/goslo.policy"
 	"github.com/gophercloud/gophercloud/v2"
+	"github.com/prometheus/common/expfmt"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
@@ -41,6 +42,7 @@ func setupTest(controller *gomock.Controller) (keystoneDriver *keystone.MockDriv
 	// simulate command parameters
 	authType = ""
 	outputFormat = ""
+	openMetrics = false
 	starttime = ""
 	endtime = ""
 	tzLocation = time.UTC
@@ -48,6 +50,8 @@ func setupTest(controller *gomock.Controller) (keystoneDriver *keystone.MockDriv
 	columns = ""
 	maiaURL = ""
 	promURL = ""
+	noChildren = false
+	childProjects = nil
 
 	// set mandatory parameters
 	auth = gophercloud.AuthOptions{
@@ -91,7 +95,7 @@ func ExampleSnapshot() {
 	selector = "vmware_name=\"win_cifs_13\""
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().Federate([]string{"{" + selector + "}"}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{"{" + selector + "}"}, storage.PlainText).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
 
 	snapshotCmd.RunE(snapshotCmd, []string{}) //nolint:errcheck
 
@@ -100,6 +104,29 @@ func ExampleSnapshot() {
 	// vcenter_cpu_costop_summation{component="vcenter-exporter-vc-a-0",instance="100.65.0.252:9102",instance_uuid="3b32f415-c953-40b9-883d-51321611a7d4",job="endpoints",kubernetes_name="vcenter-exporter-vc-a-0",kubernetes_namespace="maia",metric_detail="3",project_id="12345",region="staging",service="metrics",system="openstack",vcenter_name="STAGINGA",vcenter_node="10.44.2.40",vmware_name="win_cifs_13"} 0 1500291187275
 }
 
+func ExampleSnapshot_openMetrics() {
+	t := testReporter{}
+	ctrl := gomock.NewController(&t)
+	defer ctrl.Finish()
+
+	keystoneMock, storageMock := setupTest(ctrl)
+
+	outputFormat = "value"
+	openMetrics = true
+	selector = "vmware_name=\"win_cifs_13\""
+
+	// Prometheus falls back to the text format, which is converted
+	expectAuth(keystoneMock)
+	storageMock.EXPECT().Federate(test.MatchContext(), []string{"{" + selector + "}"}, storage.FederateAccept(expfmt.NewFormat(expfmt.TypeOpenMetrics))).Return(test.HTTPResponseFromFile("fixtures/federate.txt"), nil)
+
+	snapshotCmd.RunE(snapshotCmd, []string{}) //nolint:errcheck
+
+	// Output:
+	// # TYPE vcenter_cpu_costop_summation unknown
+	// vcenter_cpu_costop_summation{component="vcenter-exporter-vc-a-0",instance="100.65.0.252:9102",instance_uuid="3b32f415-c953-40b9-883d-51321611a7d4",job="endpoints",kubernetes_name="vcenter-exporter-vc-a-0",kubernetes_namespace="maia",metric_detail="3",project_id="12345",region="staging",service="metrics",system="openstack",vcenter_name="STAGINGA",vcenter_node="10.44.2.40",vmware_name="win_cifs_13"} 0.0 1.500291187275e+09
+	// # EOF
+}
+
 func ExampleSeries_json() {
 	t := testReporter{}
 	ctrl := gomock.NewController(t)
@@ -113,7 +140,7 @@ func ExampleSeries_json() {
 	outputFormat = "jsoN"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().Series([]string{"{" + selector + "}"}, starttime, endtime, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
+	storageMock.EXPECT().Series(test.MatchContext(), []string{"{" + selector + "}"}, starttime, endtime, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
 
 	seriesCmd.RunE(seriesCmd, []string{}) //nolint:errcheck
 
@@ -149,7 +176,7 @@ func ExampleSeries_table() {
 	outputFormat = "table"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().Series([]string{"{" + selector + "}"}, starttime, endtime, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
+	storageMock.EXPECT().Series(test.MatchContext(), []string{"{" + selector + "}"}, starttime, endtime, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/series.json"), nil)
 
 	seriesCmd.RunE(seriesCmd, []string{}) //nolint:errcheck
 
@@ -169,7 +196,7 @@ func ExampleLabelValues_json() {
 	outputFormat = "jSon"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().LabelValues(labelName, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values.json"), nil)
+	storageMock.EXPECT().LabelValues(test.MatchContext(), labelName, nil, "", "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values.json"), nil)
 
 	labelValuesCmd.RunE(labelValuesCmd, []string{labelName}) //nolint:errcheck
 
@@ -193,7 +220,7 @@ func ExampleLabelValues_values() {
 	outputFormat = "VaLue"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().LabelValues(labelName, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values.json"), nil)
+	storageMock.EXPECT().LabelValues(test.MatchContext(), labelName, nil, "", "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/label_values.json"), nil)
 
 	labelValuesCmd.RunE(labelValuesCmd, []string{labelName}) //nolint:errcheck
 
@@ -211,7 +238,7 @@ func ExampleMetricNames_values() {
 	outputFormat = "valuE"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().LabelValues("__name__", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/metric_names.json"), nil)
+	storageMock.EXPECT().LabelValues(test.MatchContext(), "__name__", nil, "", "", storage.JSON).Return(test.HTTPResponseFromFile("fixtures/metric_names.json"), nil)
 
 	metricNamesCmd.RunE(metricNamesCmd, []string{}) //nolint:errcheck
 
@@ -236,7 +263,7 @@ func ExampleQuery_json() {
 	outputFormat = "jsoN"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().Query(query, timestamp, timeoutStr, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
+	storageMock.EXPECT().Query(test.MatchContextWithDeadline(timeout), query, timestamp, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
 
 	queryCmd.RunE(queryCmd, []string{query}) //nolint:errcheck
 
@@ -272,7 +299,7 @@ func ExampleQuery_table() {
 	outputFormat = "TaBle"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().Query(query, timestamp, timeoutStr, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
+	storageMock.EXPECT().Query(test.MatchContextWithDeadline(timeout), query, timestamp, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query.json"), nil)
 
 	queryCmd.RunE(queryCmd, []string{query}) //nolint:errcheck
 
@@ -296,7 +323,7 @@ func ExampleQuery_tableColumns() {
 	columns = "domain"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().Query(query, timestamp, timeoutStr, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query2.json"), nil)
+	storageMock.EXPECT().Query(test.MatchContextWithDeadline(timeout), query, timestamp, storage.JSON).Return(test.HTTPResponseFromFile("fixtures/query2.json"), nil)
 
 	queryCmd.RunE(queryCmd, []string{query}) //nolint:errcheck
 
@@ -323,7 +350,7 @@ func ExampleQuery_rangeJSON() {
 	outputFormat = "jsoN"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().QueryRange(query, starttime, endtime, stepsizeStr, timeoutStr, "application/json").Return(test.HTTPResponseFromFile("fixtures/query_range_values.json"), nil)
+	storageMock.EXPECT().QueryRange(test.MatchContextWithDeadline(timeout), query, starttime, endtime, stepsizeStr, "application/json").Return(test.HTTPResponseFromFile("fixtures/query_range_values.json"), nil)
 
 	queryCmd.RunE(queryCmd, []string{query}) //nolint:errcheck
 
@@ -368,7 +395,7 @@ func ExampleQuery_rangeValuesTable() {
 	outputFormat = "tablE"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().QueryRange(query, starttime, endtime, stepsizeStr, timeoutStr, "application/json").Return(test.HTTPResponseFromFile("fixtures/query_range_values.json"), nil)
+	storageMock.EXPECT().QueryRange(test.MatchContextWithDeadline(timeout), query, starttime, endtime, stepsizeStr, "application/json").Return(test.HTTPResponseFromFile("fixtures/query_range_values.json"), nil)
 
 	queryCmd.RunE(queryCmd, []string{query}) //nolint:errcheck
 
@@ -395,7 +422,7 @@ func ExampleQuery_rangeSeriesTable() {
 	columns = "region,check,instance"
 
 	expectAuth(keystoneMock)
-	storageMock.EXPECT().QueryRange(query, starttime, endtime, stepsizeStr, timeoutStr, "application/json").Return(test.HTTPResponseFromFile("fixtures/query_range_series.json"), nil)
+	storageMock.EXPECT().QueryRange(test.MatchContextWithDeadline(timeout), query, starttime, endtime, stepsizeStr, "application/json").Return(test.HTTPResponseFromFile("fixtures/query_range_series.json"), nil)
 
 	queryCmd.RunE(queryCmd, []string{query}) //nolint:errcheck
 
@@ -575,7 +602,7 @@ func TestGlobalFlagPropagation(t *testing.T) {
 			driver := storage.Prometheus(ts.URL, headers)
 
 			// Make a request
-			_, err := driver.Query("up", "", "", "application/json")
+			_, err := driver.Query(t.Context(), "up", "", "application/json")
 			assert.NoError(t, err, "Query should not return an error")
 
 			// Verify headers
@@ -839,10 +866,61 @@ func TestStorageDriverWithGlobalFlag(t *testing.T) {
 			testDriver := storage.Prometheus(testServer.URL, headers)
 
 			// Make a query to verify the header behavior
-			resp, err := testDriver.Query("up", "", "", "application/json")
+			resp, err := testDriver.Query(t.Context(), "up", "", "application/json")
 			assert.NoError(t, err, "Query should not return an error")
 			assert.Equal(t, http.StatusOK, resp.StatusCode, "Response should be successful")
 			resp.Body.Close()
 		})
 	}
 }
+
+func TestChildProjectsFlagsPropagation(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	setupTest(ctrl)
+	defer func() {
+		storageDriver = nil
+		auth.IdentityEndpoint = ""
+		auth.TokenID = ""
+	}()
+
+	tests := []struct {
+		name            string
+		noChildren      bool
+		childProjects   []string
+		includeChildren string
+		selected        string
+	}{
+		{name: "Without flags"},
+		{name: "With --no-children", noChildren: true, includeChildren: "false"},
+		{name: "With --child-projects", childProjects: []string{"67890", "67891"}, selected: "67890,67891"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var receivedHeaders http.Header
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				receivedHeaders = r.Header
+				w.Header().Set("Content-Type", "application/json")
+				if _, err := w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`)); err != nil {
+					t.Errorf("Failed to write response: %v", err)
+				}
+			}))
+			defer ts.Close()
+
+			// a token and the Maia URL skip the authentication
+			storageDriver = nil
+			auth.IdentityEndpoint = "http://keystone:5000/v3"
+			auth.TokenID = "test-token"
+			maiaURL = ts.URL
+			noChildren = tc.noChildren
+			childProjects = tc.childProjects
+
+			resp, err := storageInstance().Query(t.Context(), "up", "", "application/json")
+			assert.NoError(t, err, "Query should not return an error")
+			resp.Body.Close()
+
+			assert.Equal(t, tc.includeChildren, receivedHeaders.Get("X-Include-Children"))
+			assert.Equal(t, tc.selected, receivedHeaders.Get("X-Child-Projects"))
+		})
+	}
+}
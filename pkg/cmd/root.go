@@ -17,6 +17,8 @@ var configFile string
 var promURL string
 var version = "1.0.7"
 var useGlobalKeystone bool
+var noChildren bool
+var childProjects []string
 
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
@@ -60,6 +62,8 @@ func setDefaultConfig() {
 	viper.SetDefault("maia.storage_driver", "prometheus")
 	viper.SetDefault("maia.label_value_ttl", "1h")
 	viper.SetDefault("maia.label_value_for_global_visibility", "")
+	viper.SetDefault("maia.scope_labels.project", []string{"project_id"})
+	viper.SetDefault("maia.scope_labels.domain", []string{"domain_id"})
 	viper.SetDefault("keystone.token_cache_time", "900s")
 	viper.SetDefault("keystone.roles", "monitoring_viewer,monitoring_admin")
 	viper.SetDefault("keystone.default_user_domain_name", "Default")
@@ -78,4 +82,6 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&configFile, "config-file", "", "/etc/maia/maia.conf", "Configuration file to use")
 	RootCmd.PersistentFlags().Bool("version", false, "Print version information and quit")
 	RootCmd.PersistentFlags().BoolVar(&useGlobalKeystone, "global", false, "Use global keystone backend for metrics queries")
+	RootCmd.PersistentFlags().BoolVar(&noChildren, "no-children", false, "Limit metrics queries to the project of the token, leaving out its child projects")
+	RootCmd.PersistentFlags().StringSliceVar(&childProjects, "child-projects", nil, "Limit metrics queries to the given projects of the project hierarchy (comma-separated IDs)")
 }
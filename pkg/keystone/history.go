@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package keystone
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// projectHistory records which projects have been below a project (or domain) in the project hierarchy over time.
+// Projects that have been deleted stay in the scope of their former parents for the retention period, so that their
+// metrics can still be queried e.g. for post-mortems. Projects that have only been moved or disabled are dropped, since
+// they are still visible in their new place. The record is kept in a JSON file, so that it survives restarts of Maia.
+type projectHistory struct {
+	mutex     sync.Mutex
+	path      string
+	retention time.Duration
+	// parent ID --> child project ID --> history of the child below the parent
+	entries map[string]map[string]historyEntry
+	now     func() time.Time
+}
+
+// historyEntry is the history of a project below one of its (former) parents
+type historyEntry struct {
+	// last time the project was seen below the parent
+	LastSeen time.Time `json:"last_seen"`
+	// whether Keystone has confirmed that the project has been deleted
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// newProjectHistory loads the project history from the given file, which need not exist yet
+func newProjectHistory(path string, retention time.Duration) (*projectHistory, error) {
+	h := &projectHistory{path: path, retention: retention, entries: map[string]map[string]historyEntry{}, now: time.Now}
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &h.entries); err != nil {
+		return nil, fmt.Errorf("invalid project history in %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// record notes the current child projects of a parent and returns them together with the deleted former children
+// that have been seen within the retention period. Former children are looked up with isDeleted once, since a project
+// that is no longer listed below its parent may as well have been moved or disabled. Former children whose lookup fails
+// are left out until the next call. The file is only written when the history has changed.
+func (h *projectHistory) record(parentID string, children []string, isDeleted func(projectID string) (bool, error)) ([]string, error) {
+	deleted, unconfirmed, changed := h.update(parentID, children)
+
+	// the lookups happen without holding the lock, so that they do not block the history of other parents
+	var errs []error
+	checked := map[string]bool{}
+	for _, id := range unconfirmed {
+		ok, err := isDeleted(id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot check whether project %s has been deleted: %w", id, err))
+			continue
+		}
+		checked[id] = ok
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	seen := h.entries[parentID]
+	for _, id := range slices.Sorted(maps.Keys(checked)) {
+		entry, exists := seen[id]
+		if !exists {
+			continue
+		}
+		changed = true
+		if !checked[id] {
+			delete(seen, id)
+			continue
+		}
+		entry.Deleted = true
+		seen[id] = entry
+		deleted = append(deleted, id)
+	}
+	if seen != nil && len(seen) == 0 {
+		delete(h.entries, parentID)
+	}
+
+	slices.Sort(deleted)
+	result := append(slices.Clone(children), deleted...)
+	if changed {
+		errs = append(errs, h.save())
+	}
+	return result, errors.Join(errs...)
+}
+
+// update notes the current child projects of a parent, drops the expired ones and returns the former children that
+// are known to be deleted and the ones that have not been looked up yet
+func (h *projectHistory) update(parentID string, children []string) (deleted, unconfirmed []string, changed bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := h.now().UTC().Truncate(time.Second)
+	seen := h.entries[parentID]
+	if seen == nil {
+		seen = map[string]historyEntry{}
+		h.entries[parentID] = seen
+	}
+	for _, id := range children {
+		seen[id] = historyEntry{LastSeen: now}
+	}
+
+	changed = len(children) > 0
+	for _, id := range slices.Sorted(maps.Keys(seen)) {
+		switch {
+		case now.Sub(seen[id].LastSeen) > h.retention:
+			delete(seen, id)
+			changed = true
+		case slices.Contains(children, id):
+			// still a child
+		case seen[id].Deleted:
+			deleted = append(deleted, id)
+		default:
+			unconfirmed = append(unconfirmed, id)
+		}
+	}
+	if len(seen) == 0 {
+		delete(h.entries, parentID)
+	}
+	return deleted, unconfirmed, changed
+}
+
+// save writes the history to a temporary file and renames it, so that the file is never left incomplete
+func (h *projectHistory) save() error {
+	buf, err := json.Marshal(h.entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(h.path), filepath.Base(h.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), h.path)
+}
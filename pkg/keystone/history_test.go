@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package keystone
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "projects.json")
+	h, err := newProjectHistory(path, 24*time.Hour)
+	if !assert.NoError(t, err) {
+		return
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+	var lookups []string
+	isDeleted := func(id string) (bool, error) {
+		lookups = append(lookups, id)
+		return id == "c2", nil
+	}
+
+	result, err := h.record("p1", []string{"c1", "c2", "c3"}, isDeleted)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c1", "c2", "c3"}, result)
+	assert.Empty(t, lookups)
+
+	// deleted projects stay in the scope of their former parent for the retention period, moved ones do not
+	now = now.Add(12 * time.Hour)
+	result, err = h.record("p1", []string{"c1"}, isDeleted)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c1", "c2"}, result)
+	assert.Equal(t, []string{"c2", "c3"}, lookups)
+
+	// the history survives restarts and deleted projects are not looked up again
+	h, err = newProjectHistory(path, 24*time.Hour)
+	if !assert.NoError(t, err) {
+		return
+	}
+	h.now = func() time.Time { return now.Add(6 * time.Hour) }
+	result, err = h.record("p1", []string{}, isDeleted)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c2"}, result, "c1 has been moved")
+	assert.Equal(t, []string{"c2", "c3", "c1"}, lookups)
+
+	h.now = func() time.Time { return now.Add(24 * time.Hour) }
+	result, err = h.record("p1", []string{}, isDeleted)
+	assert.NoError(t, err)
+	assert.Empty(t, result, "c2 has expired")
+
+	result, err = h.record("p2", []string{}, isDeleted)
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+}
+
+func TestProjectHistory_lookupFailure(t *testing.T) {
+	h, err := newProjectHistory(filepath.Join(t.TempDir(), "projects.json"), 24*time.Hour)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = h.record("p1", []string{"c1"}, nil)
+	assert.NoError(t, err)
+
+	// a project is only kept once its deletion has been confirmed
+	result, err := h.record("p1", []string{}, func(string) (bool, error) { return false, errors.New("keystone unavailable") })
+	assert.ErrorContains(t, err, "keystone unavailable")
+	assert.Empty(t, result)
+	result, err = h.record("p1", []string{}, func(string) (bool, error) { return true, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c1"}, result)
+}
+
+func TestProjectHistory_errorInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "projects.json")
+	assert.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+	_, err := newProjectHistory(path, time.Hour)
+	assert.ErrorContains(t, err, "invalid project history")
+}
@@ -63,6 +63,10 @@ type keystone struct {
 	domainIDs map[string]string
 	// Configuration section for viper keys
 	configSection string
+	// whether disabled projects are child projects as well
+	includeDisabledProjects bool
+	// former child projects, nil if disabled
+	projectHistory *projectHistory
 }
 
 func (d *keystone) init() {
@@ -73,6 +77,18 @@ func (d *keystone) init() {
 	d.projectScopeCache = cache.New(time.Hour*24, time.Hour)
 	d.serviceConnMutex = &sync.Mutex{}
 	d.serviceTokenMutex = &sync.Mutex{}
+	d.includeDisabledProjects = viper.GetBool(d.configKey("include_disabled_child_projects"))
+	if path := viper.GetString(d.configKey("project_history.path")); path != "" {
+		retention := viper.GetDuration(d.configKey("project_history.retention"))
+		if retention <= 0 {
+			panic(fmt.Errorf("%s must be a positive duration", d.configKey("project_history.retention")))
+		}
+		history, err := newProjectHistory(path, retention)
+		if err != nil {
+			panic(err)
+		}
+		d.projectHistory = history
+	}
 	if viper.Get("keystone.username") != nil {
 		// force service logon to check validity early
 		// this will set d.providerClient
@@ -292,6 +308,14 @@ func (d *keystone) authOptionsFromConfig() gophercloud.AuthOptions {
 	}
 }
 
+// configKey returns the viper key of a setting in the configured keystone section
+func (d *keystone) configKey(name string) string {
+	if d.configSection != "" {
+		return "keystone." + d.configSection + "." + name
+	}
+	return "keystone." + name
+}
+
 // getAuthURL returns the auth URL for the configured keystone section
 func (d *keystone) getAuthURL() string {
 	section := "keystone"
@@ -706,18 +730,41 @@ func (d *keystone) ChildProjects(ctx context.Context, projectID string) ([]strin
 	}
 
 	logg.Debug("[CHILD_PROJECTS_DEBUG] [%s-keystone] Fetched child projects for %s: %v", keystoneContext, projectID, childprojects)
+	if d.projectHistory != nil {
+		// a failure to update the history must not break queries, the projects are recorded again on the next fetch
+		childprojects, err = d.projectHistory.record(projectID, childprojects, func(id string) (bool, error) {
+			return d.projectDeleted(ctx, id)
+		})
+		if err != nil {
+			logg.Error("[%s-keystone] Unable to update project history: %s", keystoneContext, err.Error())
+		}
+	}
 	d.projectTreeCache.Set(projectID, childprojects, cache.DefaultExpiration)
 	return childprojects, nil
 }
 
+// projectDeleted checks whether a project has been deleted, as opposed to e.g. moved to another parent or disabled
+func (d *keystone) projectDeleted(ctx context.Context, projectID string) (bool, error) {
+	_, err := projects.Get(ctx, d.providerClient, projectID).Extract()
+	if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
 // fetchChildProjects builds the full hierarchy of child-projects. This is used
 // e.g. to compute the right project_id filter expression in the PromQL queries
-// generated by Maia
+// generated by Maia. Disabled projects (and thus their children) are only
+// included with keystone.include_disabled_child_projects.
 func (d *keystone) fetchChildProjects(ctx context.Context, projectID string) ([]string, error) {
 	projectIDs := []string{}
-	enabledVal := true
+	opts := projects.ListOpts{ParentID: projectID}
+	if !d.includeDisabledProjects {
+		enabledVal := true
+		opts.Enabled = &enabledVal
+	}
 	// iterate of all pages returned by the list-projects API call
-	err := projects.List(d.providerClient, projects.ListOpts{ParentID: projectID, Enabled: &enabledVal}).EachPage(ctx, func(ctx context.Context, page pagination.Page) (bool, error) {
+	err := projects.List(d.providerClient, opts).EachPage(ctx, func(ctx context.Context, page pagination.Page) (bool, error) {
 		slice, err := projects.ExtractProjects(page)
 		if err != nil {
 			return false, err
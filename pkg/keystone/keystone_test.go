@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -161,6 +162,78 @@ func TestChildProjects(t *testing.T) {
 	assertDone(t)
 }
 
+func TestChildProjects_includeDisabledWithHistory(t *testing.T) {
+	defer gock.Off()
+	viper.Set("keystone.include_disabled_child_projects", true)
+	viper.Set("keystone.project_history.path", filepath.Join(t.TempDir(), "projects.json"))
+	viper.Set("keystone.project_history.retention", "720h")
+	defer viper.Set("keystone.include_disabled_child_projects", nil)
+	defer viper.Set("keystone.project_history.path", "")
+
+	ks := setupTest()
+	ctx := t.Context()
+	withoutEnabled := func(req *http.Request, _ *gock.Request) (bool, error) { return !req.URL.Query().Has("enabled"), nil }
+
+	// disabled projects are listed as well
+	gock.New(baseURL).Get("/v3/projects").MatchParam("parent_id", "p00001").AddMatcher(withoutEnabled).Reply(http.StatusOK).File("fixtures/child_projects.json").AddHeader("Content-Type", "application/json")
+	gock.New(baseURL).Get("/v3/projects").MatchParam("parent_id", "p00002").AddMatcher(withoutEnabled).Reply(http.StatusOK).BodyString(`{ "projects": [] }`).AddHeader("Content-Type", "application/json")
+	ids, err := ks.ChildProjects(ctx, "p00001")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p00002"}, ids)
+	assertDone(t)
+
+	// after its deletion, the project is still a child of its former parent
+	ks.(*keystone).projectTreeCache.Flush()
+	gock.New(baseURL).Get("/v3/projects").MatchParam("parent_id", "p00001").Reply(http.StatusOK).BodyString(`{ "projects": [] }`).AddHeader("Content-Type", "application/json")
+	gock.New(baseURL).Get("/v3/projects/p00002").Reply(http.StatusNotFound).BodyString(`{ "error": { "code": 404, "message": "Could not find project: p00002." } }`).AddHeader("Content-Type", "application/json")
+	ids, err = ks.ChildProjects(ctx, "p00001")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p00002"}, ids)
+	assertDone(t)
+
+	// the deletion is only looked up once
+	ks.(*keystone).projectTreeCache.Flush()
+	gock.New(baseURL).Get("/v3/projects").MatchParam("parent_id", "p00001").Reply(http.StatusOK).BodyString(`{ "projects": [] }`).AddHeader("Content-Type", "application/json")
+	ids, err = ks.ChildProjects(ctx, "p00001")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"p00002"}, ids)
+	assertDone(t)
+}
+
+func TestChildProjects_historyDropsMovedAndDisabledProjects(t *testing.T) {
+	cases := map[string]string{
+		"moved":    `{ "project": { "domain_id": "d00001", "enabled": true, "id": "p00002", "name": "Child", "parent_id": "p00003" } }`,
+		"disabled": `{ "project": { "domain_id": "d00001", "enabled": false, "id": "p00002", "name": "Child", "parent_id": "p00001" } }`,
+	}
+	for name, project := range cases {
+		t.Run(name, func(t *testing.T) {
+			defer gock.Off()
+			viper.Set("keystone.project_history.path", filepath.Join(t.TempDir(), "projects.json"))
+			viper.Set("keystone.project_history.retention", "720h")
+			defer viper.Set("keystone.project_history.path", "")
+
+			ks := setupTest()
+			ctx := t.Context()
+
+			gock.New(baseURL).Get("/v3/projects").MatchParams(map[string]string{"enabled": "true", "parent_id": "p00001"}).Reply(http.StatusOK).File("fixtures/child_projects.json").AddHeader("Content-Type", "application/json")
+			gock.New(baseURL).Get("/v3/projects").MatchParams(map[string]string{"enabled": "true", "parent_id": "p00002"}).Reply(http.StatusOK).BodyString(`{ "projects": [] }`).AddHeader("Content-Type", "application/json")
+			ids, err := ks.ChildProjects(ctx, "p00001")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"p00002"}, ids)
+			assertDone(t)
+
+			// the project still exists in Keystone, so it is no longer a child of its former parent
+			ks.(*keystone).projectTreeCache.Flush()
+			gock.New(baseURL).Get("/v3/projects").MatchParams(map[string]string{"enabled": "true", "parent_id": "p00001"}).Reply(http.StatusOK).BodyString(`{ "projects": [] }`).AddHeader("Content-Type", "application/json")
+			gock.New(baseURL).Get("/v3/projects/p00002").Reply(http.StatusOK).BodyString(project).AddHeader("Content-Type", "application/json")
+			ids, err = ks.ChildProjects(ctx, "p00001")
+			assert.NoError(t, err)
+			assert.Empty(t, ids)
+			assertDone(t)
+		})
+	}
+}
+
 func TestAuthenticateRequest(t *testing.T) {
 	defer gock.Off()
 
@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"context"
+	"net/http"
+)
+
+// Driver is an interface that wraps the remote write target of Maia, e.g. Prometheus with the remote write receiver
+// enabled. Because it is an interface, the real implementation can be mocked away in unit tests.
+type Driver interface {
+	// Write sends a snappy-compressed prompb.WriteRequest to the target and returns its response unchanged
+	Write(ctx context.Context, request []byte) (*http.Response, error)
+}
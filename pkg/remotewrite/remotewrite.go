@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/sapcc/go-bits/logg"
+	"github.com/spf13/viper"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/storage"
+)
+
+// Version is the version of the remote write protocol that is forwarded to the target
+const Version = "0.1.0"
+
+// remoteWriteClient forwards write requests to a single remote write endpoint
+type remoteWriteClient struct {
+	url    *url.URL
+	client *http.Client
+}
+
+// NewRemoteWriteDriver creates a Driver for the remote write target configured in the [maia.remote_write] section.
+// Authentication and TLS are configured like for Prometheus, e.g. in [maia.remote_write.basic_auth].
+func NewRemoteWriteDriver() Driver {
+	rawURL := viper.GetString("maia.remote_write.url")
+	if rawURL == "" {
+		panic(errors.New("remote write target not configured (maia.remote_write.url)"))
+	}
+	targetURL, err := url.Parse(rawURL)
+	if err != nil || (targetURL.Scheme != "http" && targetURL.Scheme != "https") {
+		panic(fmt.Errorf("invalid maia.remote_write.url setting: %q", rawURL))
+	}
+	client, err := storage.NewHTTPClientFromConfig("maia.remote_write")
+	if err != nil {
+		panic(fmt.Errorf("invalid [maia.remote_write] settings: %w", err))
+	}
+	logg.Info("Forwarding remote write requests to: \"%s\"", targetURL)
+
+	return &remoteWriteClient{url: targetURL, client: client}
+}
+
+func (c *remoteWriteClient) Write(ctx context.Context, request []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url.String(), bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", Version)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		logg.Error("Remote write request failed: %s", err.Error())
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	defer gock.Off()
+
+	viper.Set("maia.remote_write.url", "http://prometheus.local/api/v1/write")
+	viper.Set("maia.remote_write.basic_auth.username", "maia")
+	viper.Set("maia.remote_write.basic_auth.password", "secret")
+	t.Cleanup(func() { viper.Set("maia.remote_write", nil) })
+	driver := NewRemoteWriteDriver()
+
+	gock.New("http://prometheus.local").Post("/api/v1/write").
+		MatchHeader("Content-Encoding", "^snappy$").
+		MatchHeader("Content-Type", "^application/x-protobuf$").
+		MatchHeader("X-Prometheus-Remote-Write-Version", "^0.1.0$").
+		MatchHeader("Authorization", "^Basic bWFpYTpzZWNyZXQ=$").
+		Reply(http.StatusNoContent)
+
+	resp, err := driver.Write(t.Context(), []byte("request"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.True(t, gock.IsDone())
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/spf13/viper"
+)
+
+// backendConfig contains the settings of a single named Prometheus backend configured
+// in a [maia.backends.<name>] section of the configuration file
+type backendConfig struct {
+	Name          string
+	PrometheusURL string
+	FederateURL   string
+	// Replicas are the URLs of further Prometheus instances with the same data as PrometheusURL
+	Replicas []string
+	// Connection contains the authentication and TLS settings for PrometheusURL and Replicas
+	Connection upstreamConfig
+	// FederateConnection contains the authentication and TLS settings for FederateURL
+	FederateConnection upstreamConfig
+}
+
+// backendsFromConfig reads all [maia.backends.<name>] sections, sorted by name
+func backendsFromConfig() []backendConfig {
+	names := make([]string, 0)
+	for name := range viper.GetStringMap("maia.backends") {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	backends := make([]backendConfig, 0, len(names))
+	for _, name := range names {
+		section := "maia.backends." + name
+		backend := backendConfig{
+			Name:          name,
+			PrometheusURL: viper.GetString(section + ".prometheus_url"),
+			FederateURL:   viper.GetString(section + ".federate_url"),
+			Replicas:      viper.GetStringSlice(section + ".replicas"),
+			Connection:    upstreamConfigFromViper(section),
+		}
+		backend.FederateConnection = federateUpstreamConfigFromViper(section, backend.Connection)
+		if backend.PrometheusURL == "" {
+			panic(fmt.Errorf("backend %s has no prometheus_url configured (%s.prometheus_url)", name, section))
+		}
+		backends = append(backends, backend)
+	}
+
+	return backends
+}
+
+// namedDriver is a storage Driver for a single backend together with the backend's name
+type namedDriver struct {
+	name   string
+	driver Driver
+}
+
+// newBackendDrivers creates a Prometheus storage client for each of the given backends
+func newBackendDrivers(backends []backendConfig, customHeaders map[string]string) []namedDriver {
+	drivers := make([]namedDriver, len(backends))
+	for i, backend := range backends {
+		drivers[i] = namedDriver{
+			name:   backend.Name,
+			driver: newPrometheusStorageClient(backend, customHeaders),
+		}
+	}
+	return drivers
+}
@@ -0,0 +1,324 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/viper"
+
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/util"
+)
+
+var queryCacheRequestsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "maia_query_cache_requests_count", Help: "Number of query_range time buckets looked up in the query result cache (result=hit|miss)"},
+	[]string{"result"})
+var queryCacheSizeGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "maia_query_cache_size_bytes", Help: "Estimated memory consumption of the query result cache"})
+
+func init() {
+	prometheus.MustRegister(queryCacheRequestsCounter, queryCacheSizeGauge)
+}
+
+// cachingStorageClient is a storage Driver that caches the results of range queries. A range query is split into
+// time buckets which are aligned to a multiple of the step. Buckets that lie completely in the past (older than
+// maxFreshness) do not change anymore and are served from the cache. Only the missing buckets and the recent tail
+// are fetched from the wrapped driver. All other requests are passed through.
+type cachingStorageClient struct {
+	next  Driver
+	cache *lruCache
+	// bucketInterval is the minimum length of a time bucket, rounded up to a multiple of the step of a query
+	bucketInterval time.Duration
+	// maxFreshness is the age below which results are not cached since late samples may still change them
+	maxFreshness time.Duration
+	// now is replaceable for tests
+	now func() time.Time
+}
+
+// withQueryCache wraps the driver into a caching layer if a cache size is configured in [maia.cache]
+func withQueryCache(driver Driver) Driver {
+	maxSizeMB := viper.GetInt64("maia.cache.max_size_mb")
+	if maxSizeMB <= 0 {
+		return driver
+	}
+
+	result := &cachingStorageClient{
+		next:           driver,
+		cache:          newLRUCache(maxSizeMB * 1024 * 1024),
+		bucketInterval: time.Hour,
+		maxFreshness:   10 * time.Minute,
+		now:            time.Now,
+	}
+	if viper.IsSet("maia.cache.bucket_interval") {
+		result.bucketInterval = viper.GetDuration("maia.cache.bucket_interval")
+	}
+	if viper.IsSet("maia.cache.max_freshness") {
+		result.maxFreshness = viper.GetDuration("maia.cache.max_freshness")
+	}
+	if result.bucketInterval <= 0 {
+		panic(errors.New("maia.cache.bucket_interval must be positive"))
+	}
+	logg.Info("Caching query_range results in buckets of %s (max. %d MB)", result.bucketInterval, maxSizeMB)
+
+	return result
+}
+
+func (c *cachingStorageClient) Query(ctx context.Context, query, time, acceptContentType string) (*http.Response, error) {
+	return c.next.Query(ctx, query, time, acceptContentType)
+}
+
+func (c *cachingStorageClient) Series(ctx context.Context, match []string, start, end, acceptContentType string) (*http.Response, error) {
+	return c.next.Series(ctx, match, start, end, acceptContentType)
+}
+
+func (c *cachingStorageClient) LabelValues(ctx context.Context, name string, match []string, start, end, acceptContentType string) (*http.Response, error) {
+	return c.next.LabelValues(ctx, name, match, start, end, acceptContentType)
+}
+
+func (c *cachingStorageClient) Labels(ctx context.Context, start, end string, match []string, acceptContentType string) (*http.Response, error) {
+	return c.next.Labels(ctx, start, end, match, acceptContentType)
+}
+
+func (c *cachingStorageClient) QueryExemplars(ctx context.Context, query, start, end, acceptContentType string) (*http.Response, error) {
+	return c.next.QueryExemplars(ctx, query, start, end, acceptContentType)
+}
+
+func (c *cachingStorageClient) Alerts(ctx context.Context) (*http.Response, error) {
+	return c.next.Alerts(ctx)
+}
+
+func (c *cachingStorageClient) Rules(ctx context.Context, ruleType string, ruleNames, ruleGroups []string) (*http.Response, error) {
+	return c.next.Rules(ctx, ruleType, ruleNames, ruleGroups)
+}
+
+func (c *cachingStorageClient) Metadata(ctx context.Context, metric, limitPerMetric, acceptContentType string) (*http.Response, error) {
+	return c.next.Metadata(ctx, metric, limitPerMetric, acceptContentType)
+}
+
+func (c *cachingStorageClient) Federate(ctx context.Context, selectors []string, acceptContentType string) (*http.Response, error) {
+	return c.next.Federate(ctx, selectors, acceptContentType)
+}
+
+func (c *cachingStorageClient) RemoteRead(ctx context.Context, request []byte) (*http.Response, error) {
+	return c.next.RemoteRead(ctx, request)
+}
+
+func (c *cachingStorageClient) Close() error {
+	return c.next.Close()
+}
+
+// cacheSegment is the part of a range query that falls into one time bucket. Timestamps are in milliseconds.
+type cacheSegment struct {
+	start, end int64
+	// key is empty if the segment cannot be cached (incomplete or recent bucket)
+	key    string
+	cached model.Matrix
+	hit    bool
+}
+
+// QueryRange serves the query from cached buckets where possible. The query is expected to be tenant-specific
+// already (see util.AddLabelConstraintToExpression), so that it can be used as cache key.
+func (c *cachingStorageClient) QueryRange(ctx context.Context, query, start, end, step, acceptContentType string) (*http.Response, error) {
+	segments, ok := c.plan(query, start, end, step, acceptContentType)
+	if !ok {
+		return c.next.QueryRange(ctx, query, start, end, step, acceptContentType)
+	}
+
+	var result model.Value = model.Matrix{}
+	var warnings []string
+	for i := 0; i < len(segments); {
+		if segments[i].hit {
+			var err error
+			result, err = mergeValues(result, copyMatrix(segments[i].cached))
+			if err != nil && !errors.Is(err, errPartialResult) {
+				return nil, err
+			}
+			i++
+			continue
+		}
+
+		// fetch consecutive missing segments with a single request
+		j := i
+		for j+1 < len(segments) && !segments[j+1].hit {
+			j++
+		}
+		resp, fetched, err := c.fetch(ctx, query, segments[i].start, segments[j].end, step, acceptContentType)
+		if err != nil || resp != nil {
+			// resp is an error response from upstream which is passed on unchanged
+			return resp, err
+		}
+		for _, seg := range segments[i : j+1] {
+			if seg.key != "" && len(fetched.Warnings) == 0 {
+				c.cache.Add(seg.key, sliceMatrix(fetched.Data.Value.(model.Matrix), seg.start, seg.end))
+			}
+		}
+		warnings = append(warnings, fetched.Warnings...)
+		// samples at the bucket boundaries may have changed since they were cached, the earlier segment wins then
+		result, err = mergeValues(result, fetched.Data.Value)
+		if err != nil && !errors.Is(err, errPartialResult) {
+			return nil, err
+		}
+		i = j + 1
+	}
+
+	matrix := result.(model.Matrix)
+	sort.Sort(matrix)
+	return jsonResponse(QueryResponse{
+		Status:   StatusSuccess,
+		Data:     QueryResult{Type: model.ValMatrix, Result: matrix, Value: matrix},
+		Warnings: warnings,
+	})
+}
+
+// plan splits a range query into segments and looks up the cacheable ones. It returns false if the query
+// is not eligible for caching.
+func (c *cachingStorageClient) plan(query, start, end, step, acceptContentType string) ([]cacheSegment, bool) {
+	if acceptContentType != "" && !strings.Contains(acceptContentType, JSON) && !strings.Contains(acceptContentType, "*/*") {
+		return nil, false
+	}
+	startTime, err := util.ParseTime(start)
+	if err != nil || start == "" {
+		return nil, false
+	}
+	endTime, err := util.ParseTime(end)
+	if err != nil || end == "" {
+		return nil, false
+	}
+	stepDuration, err := util.ParseDuration(step)
+	if err != nil || stepDuration < time.Millisecond {
+		return nil, false
+	}
+	// the result at a given timestamp must not depend on the range of the query
+	if dependsOnRange, err := util.DependsOnQueryRange(query); err != nil || dependsOnRange {
+		return nil, false
+	}
+
+	// Prometheus evaluates queries with millisecond precision
+	startMs := startTime.Round(time.Millisecond).UnixMilli()
+	endMs := endTime.Round(time.Millisecond).UnixMilli()
+	stepMs := stepDuration.Milliseconds()
+	if endMs < startMs {
+		return nil, false
+	}
+	lastMs := startMs + (endMs-startMs)/stepMs*stepMs
+	bucketMs := ceilDiv(c.bucketInterval.Milliseconds(), stepMs) * stepMs
+	immutableBefore := c.now().Add(-c.maxFreshness).UnixMilli()
+	// the grid of evaluation timestamps is defined by step and the offset of start
+	phase := mod(startMs, stepMs)
+
+	var segments []cacheSegment
+	cacheable := false
+	for bucketStart := floorDiv(startMs, bucketMs) * bucketMs; bucketStart <= lastMs; bucketStart += bucketMs {
+		// first and last evaluation timestamp in the bucket
+		firstInBucket := bucketStart + mod(phase-bucketStart, stepMs)
+		lastInBucket := firstInBucket + (bucketStart+bucketMs-1-firstInBucket)/stepMs*stepMs
+
+		seg := cacheSegment{start: max(startMs, firstInBucket), end: min(lastMs, lastInBucket)}
+		if seg.start == firstInBucket && seg.end == lastInBucket && bucketStart+bucketMs <= immutableBefore {
+			cacheable = true
+			seg.key = fmt.Sprintf("%s\x00%d\x00%d\x00%d", query, stepMs, phase, bucketStart)
+			seg.cached, seg.hit = c.cache.Get(seg.key)
+			if seg.hit {
+				queryCacheRequestsCounter.WithLabelValues("hit").Inc()
+			} else {
+				queryCacheRequestsCounter.WithLabelValues("miss").Inc()
+			}
+		}
+		segments = append(segments, seg)
+	}
+	// short or recent ranges are passed on unchanged
+	return segments, cacheable
+}
+
+// fetch sends a range query for the given timestamps to the wrapped driver. Unsuccessful responses are returned
+// as is, so that upstream errors reach the client unchanged.
+func (c *cachingStorageClient) fetch(ctx context.Context, query string, startMs, endMs int64, step, acceptContentType string) (*http.Response, *QueryResponse, error) {
+	resp, err := c.next.QueryRange(ctx, query, formatTimestamp(startMs), formatTimestamp(endMs), step, acceptContentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil, nil
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var qr QueryResponse
+	if err := json.Unmarshal(body, &qr); err != nil {
+		return nil, nil, fmt.Errorf("cannot decode query_range response: %w", err)
+	}
+	if _, ok := qr.Data.Value.(model.Matrix); !ok || qr.Status != StatusSuccess {
+		return bufferedResponse(resp.Header.Get("Content-Type"), body), nil, nil
+	}
+	return nil, &qr, nil
+}
+
+// sliceMatrix returns the samples of the matrix with timestamps in the given range (milliseconds)
+func sliceMatrix(m model.Matrix, startMs, endMs int64) model.Matrix {
+	result := model.Matrix{}
+	for _, s := range m {
+		stream := &model.SampleStream{Metric: s.Metric}
+		for _, v := range s.Values {
+			if int64(v.Timestamp) >= startMs && int64(v.Timestamp) <= endMs {
+				stream.Values = append(stream.Values, v)
+			}
+		}
+		for _, h := range s.Histograms {
+			if int64(h.Timestamp) >= startMs && int64(h.Timestamp) <= endMs {
+				stream.Histograms = append(stream.Histograms, h)
+			}
+		}
+		if len(stream.Values) > 0 || len(stream.Histograms) > 0 {
+			result = append(result, stream)
+		}
+	}
+	return result
+}
+
+// copyMatrix creates a copy of a cached matrix that can be modified while merging
+func copyMatrix(m model.Matrix) model.Matrix {
+	result := make(model.Matrix, len(m))
+	for i, s := range m {
+		result[i] = &model.SampleStream{
+			Metric:     s.Metric,
+			Values:     append([]model.SamplePair(nil), s.Values...),
+			Histograms: append([]model.SampleHistogramPair(nil), s.Histograms...),
+		}
+	}
+	return result
+}
+
+// formatTimestamp formats a timestamp in milliseconds as Unix timestamp for the Prometheus API
+func formatTimestamp(ms int64) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', 3, 64)
+}
+
+func floorDiv(a, b int64) int64 {
+	return (a - mod(a, b)) / b
+}
+
+func ceilDiv(a, b int64) int64 {
+	return -floorDiv(-a, b)
+}
+
+// mod returns the non-negative remainder of a divided by b
+func mod(a, b int64) int64 {
+	return ((a % b) + b) % b
+}
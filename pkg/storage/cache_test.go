@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupCacheTest(t *testing.T) Driver {
+	viper.Set("maia.cache.max_size_mb", 1)
+	viper.Set("maia.cache.bucket_interval", "10m")
+	t.Cleanup(func() {
+		viper.Set("maia.cache", nil)
+	})
+
+	return setupTest(t)
+}
+
+func TestCacheQueryRange_fetchesOnlyTail(t *testing.T) {
+	defer gock.Off()
+
+	cs := setupCacheTest(t)
+
+	// first request: nothing cached, the whole range is fetched at once
+	gock.New(prometheusURL).Get("/api/v1/query_range").
+		MatchParam("start", "^0.000$").
+		MatchParam("end", "^1800.000$").
+		MatchParam("step", "^60s$").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"resultType":"matrix","result":[`+
+			`{"metric":{"__name__":"up"},"values":[[0,"1"],[600,"1"],[1200,"0"],[1800,"1"]]}]}}`).
+		AddHeader("Content-Type", JSON)
+	// second request: the first three buckets are taken from the cache
+	gock.New(prometheusURL).Get("/api/v1/query_range").
+		MatchParam("start", "^1800.000$").
+		MatchParam("end", "^2400.000$").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"resultType":"matrix","result":[`+
+			`{"metric":{"__name__":"up"},"values":[[1800,"1"],[2400,"1"]]}]}}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := cs.QueryRange(t.Context(), "up", "0", "1800", "60s", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var qr QueryResponse
+	decodeBody(t, resp, &qr)
+
+	resp, err = cs.QueryRange(t.Context(), "up", "0", "2400", "60s", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	decodeBody(t, resp, &qr)
+	matrix, ok := qr.Data.Value.(model.Matrix)
+	if assert.True(t, ok, "expected matrix result") && assert.Len(t, matrix, 1) {
+		assert.Equal(t, []model.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: 600000, Value: 1},
+			{Timestamp: 1200000, Value: 0}, {Timestamp: 1800000, Value: 1}, {Timestamp: 2400000, Value: 1}}, matrix[0].Values)
+	}
+
+	assertDone(t)
+}
+
+func TestCacheQueryRange_passThrough(t *testing.T) {
+	defer gock.Off()
+
+	cs := setupCacheTest(t)
+
+	// results of queries using start() depend on the range, so they are never split
+	for range 2 {
+		gock.New(prometheusURL).Get("/api/v1/query_range").
+			MatchParam("query", `^up @ start\(\)$`).
+			MatchParam("start", "^0$").
+			MatchParam("end", "^1800$").
+			Reply(http.StatusOK).
+			File("fixtures/query.json").
+			AddHeader("Content-Type", JSON)
+	}
+
+	for range 2 {
+		resp, err := cs.QueryRange(t.Context(), "up @ start()", "0", "1800", "60s", JSON)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}
+
+	assertDone(t)
+}
+
+func TestCacheQueryRange_upstreamError(t *testing.T) {
+	defer gock.Off()
+
+	cs := setupCacheTest(t)
+
+	gock.New(prometheusURL).Get("/api/v1/query_range").
+		Reply(http.StatusBadRequest).
+		BodyString(`{"status":"error","errorType":"bad_data","error":"parse error"}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := cs.QueryRange(t.Context(), "up{", "0", "1800", "60s", JSON)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	assertDone(t)
+}
+
+func TestLRUCache_eviction(t *testing.T) {
+	value := model.Matrix{{Metric: model.Metric{"__name__": "up"}, Values: []model.SamplePair{{Timestamp: 0, Value: 1}}}}
+	size := matrixSize(value)
+	cache := newLRUCache(2 * size)
+
+	cache.Add("a", value)
+	cache.Add("b", value)
+	_, ok := cache.Get("a")
+	assert.True(t, ok)
+
+	// "b" is the least recently used entry now
+	cache.Add("c", value)
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	_, ok = cache.Get("a")
+	assert.True(t, ok)
+	_, ok = cache.Get("c")
+	assert.True(t, ok)
+
+	// values larger than the cache are not stored
+	newLRUCache(size-1).Add("d", value)
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// upstreamAcceptEncoding lists the compression methods offered to Prometheus. Responses are
+// decompressed by decodeResponse, so that drivers and API handlers always see the plain body.
+const upstreamAcceptEncoding = "zstd, gzip"
+
+// decodeResponse replaces the body of a gzip or zstd compressed response with a decompressing reader. The body
+// is decompressed while it is read, so that large responses can still be streamed to the client.
+func decodeResponse(resp *http.Response) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || resp.ContentLength == 0 {
+		return nil
+	}
+
+	body := &decodedBody{closers: []io.Closer{resp.Body}}
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("cannot decompress gzip response: %w", err)
+		}
+		body.Reader = reader
+		body.closers = append(body.closers, reader)
+	case "zstd":
+		reader, err := zstd.NewReader(resp.Body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return fmt.Errorf("cannot decompress zstd response: %w", err)
+		}
+		body.Reader = reader
+		body.closers = append(body.closers, reader.IOReadCloser())
+	default:
+		// passed on as is, e.g. snappy-compressed remote read responses
+		return nil
+	}
+
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// decodedBody reads the decompressed body and closes both the decompressor and the original body
+type decodedBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close implements the io.Closer interface.
+func (b *decodedBody) Close() error {
+	var result error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if err := b.closers[i].Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
@@ -0,0 +1,557 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+
+	"github.com/sapcc/go-bits/logg"
+)
+
+// fanoutStorageClient is a storage Driver that sends every request to all configured backends in parallel
+// and merges the results. Backends that fail are reported as Prometheus warnings instead of failing the
+// whole request, as long as at least one backend responded.
+type fanoutStorageClient struct {
+	backends []namedDriver
+}
+
+// backendResult is the raw outcome of a request to a single backend
+type backendResult struct {
+	name string
+	resp *http.Response
+	err  error
+}
+
+// backendResponse is the successful response of a single backend, read into memory
+type backendResponse struct {
+	name   string
+	header http.Header
+	body   []byte
+}
+
+// mergeFunc combines the successful responses of several backends into a single response
+type mergeFunc func(responses []backendResponse, warnings []string) (*http.Response, error)
+
+// Fanout creates a storage driver that distributes requests to all backends configured in the
+// [maia.backends.<name>] sections and merges their results
+func Fanout(customHeaders map[string]string) Driver {
+	backends := backendsFromConfig()
+	if len(backends) == 0 {
+		panic(errors.New("fanout storage driver requires at least one [maia.backends.<name>] section"))
+	}
+	for _, b := range backends {
+		logg.Info("Using API server %s at: \"%s\"", b.Name, b.PrometheusURL)
+	}
+	return &fanoutStorageClient{backends: newBackendDrivers(backends, customHeaders)}
+}
+
+func (f *fanoutStorageClient) Query(ctx context.Context, query, time, acceptContentType string) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.Query(ctx, query, time, acceptContentType)
+	}, mergeQueryResponses)
+}
+
+func (f *fanoutStorageClient) QueryRange(ctx context.Context, query, start, end, step, acceptContentType string) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.QueryRange(ctx, query, start, end, step, acceptContentType)
+	}, mergeQueryResponses)
+}
+
+func (f *fanoutStorageClient) Series(ctx context.Context, match []string, start, end, acceptContentType string) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.Series(ctx, match, start, end, acceptContentType)
+	}, mergeSeriesResponses)
+}
+
+func (f *fanoutStorageClient) LabelValues(ctx context.Context, name string, match []string, start, end, acceptContentType string) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.LabelValues(ctx, name, match, start, end, acceptContentType)
+	}, mergeLabelValuesResponses)
+}
+
+func (f *fanoutStorageClient) Labels(ctx context.Context, start, end string, match []string, acceptContentType string) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.Labels(ctx, start, end, match, acceptContentType)
+	}, mergeLabelValuesResponses)
+}
+
+func (f *fanoutStorageClient) Federate(ctx context.Context, selectors []string, acceptContentType string) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.Federate(ctx, selectors, acceptContentType)
+	}, func(responses []backendResponse, warnings []string) (*http.Response, error) {
+		return mergeFederateResponses(responses, warnings, acceptContentType)
+	})
+}
+
+func (f *fanoutStorageClient) QueryExemplars(ctx context.Context, query, start, end, acceptContentType string) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.QueryExemplars(ctx, query, start, end, acceptContentType)
+	}, mergeExemplarsResponses)
+}
+
+func (f *fanoutStorageClient) Metadata(ctx context.Context, metric, limitPerMetric, acceptContentType string) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.Metadata(ctx, metric, limitPerMetric, acceptContentType)
+	}, mergeMetadataResponses)
+}
+
+func (f *fanoutStorageClient) Alerts(ctx context.Context) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.Alerts(ctx)
+	}, mergeAlertsResponses)
+}
+
+func (f *fanoutStorageClient) Rules(ctx context.Context, ruleType string, ruleNames, ruleGroups []string) (*http.Response, error) {
+	return f.fanout(ctx, func(d Driver) (*http.Response, error) {
+		return d.Rules(ctx, ruleType, ruleNames, ruleGroups)
+	}, mergeRulesResponses)
+}
+
+// RemoteRead is not supported since the protobuf responses of several backends cannot be merged while streaming
+func (f *fanoutStorageClient) RemoteRead(_ context.Context, _ []byte) (*http.Response, error) {
+	return nil, errors.New("remote read is not supported by the fanout storage driver")
+}
+
+// Close closes all backends
+func (f *fanoutStorageClient) Close() error {
+	var errs []error
+	for _, b := range f.backends {
+		errs = append(errs, b.driver.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// fanout sends a request to all backends in parallel and merges the successful responses.
+// When all backends fail, the first upstream error response is passed on unchanged so that
+// e.g. bad_data errors reach the client just like with a single backend.
+func (f *fanoutStorageClient) fanout(ctx context.Context, call func(Driver) (*http.Response, error), merge mergeFunc) (*http.Response, error) {
+	results := make([]backendResult, len(f.backends))
+	var wg sync.WaitGroup
+	for i, backend := range f.backends {
+		wg.Go(func() {
+			resp, err := call(backend.driver)
+			results[i] = backendResult{name: backend.name, resp: resp, err: err}
+		})
+	}
+	wg.Wait()
+
+	// when the client has gone away, there is no point in merging partial results
+	if err := ctx.Err(); err != nil {
+		for _, r := range results {
+			if r.resp != nil {
+				r.resp.Body.Close()
+			}
+		}
+		return nil, err
+	}
+
+	var responses []backendResponse
+	var warnings []string
+	var failedResp *http.Response
+	for _, r := range results {
+		if r.err != nil {
+			logg.Info("WARNING: backend %s failed: %s", r.name, r.err.Error())
+			warnings = append(warnings, fmt.Sprintf("backend %s: %s", r.name, r.err.Error()))
+			continue
+		}
+		body, err := io.ReadAll(r.resp.Body)
+		r.resp.Body.Close()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s: %s", r.name, err.Error()))
+			continue
+		}
+		if r.resp.StatusCode >= http.StatusBadRequest {
+			logg.Info("WARNING: backend %s responded with %s", r.name, r.resp.Status)
+			warnings = append(warnings, fmt.Sprintf("backend %s: %s", r.name, upstreamErrorMessage(r.resp, body)))
+			if failedResp == nil {
+				r.resp.Body = io.NopCloser(bytes.NewReader(body))
+				failedResp = r.resp
+			}
+			continue
+		}
+		responses = append(responses, backendResponse{name: r.name, header: r.resp.Header, body: body})
+	}
+
+	if len(responses) == 0 {
+		if failedResp != nil {
+			return failedResp, nil
+		}
+		return nil, fmt.Errorf("all backends failed: %s", strings.Join(warnings, "; "))
+	}
+	return merge(responses, warnings)
+}
+
+// upstreamErrorMessage extracts the error message from a Prometheus error response
+func upstreamErrorMessage(resp *http.Response, body []byte) string {
+	var errResp Response
+	if json.Unmarshal(body, &errResp) == nil && errResp.Error != "" {
+		return errResp.Error
+	}
+	return resp.Status
+}
+
+// mergeQueryResponses merges the results of query and query_range calls. Vectors and matrices are
+// merged series by series, scalars and strings are taken from the first backend. Since every backend
+// evaluates the query on its own data, results of the same series (or scalars) that differ between the
+// backends are partial results, e.g. of an aggregation over series spread over several backends. They
+// cannot be combined, so they are reported as a warning.
+func mergeQueryResponses(responses []backendResponse, warnings []string) (*http.Response, error) {
+	var merged model.Value
+	for _, r := range responses {
+		var qr QueryResponse
+		if err := json.Unmarshal(r.body, &qr); err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s: cannot decode response: %s", r.name, err.Error()))
+			continue
+		}
+		warnings = append(warnings, qr.Warnings...)
+		var err error
+		merged, err = mergeValues(merged, qr.Data.Value)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s: %s", r.name, err.Error()))
+		}
+	}
+	if merged == nil {
+		return nil, fmt.Errorf("no decodable response from any backend: %s", strings.Join(warnings, "; "))
+	}
+
+	return jsonResponse(QueryResponse{
+		Status:   StatusSuccess,
+		Data:     QueryResult{Type: merged.Type(), Result: merged, Value: merged},
+		Warnings: warnings,
+	})
+}
+
+// errPartialResult reports that backends returned different results for the same series
+var errPartialResult = errors.New("the result differs from other backends, so it only covers the data of some backends")
+
+// mergeValues merges the query result b into a. The error reports results that cannot be merged, but the
+// merged value is still returned.
+func mergeValues(a, b model.Value) (model.Value, error) {
+	if a == nil {
+		return b, nil
+	}
+	if b == nil {
+		return a, nil
+	}
+	if a.Type() != b.Type() {
+		return a, fmt.Errorf("cannot merge result of type %s into %s", b.Type(), a.Type())
+	}
+
+	conflicts := 0
+	switch av := a.(type) {
+	case model.Vector:
+		known := make(map[model.Fingerprint]*model.Sample, len(av))
+		for _, s := range av {
+			known[s.Metric.Fingerprint()] = s
+		}
+		for _, s := range b.(model.Vector) {
+			fp := s.Metric.Fingerprint()
+			if existing, ok := known[fp]; !ok {
+				known[fp] = s
+				av = append(av, s)
+			} else if !existing.Value.Equal(s.Value) || !existing.Histogram.Equal(s.Histogram) {
+				conflicts++
+			}
+		}
+		a = av
+	case model.Matrix:
+		streams := make(map[model.Fingerprint]*model.SampleStream, len(av))
+		for _, s := range av {
+			streams[s.Metric.Fingerprint()] = s
+		}
+		for _, s := range b.(model.Matrix) {
+			if existing, ok := streams[s.Metric.Fingerprint()]; !ok {
+				streams[s.Metric.Fingerprint()] = s
+				av = append(av, s)
+			} else if !mergeSampleStreams(existing, s) {
+				conflicts++
+			}
+		}
+		a = av
+	case *model.Scalar:
+		// scalars are taken from the first backend
+		if bv := b.(*model.Scalar); !av.Value.Equal(bv.Value) {
+			conflicts++
+		}
+	case *model.String:
+		if bv := b.(*model.String); av.Value != bv.Value {
+			conflicts++
+		}
+	}
+	if conflicts > 0 {
+		return a, fmt.Errorf("%w (%d series)", errPartialResult, conflicts)
+	}
+	return a, nil
+}
+
+// mergeSampleStreams adds the samples of b to a, dropping duplicate timestamps. It returns false if a and b have
+// different values at the same timestamp.
+func mergeSampleStreams(a, b *model.SampleStream) bool {
+	consistent := true
+	a.Values = append(a.Values, b.Values...)
+	sort.SliceStable(a.Values, func(i, j int) bool { return a.Values[i].Timestamp < a.Values[j].Timestamp })
+	a.Values = slices.CompactFunc(a.Values, func(x, y model.SamplePair) bool {
+		if x.Timestamp != y.Timestamp {
+			return false
+		}
+		consistent = consistent && x.Value.Equal(y.Value)
+		return true
+	})
+
+	a.Histograms = append(a.Histograms, b.Histograms...)
+	sort.SliceStable(a.Histograms, func(i, j int) bool { return a.Histograms[i].Timestamp < a.Histograms[j].Timestamp })
+	a.Histograms = slices.CompactFunc(a.Histograms, func(x, y model.SampleHistogramPair) bool {
+		if x.Timestamp != y.Timestamp {
+			return false
+		}
+		consistent = consistent && x.Histogram.Equal(y.Histogram)
+		return true
+	})
+	return consistent
+}
+
+// mergeSeriesResponses builds the union of all series returned by the backends
+func mergeSeriesResponses(responses []backendResponse, warnings []string) (*http.Response, error) {
+	result := SeriesResponse{Status: StatusSuccess, Data: []model.LabelSet{}}
+	known := map[model.Fingerprint]bool{}
+	for _, r := range responses {
+		var sr SeriesResponse
+		if err := json.Unmarshal(r.body, &sr); err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s: cannot decode response: %s", r.name, err.Error()))
+			continue
+		}
+		warnings = append(warnings, sr.Warnings...)
+		for _, ls := range sr.Data {
+			if fp := ls.Fingerprint(); !known[fp] {
+				known[fp] = true
+				result.Data = append(result.Data, ls)
+			}
+		}
+	}
+	result.Warnings = warnings
+
+	return jsonResponse(result)
+}
+
+// mergeLabelValuesResponses builds the sorted union of label names or label values returned by the backends
+func mergeLabelValuesResponses(responses []backendResponse, warnings []string) (*http.Response, error) {
+	result := LabelValuesResponse{Status: StatusSuccess, Data: model.LabelValues{}}
+	known := map[model.LabelValue]bool{}
+	for _, r := range responses {
+		var lr LabelValuesResponse
+		if err := json.Unmarshal(r.body, &lr); err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s: cannot decode response: %s", r.name, err.Error()))
+			continue
+		}
+		warnings = append(warnings, lr.Warnings...)
+		for _, v := range lr.Data {
+			if !known[v] {
+				known[v] = true
+				result.Data = append(result.Data, v)
+			}
+		}
+	}
+	sort.Sort(result.Data)
+	result.Warnings = warnings
+
+	return jsonResponse(result)
+}
+
+// mergeExemplarsResponses merges the exemplars of identical series returned by several backends
+func mergeExemplarsResponses(responses []backendResponse, warnings []string) (*http.Response, error) {
+	result := ExemplarsResponse{Status: StatusSuccess, Data: []ExemplarQueryResult{}}
+	series := map[model.Fingerprint]int{}
+	for _, r := range responses {
+		var er ExemplarsResponse
+		if err := json.Unmarshal(r.body, &er); err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s: cannot decode response: %s", r.name, err.Error()))
+			continue
+		}
+		warnings = append(warnings, er.Warnings...)
+		for _, s := range er.Data {
+			fp := s.SeriesLabels.Fingerprint()
+			idx, ok := series[fp]
+			if !ok {
+				series[fp] = len(result.Data)
+				result.Data = append(result.Data, s)
+				continue
+			}
+			existing := &result.Data[idx]
+			existing.Exemplars = append(existing.Exemplars, s.Exemplars...)
+			sort.SliceStable(existing.Exemplars, func(i, j int) bool { return existing.Exemplars[i].Timestamp < existing.Exemplars[j].Timestamp })
+			existing.Exemplars = slices.CompactFunc(existing.Exemplars, func(x, y Exemplar) bool {
+				return x.Timestamp == y.Timestamp && x.Labels.Equal(y.Labels)
+			})
+		}
+	}
+	result.Warnings = warnings
+
+	return jsonResponse(result)
+}
+
+// mergeAlertsResponses concatenates the alerts of all backends. Each backend evaluates its own alerting rules,
+// so there is nothing to deduplicate.
+func mergeAlertsResponses(responses []backendResponse, warnings []string) (*http.Response, error) {
+	result := AlertsResponse{Status: StatusSuccess, Data: AlertsResult{Alerts: []Alert{}}}
+	for _, r := range responses {
+		var ar AlertsResponse
+		if err := json.Unmarshal(r.body, &ar); err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s: cannot decode response: %s", r.name, err.Error()))
+			continue
+		}
+		warnings = append(warnings, ar.Warnings...)
+		result.Data.Alerts = append(result.Data.Alerts, ar.Data.Alerts...)
+	}
+	result.Warnings = warnings
+
+	return jsonResponse(result)
+}
+
+// mergeRulesResponses concatenates the rule groups of all backends
+func mergeRulesResponses(responses []backendResponse, warnings []string) (*http.Response, error) {
+	result := RulesResponse{Status: StatusSuccess, Data: RulesResult{Groups: []RuleGroup{}}}
+	for _, r := range responses {
+		var rr RulesResponse
+		if err := json.Unmarshal(r.body, &rr); err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s: cannot decode response: %s", r.name, err.Error()))
+			continue
+		}
+		warnings = append(warnings, rr.Warnings...)
+		result.Data.Groups = append(result.Data.Groups, rr.Data.Groups...)
+	}
+	result.Warnings = warnings
+
+	return jsonResponse(result)
+}
+
+// mergeMetadataResponses builds the union of the metric metadata returned by the backends
+func mergeMetadataResponses(responses []backendResponse, warnings []string) (*http.Response, error) {
+	result := MetadataResponse{Status: StatusSuccess, Data: map[string][]MetricMetadata{}}
+	for _, r := range responses {
+		var mr MetadataResponse
+		if err := json.Unmarshal(r.body, &mr); err != nil {
+			warnings = append(warnings, fmt.Sprintf("backend %s: cannot decode response: %s", r.name, err.Error()))
+			continue
+		}
+		warnings = append(warnings, mr.Warnings...)
+		for metric, entries := range mr.Data {
+			for _, e := range entries {
+				if !slices.Contains(result.Data[metric], e) {
+					result.Data[metric] = append(result.Data[metric], e)
+				}
+			}
+		}
+	}
+	result.Warnings = warnings
+
+	return jsonResponse(result)
+}
+
+// mergeFederateResponses decodes the metric families of all backends, removes duplicate series
+// and encodes the result in the format requested by the client. Since the exposition formats have no
+// notion of warnings, failed backends are reported via the HTTP Warning header.
+func mergeFederateResponses(responses []backendResponse, warnings []string, acceptContentType string) (*http.Response, error) {
+	families := map[string]*dto.MetricFamily{}
+	known := map[string]map[model.Fingerprint]bool{}
+	for _, r := range responses {
+		decoder := expfmt.NewDecoder(bytes.NewReader(r.body), expfmt.ResponseFormat(r.header))
+		for {
+			var mf dto.MetricFamily
+			err := decoder.Decode(&mf)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("backend %s: cannot decode response: %s", r.name, err.Error()))
+				break
+			}
+			name := mf.GetName()
+			if _, ok := families[name]; !ok {
+				families[name] = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Unit: mf.Unit}
+				known[name] = map[model.Fingerprint]bool{}
+			}
+			for _, m := range mf.Metric {
+				ls := make(model.LabelSet, len(m.Label))
+				for _, lp := range m.Label {
+					ls[model.LabelName(lp.GetName())] = model.LabelValue(lp.GetValue())
+				}
+				if fp := ls.Fingerprint(); !known[name][fp] {
+					known[name][fp] = true
+					families[name].Metric = append(families[name].Metric, m)
+				}
+			}
+		}
+	}
+
+	resp, err := federateResponse(families, acceptContentType)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range warnings {
+		resp.Header.Add("Warning", "199 maia "+strconv.Quote(w))
+	}
+	return resp, nil
+}
+
+// federateResponse encodes the metric families sorted by name in the format requested by the client
+func federateResponse(families map[string]*dto.MetricFamily, acceptContentType string) (*http.Response, error) {
+	format := expfmt.Negotiate(http.Header{"Accept": []string{acceptContentType}})
+	var buf bytes.Buffer
+	encoder := expfmt.NewEncoder(&buf, format)
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := encoder.Encode(families[name]); err != nil {
+			return nil, err
+		}
+	}
+	if closer, ok := encoder.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return bufferedResponse(string(format), buf.Bytes()), nil
+}
+
+// jsonResponse wraps a merged Prometheus API response into a synthetic HTTP response
+func jsonResponse(data any) (*http.Response, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return bufferedResponse(JSON, body), nil
+}
+
+// bufferedResponse creates a synthetic successful HTTP response with the given body
+func bufferedResponse(contentType string, body []byte) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
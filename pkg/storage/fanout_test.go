@@ -0,0 +1,347 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/prometheus/common/model"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	shardAURL = "http://prometheus-a.local"
+	shardBURL = "http://prometheus-b.local"
+)
+
+func setupFanoutTest(t *testing.T) Driver {
+	viper.Set("maia.storage_driver", "fanout")
+	viper.Set("maia.backends.a.prometheus_url", shardAURL)
+	viper.Set("maia.backends.b.prometheus_url", shardBURL)
+	t.Cleanup(func() {
+		viper.Set("maia.storage_driver", "prometheus")
+		viper.Set("maia.backends", map[string]any{})
+	})
+
+	return NewPrometheusDriver("", map[string]string{}, nil)
+}
+
+func TestFanoutQueryRange(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	gock.New(shardAURL).Get("/api/v1/query_range").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"resultType":"matrix","result":[`+
+			`{"metric":{"__name__":"up","region":"a"},"values":[[100,"1"],[160,"1"]]}]}}`).
+		AddHeader("Content-Type", JSON)
+	gock.New(shardBURL).Get("/api/v1/query_range").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"resultType":"matrix","result":[`+
+			`{"metric":{"__name__":"up","region":"a"},"values":[[160,"1"],[220,"0"]]},`+
+			`{"metric":{"__name__":"up","region":"b"},"values":[[100,"1"]]}]}}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := fs.QueryRange(t.Context(), "up", "100", "220", "60s", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var qr QueryResponse
+	decodeBody(t, resp, &qr)
+	matrix, ok := qr.Data.Value.(model.Matrix)
+	if assert.True(t, ok, "expected matrix result") && assert.Len(t, matrix, 2) {
+		assert.Equal(t, []model.SamplePair{{Timestamp: 100000, Value: 1}, {Timestamp: 160000, Value: 1}, {Timestamp: 220000, Value: 0}}, matrix[0].Values)
+		assert.Equal(t, model.LabelValue("b"), matrix[1].Metric["region"])
+	}
+	assert.Empty(t, qr.Warnings)
+
+	assertDone(t)
+}
+
+func TestFanoutQuery_partialFailure(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	gock.New(shardAURL).Get("/api/v1/query").
+		Reply(http.StatusOK).
+		File("fixtures/query.json").
+		AddHeader("Content-Type", JSON)
+	gock.New(shardBURL).Get("/api/v1/query").
+		Reply(http.StatusServiceUnavailable).
+		BodyString(`{"status":"error","errorType":"timeout","error":"query timed out"}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := fs.Query(t.Context(), "sum(up)", "", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var qr QueryResponse
+	decodeBody(t, resp, &qr)
+	assert.Equal(t, StatusSuccess, qr.Status)
+	assert.Equal(t, []string{"backend b: query timed out"}, qr.Warnings)
+	assert.Len(t, qr.Data.Value, 1)
+
+	assertDone(t)
+}
+
+func TestFanoutQuery_allFailed(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	for _, u := range []string{shardAURL, shardBURL} {
+		gock.New(u).Get("/api/v1/query").
+			Reply(http.StatusBadRequest).
+			BodyString(`{"status":"error","errorType":"bad_data","error":"parse error"}`).
+			AddHeader("Content-Type", JSON)
+	}
+
+	resp, err := fs.Query(t.Context(), "sum(", "", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "upstream error should be passed on")
+
+	assertDone(t)
+}
+
+func TestFanoutSeries(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	gock.New(shardAURL).Get("/api/v1/series").
+		Reply(http.StatusOK).
+		File("fixtures/series.json").
+		AddHeader("Content-Type", JSON)
+	gock.New(shardBURL).Get("/api/v1/series").
+		Reply(http.StatusOK).
+		File("fixtures/series.json").
+		AddHeader("Content-Type", JSON)
+
+	resp, err := fs.Series(t.Context(), []string{"{component=\"objectstore\"}"}, "", "", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var sr SeriesResponse
+	decodeBody(t, resp, &sr)
+	assert.Len(t, sr.Data, 1, "identical series should be deduplicated")
+
+	assertDone(t)
+}
+
+func TestFanoutAlerts(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	gock.New(shardAURL).Get("/api/v1/alerts").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"alerts":[{"labels":{"alertname":"A"},"annotations":{},"state":"firing","value":"1"}]}}`).
+		AddHeader("Content-Type", JSON)
+	gock.New(shardBURL).Get("/api/v1/alerts").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"alerts":[{"labels":{"alertname":"B"},"annotations":{},"state":"pending","value":"0"}]}}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := fs.Alerts(t.Context())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var ar AlertsResponse
+	decodeBody(t, resp, &ar)
+	if assert.Len(t, ar.Data.Alerts, 2) {
+		assert.Equal(t, model.LabelValue("A"), ar.Data.Alerts[0].Labels["alertname"])
+		assert.Equal(t, model.LabelValue("B"), ar.Data.Alerts[1].Labels["alertname"])
+	}
+
+	assertDone(t)
+}
+
+func TestFanoutLabelValues(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	gock.New(shardAURL).Get("/api/v1/label/service/values").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":["objectstore","compute"]}`).
+		AddHeader("Content-Type", JSON)
+	gock.New(shardBURL).Get("/api/v1/label/service/values").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":["network","objectstore"]}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := fs.LabelValues(t.Context(), "service", nil, "", "", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var lr LabelValuesResponse
+	decodeBody(t, resp, &lr)
+	assert.Equal(t, model.LabelValues{"compute", "network", "objectstore"}, lr.Data)
+
+	assertDone(t)
+}
+
+func TestFanoutQueryExemplars(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	gock.New(shardAURL).Get("/api/v1/query_exemplars").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":[{"seriesLabels":{"__name__":"up","job":"a"},`+
+			`"exemplars":[{"labels":{"trace_id":"1"},"value":"1","timestamp":100},{"labels":{"trace_id":"2"},"value":"1","timestamp":200}]}]}`).
+		AddHeader("Content-Type", JSON)
+	gock.New(shardBURL).Get("/api/v1/query_exemplars").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":[{"seriesLabels":{"__name__":"up","job":"a"},`+
+			`"exemplars":[{"labels":{"trace_id":"2"},"value":"1","timestamp":200},{"labels":{"trace_id":"3"},"value":"1","timestamp":150}]},`+
+			`{"seriesLabels":{"__name__":"up","job":"b"},"exemplars":[{"labels":{"trace_id":"4"},"value":"1","timestamp":100}]}]}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := fs.QueryExemplars(t.Context(), "up", "0", "300", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var er ExemplarsResponse
+	decodeBody(t, resp, &er)
+	if assert.Len(t, er.Data, 2) {
+		var traces []model.LabelValue
+		for _, e := range er.Data[0].Exemplars {
+			traces = append(traces, e.Labels["trace_id"])
+		}
+		assert.Equal(t, []model.LabelValue{"1", "3", "2"}, traces)
+		assert.Len(t, er.Data[1].Exemplars, 1)
+	}
+
+	assertDone(t)
+}
+
+func TestFanoutMetadata(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	gock.New(shardAURL).Get("/api/v1/metadata").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"up":[{"type":"gauge","help":"Target is up.","unit":""}]}}`).
+		AddHeader("Content-Type", JSON)
+	gock.New(shardBURL).Get("/api/v1/metadata").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"up":[{"type":"gauge","help":"Target is up.","unit":""}],`+
+			`"scrape_duration_seconds":[{"type":"gauge","help":"Scrape duration.","unit":"seconds"}]}}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := fs.Metadata(t.Context(), "", "", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var mr MetadataResponse
+	decodeBody(t, resp, &mr)
+	assert.Equal(t, map[string][]MetricMetadata{
+		"up":                      {{Type: model.MetricTypeGauge, Help: "Target is up."}},
+		"scrape_duration_seconds": {{Type: model.MetricTypeGauge, Help: "Scrape duration.", Unit: "seconds"}},
+	}, mr.Data)
+
+	assertDone(t)
+}
+
+func TestFanoutFederate(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	gock.New(shardAURL).Get("/federate").
+		Reply(http.StatusOK).
+		File("fixtures/federate.txt").
+		AddHeader("Content-Type", PlainText)
+	gock.New(shardBURL).Get("/federate").
+		ReplyError(io.ErrUnexpectedEOF)
+
+	resp, err := fs.Federate(t.Context(), []string{"{vmware_name=\"win_cifs_13\"}"}, PlainText)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "vcenter_cpu_costop_summation{")
+	assert.Contains(t, resp.Header.Get("Warning"), "backend b")
+
+	assertDone(t)
+}
+
+func decodeBody(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFanoutQuery_partialResults(t *testing.T) {
+	defer gock.Off()
+
+	fs := setupFanoutTest(t)
+
+	// both shards hold some of the series of the aggregation, so neither sum is the total
+	gock.New(shardAURL).Get("/api/v1/query").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[100,"3"]}]}}`).
+		AddHeader("Content-Type", JSON)
+	gock.New(shardBURL).Get("/api/v1/query").
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[100,"4"]}]}}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := fs.Query(t.Context(), "sum(up)", "100", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var qr QueryResponse
+	decodeBody(t, resp, &qr)
+	assert.Len(t, qr.Data.Value, 1)
+	if assert.Len(t, qr.Warnings, 1) {
+		assert.Contains(t, qr.Warnings[0], errPartialResult.Error())
+	}
+
+	assertDone(t)
+}
+
+func TestMergeValues(t *testing.T) {
+	// identical results of replicas are merged without complaint
+	_, err := mergeValues(&model.Scalar{Value: 1, Timestamp: 100}, &model.Scalar{Value: 1, Timestamp: 100})
+	assert.NoError(t, err)
+	_, err = mergeValues(
+		model.Matrix{{Metric: model.Metric{"a": "1"}, Values: []model.SamplePair{{Timestamp: 100, Value: 1}}}},
+		model.Matrix{{Metric: model.Metric{"a": "1"}, Values: []model.SamplePair{{Timestamp: 100, Value: 1}, {Timestamp: 160, Value: 2}}}})
+	assert.NoError(t, err)
+
+	// different values per backend are partial results
+	merged, err := mergeValues(&model.Scalar{Value: 1, Timestamp: 100}, &model.Scalar{Value: 2, Timestamp: 100})
+	assert.ErrorIs(t, err, errPartialResult)
+	assert.Equal(t, &model.Scalar{Value: 1, Timestamp: 100}, merged)
+	_, err = mergeValues(
+		model.Matrix{{Metric: model.Metric{"a": "1"}, Values: []model.SamplePair{{Timestamp: 100, Value: 1}}}},
+		model.Matrix{{Metric: model.Metric{"a": "1"}, Values: []model.SamplePair{{Timestamp: 100, Value: 5}}}})
+	assert.ErrorIs(t, err, errPartialResult)
+}
@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// FederateAccept returns the Accept header for fetching /federate in the given exposition format. Prometheus does
+// not serve OpenMetrics on /federate, so OpenMetrics is requested with a fallback to delimited protobuf, the only
+// format that also carries native histograms, exemplars and created timestamps. ConvertFederateResponse turns the
+// response into the requested format.
+func FederateAccept(format expfmt.Format) string {
+	switch format.FormatType() {
+	case expfmt.TypeTextPlain:
+		return PlainText
+	case expfmt.TypeOpenMetrics:
+		return OpenMetrics + ";q=0.8," + P8SProtoBuf
+	default:
+		return P8SProtoBuf
+	}
+}
+
+// ConvertFederateResponse checks that a successful /federate response is encoded in the given exposition format.
+// Responses in another format are decoded and encoded in the requested one (OpenMetrics with _created lines).
+// Bodies that are neither protobuf nor text format cannot be converted and result in an error.
+func ConvertFederateResponse(resp *http.Response, format expfmt.Format) (*http.Response, error) {
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	upstream := expfmt.Format(resp.Header.Get("Content-Type"))
+	switch upstream.FormatType() {
+	case format.FormatType():
+		return resp, nil
+	case expfmt.TypeProtoDelim, expfmt.TypeTextPlain:
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected content type %q of /federate response, expected %s", upstream, format)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	decoder := expfmt.NewDecoder(resp.Body, upstream)
+	encoder := expfmt.NewEncoder(&buf, format, expfmt.WithCreatedLines())
+	for {
+		var mf dto.MetricFamily
+		err := decoder.Decode(&mf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode /federate response: %w", err)
+		}
+		if err := encoder.Encode(&mf); err != nil {
+			return nil, err
+		}
+	}
+	if closer, ok := encoder.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	result := bufferedResponse(string(format), buf.Bytes())
+	for k, values := range resp.Header {
+		if k != "Content-Type" && k != "Content-Length" {
+			result.Header[k] = values
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// protobufFederateResponse encodes a counter with created timestamp and exemplar, and a native histogram
+func protobufFederateResponse(t *testing.T) *http.Response {
+	families := []*dto.MetricFamily{
+		{Name: proto.String("http_requests_total"), Type: dto.MetricType_COUNTER.Enum(), Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{{Name: proto.String("project_id"), Value: proto.String("12345")}},
+			Counter: &dto.Counter{
+				Value:            proto.Float64(42),
+				CreatedTimestamp: timestamppb.New(time.Unix(1499996400, 0)),
+				Exemplar: &dto.Exemplar{
+					Label: []*dto.LabelPair{{Name: proto.String("trace_id"), Value: proto.String("abc")}},
+					Value: proto.Float64(1),
+				},
+			},
+			TimestampMs: proto.Int64(1500000000000),
+		}}},
+		{Name: proto.String("http_request_duration_seconds"), Type: dto.MetricType_HISTOGRAM.Enum(), Metric: []*dto.Metric{{
+			Label: []*dto.LabelPair{{Name: proto.String("project_id"), Value: proto.String("12345")}},
+			Histogram: &dto.Histogram{
+				SampleCount:   proto.Uint64(3),
+				SampleSum:     proto.Float64(1.5),
+				Schema:        proto.Int32(0),
+				ZeroThreshold: proto.Float64(1e-128),
+				PositiveSpan:  []*dto.BucketSpan{{Offset: proto.Int32(0), Length: proto.Uint32(1)}},
+				PositiveDelta: []int64{3},
+			},
+			TimestampMs: proto.Int64(1500000000000),
+		}}},
+	}
+	var buf bytes.Buffer
+	encoder := expfmt.NewEncoder(&buf, expfmt.NewFormat(expfmt.TypeProtoDelim))
+	for _, mf := range families {
+		if err := encoder.Encode(mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bufferedResponse(string(expfmt.NewFormat(expfmt.TypeProtoDelim)), buf.Bytes())
+}
+
+func TestFederateAccept(t *testing.T) {
+	header := func(accept string) http.Header { return http.Header{"Accept": []string{accept}} }
+	cases := map[string]string{
+		"":               PlainText,
+		PlainText:        PlainText,
+		P8SProtoBuf:      P8SProtoBuf,
+		OpenMetrics:      OpenMetrics + ";q=0.8," + P8SProtoBuf,
+		"application/xy": PlainText,
+	}
+	for accept, expected := range cases {
+		assert.Equal(t, expected, FederateAccept(expfmt.NegotiateIncludingOpenMetrics(header(accept))), "Accept: %s", accept)
+	}
+}
+
+func TestConvertFederateResponse_passThrough(t *testing.T) {
+	resp := protobufFederateResponse(t)
+	result, err := ConvertFederateResponse(resp, expfmt.NewFormat(expfmt.TypeProtoDelim))
+	assert.NoError(t, err)
+	assert.Same(t, resp, result, "responses in the requested format should be passed on unchanged")
+}
+
+func TestConvertFederateResponse_openMetrics(t *testing.T) {
+	resp := protobufFederateResponse(t)
+	resp.Header.Set("Warning", `199 maia "backend b: timeout"`)
+
+	result, err := ConvertFederateResponse(resp, expfmt.NewFormat(expfmt.TypeOpenMetrics))
+	if !assert.NoError(t, err) {
+		return
+	}
+	body, err := io.ReadAll(result.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, OpenMetrics, result.Header.Get("Content-Type"))
+	assert.Equal(t, `199 maia "backend b: timeout"`, result.Header.Get("Warning"))
+	assert.Contains(t, string(body), "# TYPE http_requests counter\n")
+	assert.Contains(t, string(body), `http_requests_total{project_id="12345"} 42.0 1.5e+09 # {trace_id="abc"} 1.0`)
+	assert.Contains(t, string(body), `http_requests_created{project_id="12345"} 1.4999964e+09`)
+	assert.Contains(t, string(body), `http_request_duration_seconds_count{project_id="12345"} 3 1.5e+09`)
+	assert.Contains(t, string(body), "# EOF\n")
+}
+
+func TestConvertFederateResponse_text(t *testing.T) {
+	resp := bufferedResponse(PlainText, []byte("# TYPE up untyped\nup{project_id=\"12345\"} 1 1500000000000\n"))
+
+	result, err := ConvertFederateResponse(resp, expfmt.NewFormat(expfmt.TypeProtoDelim))
+	if !assert.NoError(t, err) {
+		return
+	}
+	var mf dto.MetricFamily
+	err = expfmt.NewDecoder(result.Body, expfmt.ResponseFormat(result.Header)).Decode(&mf)
+	assert.NoError(t, err)
+	assert.Equal(t, "up", mf.GetName())
+	assert.Equal(t, int64(1500000000000), mf.GetMetric()[0].GetTimestampMs())
+}
+
+func TestConvertFederateResponse_errorContentType(t *testing.T) {
+	resp := bufferedResponse(JSON, []byte(`{"status":"success"}`))
+	_, err := ConvertFederateResponse(resp, expfmt.NewFormat(expfmt.TypeTextPlain))
+	assert.ErrorContains(t, err, "unexpected content type")
+
+	// error responses are passed on
+	resp = bufferedResponse(JSON, []byte(`{"status":"error"}`))
+	resp.StatusCode = http.StatusBadRequest
+	result, err := ConvertFederateResponse(resp, expfmt.NewFormat(expfmt.TypeTextPlain))
+	assert.NoError(t, err)
+	assert.Same(t, resp, result)
+}
@@ -4,9 +4,11 @@
 package storage
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/common/model"
 	"github.com/spf13/viper"
@@ -19,8 +21,12 @@ const (
 	P8SProtoBuf string = "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3,*/*;q=0.1"
 	// PlainText is used for readable federate output
 	PlainText = "text/plain; version=0.0.4"
+	// OpenMetrics is the OpenMetrics 1.0 text format
+	OpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
 	// JSON is used to obtain output in JSON (--format json)
 	JSON = "application/json"
+	// RemoteReadVersion is the version of the remote read protocol spoken by Maia
+	RemoteReadVersion = "0.1.0"
 )
 
 // Status contains Prometheus status strings
@@ -57,6 +63,7 @@ type Response struct {
 	Data      []any     `json:"data,omitempty"`
 	ErrorType ErrorType `json:"errorType,omitempty"`
 	Error     string    `json:"error,omitempty"`
+	Warnings  []string  `json:"warnings,omitempty"`
 }
 
 // SeriesResponse encapsulates a response to the /series API of Prometheus
@@ -65,12 +72,121 @@ type SeriesResponse struct {
 	Data      []model.LabelSet `json:"data,omitempty"`
 	ErrorType ErrorType        `json:"errorType,omitempty"`
 	Error     string           `json:"error,omitempty"`
+	Warnings  []string         `json:"warnings,omitempty"`
 }
 
 // LabelValuesResponse encapsulates a response to the /label/values API of Prometheus
 type LabelValuesResponse struct {
-	Status Status            `json:"status"`
-	Data   model.LabelValues `json:"data"`
+	Status    Status            `json:"status"`
+	Data      model.LabelValues `json:"data"`
+	ErrorType ErrorType         `json:"errorType,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Warnings  []string          `json:"warnings,omitempty"`
+}
+
+// ExemplarsResponse encapsulates a response to the /query_exemplars API of Prometheus
+type ExemplarsResponse struct {
+	Status    Status                `json:"status"`
+	Data      []ExemplarQueryResult `json:"data"`
+	ErrorType ErrorType             `json:"errorType,omitempty"`
+	Error     string                `json:"error,omitempty"`
+	Warnings  []string              `json:"warnings,omitempty"`
+}
+
+// ExemplarQueryResult contains the exemplars of a single series
+type ExemplarQueryResult struct {
+	SeriesLabels model.LabelSet `json:"seriesLabels"`
+	Exemplars    []Exemplar     `json:"exemplars"`
+}
+
+// Exemplar is a sample with additional labels, e.g. the ID of a trace
+type Exemplar struct {
+	Labels    model.LabelSet    `json:"labels"`
+	Value     model.SampleValue `json:"value"`
+	Timestamp model.Time        `json:"timestamp"`
+}
+
+// MetadataResponse encapsulates a response to the /metadata API of Prometheus
+type MetadataResponse struct {
+	Status    Status                      `json:"status"`
+	Data      map[string][]MetricMetadata `json:"data"`
+	ErrorType ErrorType                   `json:"errorType,omitempty"`
+	Error     string                      `json:"error,omitempty"`
+	Warnings  []string                    `json:"warnings,omitempty"`
+}
+
+// MetricMetadata describes a metric as announced by its exporters
+type MetricMetadata struct {
+	Type model.MetricType `json:"type"`
+	Help string           `json:"help"`
+	Unit string           `json:"unit"`
+}
+
+// AlertsResponse encapsulates a response to the /alerts API of Prometheus
+type AlertsResponse struct {
+	Status    Status       `json:"status"`
+	Data      AlertsResult `json:"data"`
+	ErrorType ErrorType    `json:"errorType,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Warnings  []string     `json:"warnings,omitempty"`
+}
+
+// AlertsResult contains the active alerts
+type AlertsResult struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+// Alert is a pending or firing instance of an alerting rule
+type Alert struct {
+	Labels          model.LabelSet `json:"labels"`
+	Annotations     model.LabelSet `json:"annotations"`
+	State           string         `json:"state"`
+	ActiveAt        *time.Time     `json:"activeAt,omitempty"`
+	KeepFiringSince *time.Time     `json:"keepFiringSince,omitempty"`
+	Value           string         `json:"value"`
+}
+
+// RulesResponse encapsulates a response to the /rules API of Prometheus
+type RulesResponse struct {
+	Status    Status      `json:"status"`
+	Data      RulesResult `json:"data"`
+	ErrorType ErrorType   `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
+}
+
+// RulesResult contains the loaded rule groups
+type RulesResult struct {
+	Groups []RuleGroup `json:"groups"`
+}
+
+// RuleGroup is a group of rules that are evaluated together
+type RuleGroup struct {
+	Name           string    `json:"name"`
+	File           string    `json:"file"`
+	Rules          []Rule    `json:"rules"`
+	Interval       float64   `json:"interval"`
+	Limit          int       `json:"limit"`
+	EvaluationTime float64   `json:"evaluationTime"`
+	LastEvaluation time.Time `json:"lastEvaluation"`
+}
+
+// Rule is an alerting rule (Type "alerting") or a recording rule (Type "recording"). Fields that only apply to
+// the other kind of rule are left empty.
+type Rule struct {
+	State          string         `json:"state,omitempty"`
+	Name           string         `json:"name"`
+	Query          string         `json:"query"`
+	Duration       float64        `json:"duration,omitempty"`
+	KeepFiringFor  float64        `json:"keepFiringFor,omitempty"`
+	Labels         model.LabelSet `json:"labels,omitempty"`
+	Annotations    model.LabelSet `json:"annotations,omitempty"`
+	Alerts         []Alert        `json:"alerts,omitempty"`
+	Health         string         `json:"health"`
+	LastError      string         `json:"lastError,omitempty"`
+	EvaluationTime float64        `json:"evaluationTime"`
+	LastEvaluation time.Time      `json:"lastEvaluation"`
+	Type           string         `json:"type"`
 }
 
 // QueryResponse contains the response from a call to query or query_range
@@ -79,6 +195,7 @@ type QueryResponse struct {
 	Data      QueryResult `json:"data"`
 	ErrorType ErrorType   `json:"errorType,omitempty"`
 	Error     string      `json:"error,omitempty"`
+	Warnings  []string    `json:"warnings,omitempty"`
 }
 
 // QueryResult contains the actual result of a query or query_range call
@@ -87,7 +204,7 @@ type QueryResult struct {
 	Result any             `json:"result"`
 
 	// The decoded value.
-	Value model.Value
+	Value model.Value `json:"-"`
 }
 
 // UnmarshalJSON contains a custom unmarshaller
@@ -129,18 +246,34 @@ func (qr *QueryResult) UnmarshalJSON(b []byte) error {
 // For pragmatic reasons the HTTP response from the underlying storage service is passed
 // on unchanged. For most API operations, Maia does not have to transform the response and that way
 // we can avoid an entire in-memory unmarshal-marshal cycle.
+// All methods take the context of the originating request: when it is cancelled, the upstream
+// call is aborted. A context deadline is passed on to Prometheus as query timeout.
 type Driver interface {
 	/********** requests to Prometheus **********/
-	Federate(selectors []string, acceptContentType string) (*http.Response, error)
-	Query(query, time, timeout string, acceptContentType string) (*http.Response, error)
-	QueryRange(query, start, end, step, timeout string, acceptContentType string) (*http.Response, error)
-	Series(match []string, start, end string, acceptContentType string) (*http.Response, error)
-	LabelValues(name string, acceptContentType string) (*http.Response, error)
-	Labels(start, end string, match []string, acceptContentType string) (*http.Response, error)
+	Federate(ctx context.Context, selectors []string, acceptContentType string) (*http.Response, error)
+	Query(ctx context.Context, query, time string, acceptContentType string) (*http.Response, error)
+	QueryRange(ctx context.Context, query, start, end, step string, acceptContentType string) (*http.Response, error)
+	Series(ctx context.Context, match []string, start, end string, acceptContentType string) (*http.Response, error)
+	// LabelValues returns the values of a label, restricted to the series matching any of the selectors in match
+	LabelValues(ctx context.Context, name string, match []string, start, end string, acceptContentType string) (*http.Response, error)
+	Labels(ctx context.Context, start, end string, match []string, acceptContentType string) (*http.Response, error)
+	QueryExemplars(ctx context.Context, query, start, end string, acceptContentType string) (*http.Response, error)
+	// Metadata returns the metadata of all metrics, or of a single metric if metric is not empty
+	Metadata(ctx context.Context, metric, limitPerMetric string, acceptContentType string) (*http.Response, error)
+	// Alerts returns the active alerts of all alerting rules
+	Alerts(ctx context.Context) (*http.Response, error)
+	// Rules returns the rule groups with the state of their rules, optionally restricted to alerting or recording
+	// rules (ruleType) and to rules or groups with the given names
+	Rules(ctx context.Context, ruleType string, ruleNames, ruleGroups []string) (*http.Response, error)
+	// RemoteRead forwards a remote read request (snappy-compressed protobuf) to the remote read API
+	RemoteRead(ctx context.Context, request []byte) (*http.Response, error)
+	// Close stops the background activities of the driver, like the health checks of replicas
+	Close() error
 }
 
-// NewPrometheusDriver is a factory method which chooses the right driver implementation based on configuration settings
-func NewPrometheusDriver(prometheusAPIURL string, customHeader map[string]string) Driver {
+// NewPrometheusDriver is a factory method which chooses the right driver implementation based on configuration settings.
+// The tenantLabels are the labels restricting queries to a project or domain, which routing rules match tenants on.
+func NewPrometheusDriver(prometheusAPIURL string, customHeader map[string]string, tenantLabels []string) Driver {
 	driverName := viper.GetString("maia.storage_driver")
 	switch driverName {
 	case "prometheus":
@@ -151,7 +284,13 @@ func NewPrometheusDriver(prometheusAPIURL string, customHeader map[string]string
 		}
 		logg.Info("Using API server at: \"%s\"", prometheusAPIURL)
 
-		return driver
+		return withQueryCache(driver)
+	case "fanout":
+		return withQueryCache(Fanout(customHeader))
+	case "routing":
+		return withQueryCache(Routing(customHeader, tenantLabels))
+	case "memory":
+		return withQueryCache(Memory())
 	default:
 		panic(fmt.Errorf("invalid service.storage_driver setting: %s", driverName))
 	}
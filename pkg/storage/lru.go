@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/prometheus/common/model"
+)
+
+// lruCache is an in-memory cache of query results that evicts the least recently used
+// entries once the estimated size of all entries exceeds the limit
+type lruCache struct {
+	mutex     sync.Mutex
+	maxBytes  int64
+	usedBytes int64
+	entries   map[string]*list.Element
+	// order contains the *lruEntry values, most recently used first
+	order *list.List
+}
+
+type lruEntry struct {
+	key   string
+	value model.Matrix
+	size  int64
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns the cached value for the given key. The value must not be modified by the caller.
+func (c *lruCache) Get(key string) (model.Matrix, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry).value, true
+}
+
+// Add stores a value in the cache. Values exceeding the size limit on their own are not cached at all.
+func (c *lruCache) Add(key string, value model.Matrix) {
+	size := matrixSize(value)
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, size: size})
+	c.usedBytes += size
+
+	for c.usedBytes > c.maxBytes {
+		c.removeElement(c.order.Back())
+	}
+	queryCacheSizeGauge.Set(float64(c.usedBytes))
+}
+
+func (c *lruCache) removeElement(elem *list.Element) {
+	entry := c.order.Remove(elem).(*lruEntry)
+	delete(c.entries, entry.key)
+	c.usedBytes -= entry.size
+}
+
+// matrixSize estimates the memory consumption of a query result in bytes
+func matrixSize(m model.Matrix) int64 {
+	size := int64(64)
+	for _, s := range m {
+		size += 64 + 16*int64(len(s.Values)) + 256*int64(len(s.Histograms))
+		for name, value := range s.Metric {
+			size += 32 + int64(len(name)+len(value))
+		}
+	}
+	return size
+}
@@ -0,0 +1,690 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	promstorage "github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/spf13/viper"
+
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/util"
+)
+
+// memoryStorageClient is a storage Driver that evaluates queries with the PromQL engine of Prometheus against
+// an in-memory TSDB. The TSDB is populated once on startup from the files configured in maia.memory.files,
+// so that Maia can be run end to end without a Prometheus, e.g. for development and tests.
+type memoryStorageClient struct {
+	db            *tsdb.DB
+	dir           string
+	engine        *promql.Engine
+	lookbackDelta time.Duration
+	metadata      map[string]MetricMetadata
+}
+
+// memoryLoader appends the series read from files to the TSDB
+type memoryLoader struct {
+	app promstorage.Appender
+	// start is the timestamp of the first sample of the series in promtool-style test files
+	start time.Time
+	// now is the timestamp of samples without an explicit timestamp in OpenMetrics files
+	now      time.Time
+	metadata map[string]MetricMetadata
+}
+
+var (
+	seriesParser = parser.NewParser(parser.Options{})
+	loadCommand  = regexp.MustCompile(`^load\s+(\S+)$`)
+)
+
+// Memory creates a storage driver that answers queries from the series in the files configured in
+// maia.memory.files. Files ending in .test contain series in the notation of promtool/PromQL unit tests
+// ("load" commands), all other files are read as OpenMetrics or Prometheus text format.
+func Memory() Driver {
+	start := time.Unix(0, 0)
+	if s := viper.GetString("maia.memory.start"); s != "" {
+		var err error
+		start, err = util.ParseTime(s)
+		if err != nil {
+			panic(fmt.Errorf("invalid maia.memory.start setting: %w", err))
+		}
+	}
+
+	files := viper.GetStringSlice("maia.memory.files")
+	client, err := newMemoryStorageClient(files, start)
+	if err != nil {
+		panic(err)
+	}
+	logg.Info("Using in-memory TSDB with series loaded from: %s", strings.Join(files, ", "))
+	return client
+}
+
+// newMemoryStorageClient creates an in-memory TSDB and loads the given files into it
+func newMemoryStorageClient(files []string, start time.Time) (*memoryStorageClient, error) {
+	dir, err := os.MkdirTemp("", "maia-memory-")
+	if err != nil {
+		return nil, err
+	}
+	opts := tsdb.DefaultOptions()
+	// loaded samples are kept in memory and never expire
+	opts.WALSegmentSize = -1
+	opts.RetentionDuration = 0
+	opts.EnableExemplarStorage = true
+	opts.MaxExemplars = 100000
+	db, err := tsdb.Open(dir, nil, nil, opts, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("cannot create in-memory TSDB: %w", err)
+	}
+	db.DisableCompactions()
+
+	client := &memoryStorageClient{
+		db:            db,
+		dir:           dir,
+		lookbackDelta: 5 * time.Minute,
+		metadata:      map[string]MetricMetadata{},
+		engine: promql.NewEngine(promql.EngineOpts{
+			MaxSamples:           50000000,
+			Timeout:              2 * time.Minute,
+			LookbackDelta:        5 * time.Minute,
+			EnableAtModifier:     true,
+			EnableNegativeOffset: true,
+		}),
+	}
+
+	// a single appender accepts the samples of all files regardless of their timestamps
+	loader := memoryLoader{app: db.Appender(context.Background()), start: start, now: time.Now(), metadata: client.metadata}
+	for _, file := range files {
+		if err := loader.loadFile(file); err != nil {
+			_ = loader.app.Rollback()
+			_ = client.Close()
+			return nil, err
+		}
+	}
+	if err := loader.app.Commit(); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("cannot store series: %w", err)
+	}
+
+	return client, nil
+}
+
+// Close releases the TSDB
+func (c *memoryStorageClient) Close() error {
+	err := c.db.Close()
+	return errors.Join(err, os.RemoveAll(c.dir))
+}
+
+func (l *memoryLoader) loadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(path) == ".test" {
+		return l.loadTestSeries(path, content)
+	}
+	return l.loadExposition(path, content)
+}
+
+// loadTestSeries reads series in the notation of PromQL unit tests, e.g.
+//
+//	load 5m
+//	  http_requests_total{job="api"} 0+10x100
+//
+// The first sample of each series has the start timestamp, the following samples are spaced by the interval
+// of the load command.
+func (l *memoryLoader) loadTestSeries(path string, content []byte) error {
+	var interval time.Duration
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if m := loadCommand.FindStringSubmatch(line); m != nil {
+			d, err := model.ParseDuration(m[1])
+			if err != nil || d <= 0 {
+				return fmt.Errorf("%s:%d: invalid interval %q", path, i+1, m[1])
+			}
+			interval = time.Duration(d)
+			continue
+		}
+		if interval == 0 {
+			return fmt.Errorf("%s:%d: unexpected %q, only load commands are supported", path, i+1, line)
+		}
+
+		lset, values, err := seriesParser.ParseSeriesDesc(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		for j, v := range values {
+			if v.Omitted {
+				continue
+			}
+			ts := l.start.Add(time.Duration(j) * interval).UnixMilli()
+			if v.Histogram != nil {
+				_, err = l.app.AppendHistogram(0, lset, ts, nil, v.Histogram)
+			} else {
+				_, err = l.app.Append(0, lset, ts, v.Value)
+			}
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadExposition reads a file in OpenMetrics or Prometheus text format including metadata and exemplars
+func (l *memoryLoader) loadExposition(path string, content []byte) error {
+	contentType := "text/plain"
+	if bytes.Contains(content, []byte("# EOF")) {
+		contentType = "application/openmetrics-text"
+	}
+	p, err := textparse.New(content, contentType, nil, textparse.ParserOptions{})
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for {
+		entry, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		switch entry {
+		case textparse.EntryType:
+			name, typ := p.Type()
+			m := l.metadata[string(name)]
+			m.Type = typ
+			l.metadata[string(name)] = m
+		case textparse.EntryHelp:
+			name, help := p.Help()
+			m := l.metadata[string(name)]
+			m.Help = string(help)
+			l.metadata[string(name)] = m
+		case textparse.EntryUnit:
+			name, unit := p.Unit()
+			m := l.metadata[string(name)]
+			m.Unit = string(unit)
+			l.metadata[string(name)] = m
+		case textparse.EntrySeries:
+			_, tsPtr, v := p.Series()
+			ts := l.timestamp(tsPtr)
+			var lset labels.Labels
+			p.Labels(&lset)
+			ref, err := l.app.Append(0, lset, ts, v)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %w", path, lset.String(), err)
+			}
+			var e exemplar.Exemplar
+			for p.Exemplar(&e) {
+				if !e.HasTs {
+					e.Ts = ts
+					e.HasTs = true
+				}
+				if _, err := l.app.AppendExemplar(ref, lset, e); err != nil {
+					return fmt.Errorf("%s: exemplar of %s: %w", path, lset.String(), err)
+				}
+				e = exemplar.Exemplar{}
+			}
+		case textparse.EntryHistogram:
+			_, tsPtr, h, fh := p.Histogram()
+			var lset labels.Labels
+			p.Labels(&lset)
+			if _, err := l.app.AppendHistogram(0, lset, l.timestamp(tsPtr), h, fh); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, lset.String(), err)
+			}
+		default:
+			// comments are ignored
+		}
+	}
+}
+
+func (l *memoryLoader) timestamp(ts *int64) int64 {
+	if ts != nil {
+		return *ts
+	}
+	return l.now.UnixMilli()
+}
+
+func (c *memoryStorageClient) Query(ctx context.Context, query, time, _ string) (*http.Response, error) {
+	ts, err := util.ParseTime(time)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, fmt.Errorf("invalid parameter 'time': %w", err))
+	}
+	q, err := c.engine.NewInstantQuery(ctx, c.db, nil, query, ts)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+	return c.execQuery(ctx, q, query)
+}
+
+func (c *memoryStorageClient) QueryRange(ctx context.Context, query, start, end, step, _ string) (*http.Response, error) {
+	startTime, err := util.ParseTime(start)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, fmt.Errorf("invalid parameter 'start': %w", err))
+	}
+	endTime, err := util.ParseTime(end)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, fmt.Errorf("invalid parameter 'end': %w", err))
+	}
+	interval, err := util.ParseDuration(step)
+	if err != nil || interval <= 0 {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, fmt.Errorf("invalid parameter 'step': %q is not a positive duration", step))
+	}
+	q, err := c.engine.NewRangeQuery(ctx, c.db, nil, query, startTime, endTime, interval)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+	return c.execQuery(ctx, q, query)
+}
+
+// execQuery evaluates a query and encodes the result like the Prometheus API
+func (c *memoryStorageClient) execQuery(ctx context.Context, q promql.Query, query string) (*http.Response, error) {
+	defer q.Close()
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var (
+			errTimeout  promql.ErrQueryTimeout
+			errCanceled promql.ErrQueryCanceled
+			errStorage  promql.ErrStorage
+		)
+		switch {
+		case errors.As(res.Err, &errTimeout):
+			return errorResponse(http.StatusServiceUnavailable, ErrorTimeout, res.Err)
+		case errors.As(res.Err, &errCanceled):
+			return errorResponse(http.StatusServiceUnavailable, ErrorCanceled, res.Err)
+		case errors.As(res.Err, &errStorage):
+			return errorResponse(http.StatusInternalServerError, ErrorInternal, res.Err)
+		default:
+			return errorResponse(http.StatusUnprocessableEntity, ErrorExec, res.Err)
+		}
+	}
+
+	v, err := convertValue(res.Value)
+	if err != nil {
+		return nil, err
+	}
+	warnings, _ := res.Warnings.AsStrings(query, 0, 0)
+	return jsonResponse(QueryResponse{
+		Status:   StatusSuccess,
+		Data:     QueryResult{Type: v.Type(), Result: v, Value: v},
+		Warnings: warnings,
+	})
+}
+
+func (c *memoryStorageClient) Series(ctx context.Context, match []string, start, end, _ string) (*http.Response, error) {
+	selectors, err := parseSelectors(match)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+	mint, maxt, err := parseTimeRange(start, end)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+
+	q, err := c.db.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	result := SeriesResponse{Status: StatusSuccess, Data: []model.LabelSet{}}
+	known := map[model.Fingerprint]bool{}
+	for _, matchers := range selectors {
+		set := q.Select(ctx, false, &promstorage.SelectHints{Start: mint, End: maxt, Func: "series"}, matchers...)
+		for set.Next() {
+			ls := model.LabelSet(convertLabels(set.At().Labels()))
+			if fp := ls.Fingerprint(); !known[fp] {
+				known[fp] = true
+				result.Data = append(result.Data, ls)
+			}
+		}
+		if err := set.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return jsonResponse(result)
+}
+
+func (c *memoryStorageClient) LabelValues(ctx context.Context, name string, match []string, start, end, _ string) (*http.Response, error) {
+	selectors, err := parseSelectors(match)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+	mint, maxt, err := parseTimeRange(start, end)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+
+	q, err := c.db.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	if len(selectors) == 0 {
+		selectors = [][]*labels.Matcher{nil}
+	}
+	var values []string
+	for _, matchers := range selectors {
+		v, _, err := q.LabelValues(ctx, name, nil, matchers...)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v...)
+	}
+	slices.Sort(values)
+	values = slices.Compact(values)
+
+	result := LabelValuesResponse{Status: StatusSuccess, Data: make(model.LabelValues, len(values))}
+	for i, v := range values {
+		result.Data[i] = model.LabelValue(v)
+	}
+	return jsonResponse(result)
+}
+
+func (c *memoryStorageClient) Labels(ctx context.Context, start, end string, match []string, _ string) (*http.Response, error) {
+	selectors, err := parseSelectors(match)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+	mint, maxt, err := parseTimeRange(start, end)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+
+	q, err := c.db.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	if len(selectors) == 0 {
+		selectors = [][]*labels.Matcher{nil}
+	}
+	var names []string
+	for _, matchers := range selectors {
+		n, _, err := q.LabelNames(ctx, nil, matchers...)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, n...)
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	result := LabelValuesResponse{Status: StatusSuccess, Data: make(model.LabelValues, len(names))}
+	for i, n := range names {
+		result.Data[i] = model.LabelValue(n)
+	}
+	return jsonResponse(result)
+}
+
+// Federate returns the latest sample of every selected series, like the /federate endpoint of Prometheus
+func (c *memoryStorageClient) Federate(ctx context.Context, selectors []string, acceptContentType string) (*http.Response, error) {
+	matcherSets, err := parseSelectors(selectors)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+	maxt := time.Now().UnixMilli()
+	mint := maxt - c.lookbackDelta.Milliseconds()
+
+	q, err := c.db.Querier(mint, maxt)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	families := map[string]*dto.MetricFamily{}
+	for _, matchers := range matcherSets {
+		set := q.Select(ctx, true, &promstorage.SelectHints{Start: mint, End: maxt}, matchers...)
+		var it chunkenc.Iterator
+		for set.Next() {
+			series := set.At()
+			it = series.Iterator(it)
+			ts, v, ok := lastFloatSample(it)
+			if !ok {
+				continue
+			}
+
+			lset := series.Labels()
+			name := lset.Get(labels.MetricName)
+			if _, exists := families[name]; !exists {
+				families[name] = &dto.MetricFamily{Name: &name, Type: dto.MetricType_UNTYPED.Enum()}
+			}
+			m := &dto.Metric{Untyped: &dto.Untyped{Value: &v}, TimestampMs: &ts}
+			lset.Range(func(l labels.Label) {
+				if l.Name != labels.MetricName {
+					m.Label = append(m.Label, &dto.LabelPair{Name: &l.Name, Value: &l.Value})
+				}
+			})
+			families[name].Metric = append(families[name].Metric, m)
+		}
+		if err := set.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return federateResponse(families, acceptContentType)
+}
+
+// lastFloatSample returns the latest float sample of a series unless it is a staleness marker
+func lastFloatSample(it chunkenc.Iterator) (ts int64, v float64, ok bool) {
+	for vt := it.Next(); vt != chunkenc.ValNone; vt = it.Next() {
+		if vt == chunkenc.ValFloat {
+			ts, v = it.At()
+			ok = !value.IsStaleNaN(v)
+		}
+	}
+	return ts, v, ok
+}
+
+func (c *memoryStorageClient) QueryExemplars(ctx context.Context, query, start, end, _ string) (*http.Response, error) {
+	selectors, err := util.ExtractSelectors(query)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+	mint, maxt, err := parseTimeRange(start, end)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, ErrorBadData, err)
+	}
+
+	eq, err := c.db.ExemplarQuerier(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results, err := eq.Select(mint, maxt, selectors...)
+	if err != nil {
+		return nil, err
+	}
+
+	response := ExemplarsResponse{Status: StatusSuccess, Data: make([]ExemplarQueryResult, len(results))}
+	for i, r := range results {
+		response.Data[i].SeriesLabels = model.LabelSet(convertLabels(r.SeriesLabels))
+		for _, e := range r.Exemplars {
+			response.Data[i].Exemplars = append(response.Data[i].Exemplars, Exemplar{
+				Labels:    model.LabelSet(convertLabels(e.Labels)),
+				Value:     model.SampleValue(e.Value),
+				Timestamp: model.Time(e.Ts),
+			})
+		}
+	}
+	return jsonResponse(response)
+}
+
+// Metadata returns the metadata found in the loaded OpenMetrics files. Since there is only one source,
+// there is at most one entry per metric and limitPerMetric does not matter.
+func (c *memoryStorageClient) Metadata(_ context.Context, metric, _ string, _ string) (*http.Response, error) {
+	result := MetadataResponse{Status: StatusSuccess, Data: map[string][]MetricMetadata{}}
+	for name, m := range c.metadata {
+		if metric == "" || metric == name {
+			result.Data[name] = []MetricMetadata{m}
+		}
+	}
+	return jsonResponse(result)
+}
+
+// Alerts returns an empty list since the memory storage driver does not evaluate alerting rules
+func (c *memoryStorageClient) Alerts(_ context.Context) (*http.Response, error) {
+	return jsonResponse(AlertsResponse{Status: StatusSuccess, Data: AlertsResult{Alerts: []Alert{}}})
+}
+
+// Rules returns an empty list since the memory storage driver does not load rules
+func (c *memoryStorageClient) Rules(_ context.Context, _ string, _, _ []string) (*http.Response, error) {
+	return jsonResponse(RulesResponse{Status: StatusSuccess, Data: RulesResult{Groups: []RuleGroup{}}})
+}
+
+// RemoteRead is not supported since the in-memory TSDB has no remote read API
+func (c *memoryStorageClient) RemoteRead(_ context.Context, _ []byte) (*http.Response, error) {
+	return nil, errors.New("remote read is not supported by the memory storage driver")
+}
+
+// errorResponse creates a synthetic Prometheus error response
+func errorResponse(code int, errorType ErrorType, err error) (*http.Response, error) {
+	resp, jsonErr := jsonResponse(Response{Status: StatusError, ErrorType: errorType, Error: err.Error()})
+	if jsonErr != nil {
+		return nil, jsonErr
+	}
+	resp.StatusCode = code
+	resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	return resp, nil
+}
+
+// parseSelectors parses the match[] parameters of a request
+func parseSelectors(match []string) ([][]*labels.Matcher, error) {
+	selectors := make([][]*labels.Matcher, 0, len(match))
+	for _, sel := range match {
+		matchers, err := util.ParseSelector(sel)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, matchers)
+	}
+	return selectors, nil
+}
+
+// parseTimeRange parses the optional start and end parameters of a request into milliseconds. Like in
+// Prometheus, the range is unbounded if they are missing.
+func parseTimeRange(start, end string) (mint, maxt int64, err error) {
+	mint, maxt = math.MinInt64, math.MaxInt64
+	if start != "" {
+		t, err := util.ParseTime(start)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid parameter 'start': %w", err)
+		}
+		mint = t.UnixMilli()
+	}
+	if end != "" {
+		t, err := util.ParseTime(end)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid parameter 'end': %w", err)
+		}
+		maxt = t.UnixMilli()
+	}
+	return mint, maxt, nil
+}
+
+// convertValue converts a PromQL evaluation result to the types of the Prometheus API client
+func convertValue(v parser.Value) (model.Value, error) {
+	switch v := v.(type) {
+	case promql.Vector:
+		result := make(model.Vector, len(v))
+		for i, s := range v {
+			result[i] = &model.Sample{Metric: convertLabels(s.Metric), Timestamp: model.Time(s.T)}
+			if s.H != nil {
+				result[i].Histogram = convertHistogram(s.H)
+			} else {
+				result[i].Value = model.SampleValue(s.F)
+			}
+		}
+		return result, nil
+	case promql.Matrix:
+		result := make(model.Matrix, len(v))
+		for i, s := range v {
+			stream := &model.SampleStream{Metric: convertLabels(s.Metric)}
+			for _, p := range s.Floats {
+				stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.Time(p.T), Value: model.SampleValue(p.F)})
+			}
+			for _, p := range s.Histograms {
+				stream.Histograms = append(stream.Histograms, model.SampleHistogramPair{Timestamp: model.Time(p.T), Histogram: convertHistogram(p.H)})
+			}
+			result[i] = stream
+		}
+		return result, nil
+	case promql.Scalar:
+		return &model.Scalar{Timestamp: model.Time(v.T), Value: model.SampleValue(v.V)}, nil
+	case promql.String:
+		return &model.String{Timestamp: model.Time(v.T), Value: v.V}, nil
+	default:
+		return nil, fmt.Errorf("unexpected result type %T", v)
+	}
+}
+
+func convertLabels(lset labels.Labels) model.Metric {
+	result := make(model.Metric, lset.Len())
+	lset.Range(func(l labels.Label) {
+		result[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	})
+	return result
+}
+
+// convertHistogram converts a native histogram with the bucket boundary encoding of the Prometheus API
+func convertHistogram(h *histogram.FloatHistogram) *model.SampleHistogram {
+	result := &model.SampleHistogram{Count: model.FloatString(h.Count), Sum: model.FloatString(h.Sum)}
+	for it := h.AllBucketIterator(); it.Next(); {
+		b := it.At()
+		if b.Count == 0 {
+			continue
+		}
+		// 0: (lower, upper], 1: [lower, upper), 2: (lower, upper), 3: [lower, upper]
+		var boundaries int32 = 2
+		switch {
+		case b.LowerInclusive && b.UpperInclusive:
+			boundaries = 3
+		case b.LowerInclusive:
+			boundaries = 1
+		case b.UpperInclusive:
+			boundaries = 0
+		}
+		result.Buckets = append(result.Buckets, &model.HistogramBucket{
+			Boundaries: boundaries,
+			Lower:      model.FloatString(b.Lower),
+			Upper:      model.FloatString(b.Upper),
+			Count:      model.FloatString(b.Count),
+		})
+	}
+	return result
+}
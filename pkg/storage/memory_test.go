@@ -0,0 +1,153 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupMemoryTest(t *testing.T) *memoryStorageClient {
+	client, err := newMemoryStorageClient([]string{"fixtures/memory.test", "fixtures/memory.om"}, time.Unix(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestMemoryQuery(t *testing.T) {
+	ms := setupMemoryTest(t)
+
+	resp, err := ms.Query(t.Context(), `sum by (project_id) (rate(http_requests_total[5m]))`, "600", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var qr QueryResponse
+	decodeBody(t, resp, &qr)
+	vector, ok := qr.Data.Value.(model.Vector)
+	if assert.True(t, ok, "expected vector result") && assert.Len(t, vector, 2) {
+		rates := map[model.LabelValue]model.SampleValue{}
+		for _, s := range vector {
+			rates[s.Metric["project_id"]] = s.Value
+		}
+		assert.InDelta(t, 10.0/60, float64(rates["12345"]), 1e-9)
+		assert.InDelta(t, 20.0/60, float64(rates["67890"]), 1e-9)
+	}
+}
+
+func TestMemoryQueryRange(t *testing.T) {
+	ms := setupMemoryTest(t)
+
+	resp, err := ms.QueryRange(t.Context(), `up`, "0", "300", "60s", JSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var qr QueryResponse
+	decodeBody(t, resp, &qr)
+	matrix, ok := qr.Data.Value.(model.Matrix)
+	if assert.True(t, ok, "expected matrix result") && assert.Len(t, matrix, 1) {
+		// the omitted sample at 180s is filled by the lookback
+		assert.Equal(t, []model.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: 60000, Value: 1}, {Timestamp: 120000, Value: 0},
+			{Timestamp: 180000, Value: 0}, {Timestamp: 240000, Value: 1}, {Timestamp: 300000, Value: 1}}, matrix[0].Values)
+	}
+}
+
+func TestMemoryQuery_errors(t *testing.T) {
+	ms := setupMemoryTest(t)
+
+	resp, err := ms.Query(t.Context(), `sum(`, "600", JSON)
+	if assert.NoError(t, err) {
+		var r Response
+		decodeBody(t, resp, &r)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		assert.Equal(t, ErrorType(ErrorBadData), r.ErrorType)
+	}
+
+	resp, err = ms.QueryRange(t.Context(), `up`, "0", "300", "0", JSON)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestMemorySeriesAndLabels(t *testing.T) {
+	ms := setupMemoryTest(t)
+
+	resp, err := ms.Series(t.Context(), []string{`{__name__="http_requests_total",project_id="12345"}`}, "", "", JSON)
+	if assert.NoError(t, err) {
+		var sr SeriesResponse
+		decodeBody(t, resp, &sr)
+		assert.Equal(t, []model.LabelSet{{"__name__": "http_requests_total", "job": "api", "project_id": "12345"}}, sr.Data)
+	}
+
+	resp, err = ms.LabelValues(t.Context(), "project_id", nil, "", "", JSON)
+	if assert.NoError(t, err) {
+		var lr LabelValuesResponse
+		decodeBody(t, resp, &lr)
+		assert.Equal(t, model.LabelValues{"12345", "67890"}, lr.Data)
+	}
+
+	resp, err = ms.LabelValues(t.Context(), "__name__", []string{`{project_id="67890"}`}, "", "", JSON)
+	if assert.NoError(t, err) {
+		var lr LabelValuesResponse
+		decodeBody(t, resp, &lr)
+		assert.Equal(t, model.LabelValues{"http_requests_total"}, lr.Data)
+	}
+
+	resp, err = ms.Labels(t.Context(), "", "", []string{`{__name__="openstack_compute_instances"}`}, JSON)
+	if assert.NoError(t, err) {
+		var lr LabelValuesResponse
+		decodeBody(t, resp, &lr)
+		assert.Equal(t, model.LabelValues{"__name__", "project_id"}, lr.Data)
+	}
+}
+
+func TestMemoryFederate(t *testing.T) {
+	ms := setupMemoryTest(t)
+
+	// only the samples loaded from the OpenMetrics file are recent enough
+	resp, err := ms.Federate(t.Context(), []string{`{project_id="12345"}`}, PlainText)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `openstack_compute_instances{project_id="12345"} 3`)
+	assert.NotContains(t, string(body), `http_requests_total`)
+}
+
+func TestMemoryMetadataAndExemplars(t *testing.T) {
+	ms := setupMemoryTest(t)
+
+	resp, err := ms.Metadata(t.Context(), "request_duration_seconds", "", JSON)
+	if assert.NoError(t, err) {
+		var mr MetadataResponse
+		decodeBody(t, resp, &mr)
+		assert.Equal(t, map[string][]MetricMetadata{
+			"request_duration_seconds": {{Type: model.MetricTypeCounter, Help: "Total duration of requests.", Unit: "seconds"}},
+		}, mr.Data)
+	}
+
+	resp, err = ms.QueryExemplars(t.Context(), `request_duration_seconds_total`, "0", "10", JSON)
+	if assert.NoError(t, err) {
+		var er ExemplarsResponse
+		decodeBody(t, resp, &er)
+		if assert.Len(t, er.Data, 1) && assert.Len(t, er.Data[0].Exemplars, 1) {
+			assert.Equal(t, model.LabelSet{"trace_id": "abc"}, er.Data[0].Exemplars[0].Labels)
+			assert.Equal(t, model.SampleValue(0.5), er.Data[0].Exemplars[0].Value)
+		}
+	}
+}
+
+func TestMemoryInvalidFile(t *testing.T) {
+	_, err := newMemoryStorageClient([]string{"fixtures/query.json"}, time.Unix(0, 0))
+	assert.Error(t, err)
+}
@@ -4,12 +4,16 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
 	"slices"
+	"strconv"
+	"time"
 
 	"github.com/spf13/viper"
 
@@ -17,72 +21,98 @@ import (
 )
 
 type prometheusStorageClient struct {
-	httpClient       *http.Client
-	url, federateURL *url.URL
-	customHeaders    map[string]string
+	replicas      *replicaSet
+	federate      *replicaSet
+	customHeaders map[string]string
+	// postThreshold is the length of the encoded parameters above which requests are sent as POST
+	postThreshold int
 }
 
+// defaultPostThreshold keeps request URLs well below the limits of common proxies and web servers
+const defaultPostThreshold = 4096
+
 // Prometheus creates a storage driver for Prometheus/Maia
 func Prometheus(prometheusAPIURL string, customHeaders map[string]string) Driver {
-	parsedURL, err := url.Parse(prometheusAPIURL)
-	if err != nil {
-		panic(err)
-	}
-	result := prometheusStorageClient{
-		url:           parsedURL,
-		customHeaders: customHeaders,
-	}
-	result.init()
-	return &result
+	connection := upstreamConfigFromViper("maia")
+	return newPrometheusStorageClient(backendConfig{
+		PrometheusURL: prometheusAPIURL,
+		// if federateURL is configured, this will direct /federate requests to another host URL
+		FederateURL:        viper.GetString("maia.federate_url"),
+		Replicas:           viper.GetStringSlice("maia.prometheus_replicas"),
+		Connection:         connection,
+		FederateConnection: federateUpstreamConfigFromViper("maia", connection),
+	}, customHeaders)
 }
 
-func (promCli *prometheusStorageClient) init() {
-	if viper.IsSet("maia.proxy") {
-		proxyURL, err := url.Parse(viper.GetString("maia.proxy"))
+// newPrometheusStorageClient creates a storage client for a single Prometheus backend. An empty federateURL
+// means that /federate requests are sent to the same replicas as all other API requests.
+func newPrometheusStorageClient(backend backendConfig, customHeaders map[string]string) *prometheusStorageClient {
+	urls := make([]*url.URL, 0, 1+len(backend.Replicas))
+	for _, u := range append([]string{backend.PrometheusURL}, backend.Replicas...) {
+		parsedURL, err := url.Parse(u)
 		if err != nil {
-			panic(fmt.Errorf("could not set proxy: %s .\n%s", proxyURL, err.Error()))
-		} else {
-			promCli.httpClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
-			return
+			panic(err)
 		}
+		urls = append(urls, parsedURL)
 	}
-	promCli.httpClient = &http.Client{}
 
-	// if federateURL is configured, this will direct /federate requests to another host URL
-	if viper.IsSet("maia.federate_url") {
-		parsedURL, err := url.Parse(viper.GetString("maia.federate_url"))
+	httpClient, err := newHTTPClient(backend.Connection)
+	if err != nil {
+		panic(fmt.Errorf("invalid connection settings for %s: %w", backend.PrometheusURL, err))
+	}
+
+	cfg := failoverConfigFromViper()
+	result := prometheusStorageClient{
+		replicas:      newReplicaSet(urls, httpClient, cfg),
+		customHeaders: customHeaders,
+		postThreshold: defaultPostThreshold,
+	}
+	if viper.IsSet("maia.post_threshold") {
+		result.postThreshold = viper.GetInt("maia.post_threshold")
+	}
+	result.federate = result.replicas
+	if backend.FederateURL != "" {
+		parsedURL, err := url.Parse(backend.FederateURL)
 		if err != nil {
 			panic(err)
 		}
-		promCli.federateURL = parsedURL
-	} else {
-		promCli.federateURL = promCli.url
+		federateClient, err := newHTTPClient(backend.FederateConnection)
+		if err != nil {
+			panic(fmt.Errorf("invalid connection settings for %s: %w", backend.FederateURL, err))
+		}
+		result.federate = newReplicaSet([]*url.URL{parsedURL}, federateClient, cfg)
+	}
+
+	// readiness only matters when there is a replica to fail over to
+	if len(result.replicas.replicas) > 1 {
+		result.replicas.startHealthChecks(customHeaders)
 	}
+	return &result
 }
 
-func (promCli *prometheusStorageClient) Query(query, time, timeout, acceptContentType string) (*http.Response, error) {
-	promURL := promCli.buildURL("/api/v1/query", map[string]any{"query": query, "time": time, "timeout": timeout})
+func (promCli *prometheusStorageClient) Query(ctx context.Context, query, time, acceptContentType string) (*http.Response, error) {
+	promURL := promCli.buildURL("/api/v1/query", map[string]any{"query": query, "time": time, "timeout": timeoutFromContext(ctx)})
 
-	return promCli.sendToPrometheus("GET", promURL.String(), nil, map[string]string{"Accept": acceptContentType})
+	return promCli.sendToReplicas(ctx, promCli.replicas, http.MethodGet, true, promURL, nil, map[string]string{"Accept": acceptContentType})
 }
 
-func (promCli *prometheusStorageClient) QueryRange(query, start, end, step, timeout, acceptContentType string) (*http.Response, error) {
+func (promCli *prometheusStorageClient) QueryRange(ctx context.Context, query, start, end, step, acceptContentType string) (*http.Response, error) {
 	promURL := promCli.buildURL("/api/v1/query_range", map[string]any{"query": query, "start": start, "end": end,
-		"step": step, "timeout": timeout})
+		"step": step, "timeout": timeoutFromContext(ctx)})
 
-	return promCli.sendToPrometheus("GET", promURL.String(), nil, map[string]string{"Accept": acceptContentType})
+	return promCli.sendToReplicas(ctx, promCli.replicas, http.MethodGet, true, promURL, nil, map[string]string{"Accept": acceptContentType})
 }
 
-func (promCli *prometheusStorageClient) Series(match []string, start, end, acceptContentType string) (*http.Response, error) {
+func (promCli *prometheusStorageClient) Series(ctx context.Context, match []string, start, end, acceptContentType string) (*http.Response, error) {
 	promURL := promCli.buildURL("/api/v1/series", map[string]any{"match[]": match, "start": start, "end": end})
 
-	return promCli.sendToPrometheus("GET", promURL.String(), nil, map[string]string{"Accept": acceptContentType})
+	return promCli.sendToReplicas(ctx, promCli.replicas, http.MethodGet, true, promURL, nil, map[string]string{"Accept": acceptContentType})
 }
 
-func (promCli *prometheusStorageClient) LabelValues(name, acceptContentType string) (*http.Response, error) {
-	promURL := promCli.buildURL("/api/v1/label/"+name+"/values", map[string]any{})
+func (promCli *prometheusStorageClient) LabelValues(ctx context.Context, name string, match []string, start, end, acceptContentType string) (*http.Response, error) {
+	promURL := promCli.buildURL("/api/v1/label/"+name+"/values", map[string]any{"match[]": match, "start": start, "end": end})
 
-	res, err := promCli.sendToPrometheus("GET", promURL.String(), nil, map[string]string{"Accept": acceptContentType})
+	res, err := promCli.sendToReplicas(ctx, promCli.replicas, http.MethodGet, false, promURL, nil, map[string]string{"Accept": acceptContentType})
 
 	return res, err
 }
@@ -91,28 +121,70 @@ func (promCli *prometheusStorageClient) LabelValues(name, acceptContentType stri
 // https://prometheus.io/docs/prometheus/latest/querying/api/#getting-label-names
 // match[]=<series_selector>: Repeated series selector argument that selects the series to return. At least one match[] argument must be provided.
 // Does this mean we need to use /api/v1/series to get the series selector?
-func (promCli *prometheusStorageClient) Labels(start, end string, match []string, acceptContentType string) (*http.Response, error) {
+func (promCli *prometheusStorageClient) Labels(ctx context.Context, start, end string, match []string, acceptContentType string) (*http.Response, error) {
 	promURL := promCli.buildURL("/api/v1/labels", map[string]any{"start": start, "end": end, "match[]": match})
 
-	return promCli.sendToPrometheus("GET", promURL.String(), nil, map[string]string{"Accept": acceptContentType})
+	return promCli.sendToReplicas(ctx, promCli.replicas, http.MethodGet, true, promURL, nil, map[string]string{"Accept": acceptContentType})
+}
+
+func (promCli *prometheusStorageClient) QueryExemplars(ctx context.Context, query, start, end, acceptContentType string) (*http.Response, error) {
+	promURL := promCli.buildURL("/api/v1/query_exemplars", map[string]any{"query": query, "start": start, "end": end})
+
+	return promCli.sendToReplicas(ctx, promCli.replicas, http.MethodGet, true, promURL, nil, map[string]string{"Accept": acceptContentType})
+}
+
+func (promCli *prometheusStorageClient) Metadata(ctx context.Context, metric, limitPerMetric, acceptContentType string) (*http.Response, error) {
+	promURL := promCli.buildURL("/api/v1/metadata", map[string]any{"metric": metric, "limit_per_metric": limitPerMetric})
+
+	return promCli.sendToReplicas(ctx, promCli.replicas, http.MethodGet, false, promURL, nil, map[string]string{"Accept": acceptContentType})
+}
+
+func (promCli *prometheusStorageClient) Alerts(ctx context.Context) (*http.Response, error) {
+	promURL := promCli.buildURL("/api/v1/alerts", map[string]any{})
+
+	return promCli.sendToReplicas(ctx, promCli.replicas, http.MethodGet, false, promURL, nil, map[string]string{"Accept": JSON})
+}
+
+func (promCli *prometheusStorageClient) Rules(ctx context.Context, ruleType string, ruleNames, ruleGroups []string) (*http.Response, error) {
+	promURL := promCli.buildURL("/api/v1/rules", map[string]any{"type": ruleType, "rule_name[]": ruleNames, "rule_group[]": ruleGroups})
+
+	return promCli.sendToReplicas(ctx, promCli.replicas, http.MethodGet, false, promURL, nil, map[string]string{"Accept": JSON})
 }
 
-func (promCli *prometheusStorageClient) Federate(selectors []string, acceptContentType string) (*http.Response, error) {
+func (promCli *prometheusStorageClient) Federate(ctx context.Context, selectors []string, acceptContentType string) (*http.Response, error) {
 	promURL := promCli.buildURL("/federate", map[string]any{"match[]": selectors})
 
-	return promCli.sendToPrometheus("GET", promURL.String(), nil, map[string]string{"Accept": acceptContentType})
+	return promCli.sendToReplicas(ctx, promCli.federate, http.MethodGet, false, promURL, nil, map[string]string{"Accept": acceptContentType})
 }
 
-// buildURL is used to build the target URL of a Prometheus call
-func (promCli *prometheusStorageClient) buildURL(path string, params map[string]any) url.URL {
-	promURL := *promCli.url
-	// treat federate special
-	if path == "/federate" {
-		promURL = *promCli.federateURL
+func (promCli *prometheusStorageClient) RemoteRead(ctx context.Context, request []byte) (*http.Response, error) {
+	return promCli.sendToReplicas(ctx, promCli.replicas, http.MethodPost, false, url.URL{Path: "/api/v1/read"}, request, map[string]string{
+		"Content-Type":                     "application/x-protobuf",
+		"Content-Encoding":                 "snappy",
+		"X-Prometheus-Remote-Read-Version": RemoteReadVersion,
+	})
+}
+
+// Close stops the health checks of the replicas
+func (promCli *prometheusStorageClient) Close() error {
+	promCli.replicas.close()
+	promCli.federate.close()
+	return nil
+}
+
+// timeoutFromContext converts the deadline of the context into the timeout parameter of the Prometheus query API,
+// so that Prometheus stops evaluating a query as soon as nobody waits for the result anymore
+func timeoutFromContext(ctx context.Context) string {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ""
 	}
+	return strconv.FormatFloat(max(time.Until(deadline).Seconds(), 0.001), 'f', 3, 64)
+}
 
-	// change original request to point to our backing Prometheus
-	promURL.Path += path
+// buildURL is used to build the path and query of a Prometheus call. It is resolved against the
+// URL of a replica when the request is sent.
+func (promCli *prometheusStorageClient) buildURL(path string, params map[string]any) url.URL {
 	queryParams := url.Values{}
 	for k, v := range params {
 		if s, ok := v.(string); ok {
@@ -125,29 +197,114 @@ func (promCli *prometheusStorageClient) buildURL(path string, params map[string]
 			}
 		}
 	}
-	promURL.RawQuery = queryParams.Encode()
 
-	return promURL
+	return url.URL{Path: path, RawQuery: queryParams.Encode()}
+}
+
+// sendToReplicas sends a request to the first available replica of the set. Since all requests to Prometheus
+// are reads, they are retried on connection errors and 5xx responses, preferring other replicas. When all
+// attempts fail, the last error response from Prometheus is returned (or the last connection error, if there
+// was no response at all). If allowPost is set, GET requests with long parameters (e.g. queries constrained to
+// large project trees) are sent as POST with a form-encoded body instead. Only pass it for endpoints of the
+// Prometheus API that accept POST likewise.
+func (promCli *prometheusStorageClient) sendToReplicas(ctx context.Context, set *replicaSet, method string, allowPost bool, reqURL url.URL, body []byte, headers map[string]string) (*http.Response, error) {
+	attempts := 1 + max(set.config.Retries, 0)
+
+	var lastResp *http.Response
+	var lastErr error
+	for i, r := range set.candidates(attempts) {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				if lastResp != nil {
+					lastResp.Body.Close()
+				}
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(i) * set.config.RetryBackoff):
+			}
+			logg.Debug("Retrying request on Prometheus replica %s (attempt %d/%d)", r.name, i+1, attempts)
+			replicaRetriesCounter.WithLabelValues(r.name).Inc()
+		}
+
+		resp, err := promCli.sendToReplica(ctx, set, r, method, allowPost, reqURL, body, headers)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				// aborted by the client, which is not the replica's fault
+				if lastResp != nil {
+					lastResp.Body.Close()
+				}
+				return nil, err
+			}
+			r.recordFailure(set.config)
+			lastErr = err
+		case resp.StatusCode >= http.StatusInternalServerError:
+			logg.Info("WARNING: Prometheus replica %s responded with %s", r.name, resp.Status)
+			r.recordFailure(set.config)
+			if lastResp != nil {
+				lastResp.Body.Close()
+			}
+			lastResp = resp
+		default:
+			r.recordSuccess()
+			if lastResp != nil {
+				lastResp.Body.Close()
+			}
+			return resp, nil
+		}
+	}
+
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	return nil, lastErr
+}
+
+// sendToReplica sends a single attempt of a request to a replica. The timeout parameter of queries is derived from
+// the deadline of the context for every attempt, since the time spent on previous attempts is gone.
+func (promCli *prometheusStorageClient) sendToReplica(ctx context.Context, set *replicaSet, r *replica, method string, allowPost bool, reqURL url.URL, body []byte, headers map[string]string) (*http.Response, error) {
+	if params := reqURL.Query(); params.Has("timeout") {
+		params.Set("timeout", timeoutFromContext(ctx))
+		reqURL.RawQuery = params.Encode()
+	}
+
+	if allowPost && method == http.MethodGet && promCli.postThreshold > 0 && len(reqURL.RawQuery) > promCli.postThreshold {
+		logg.Debug("Sending request to %s as POST due to %d bytes of parameters", reqURL.Path, len(reqURL.RawQuery))
+		method = http.MethodPost
+		body = []byte(reqURL.RawQuery)
+		reqURL.RawQuery = ""
+		headers = maps.Clone(headers)
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headers["Content-Type"] = "application/x-www-form-urlencoded"
+	}
+
+	promURL := r.resolve(reqURL)
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	return promCli.sendToPrometheus(ctx, set, method, promURL.String(), bodyReader, headers)
 }
 
 // sendToPrometheus takes care of the request wrapping and delivery to Prometheus.
-//
-//nolint:unparam // method is currently always "GET" but kept generic for API flexibility
-func (promCli *prometheusStorageClient) sendToPrometheus(method, promURL string, body io.Reader, headers map[string]string) (*http.Response, error) {
+func (promCli *prometheusStorageClient) sendToPrometheus(ctx context.Context, set *replicaSet, method, promURL string, body io.Reader, headers map[string]string) (*http.Response, error) {
 	// Defense-in-depth: verify the URL targets a trusted upstream before sending.
-	// All Driver methods construct URLs via buildURL() which uses only the
-	// configured promCli.url / promCli.federateURL base, but this check makes
+	// All Driver methods resolve URLs via replica.resolve() which uses only the
+	// configured replica URLs as base, but this check makes
 	// the safety property explicit and guards against future regressions.
 	if err := promCli.validateUpstreamURL(promURL); err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(context.Background(), method, promURL, body)
+	req, err := http.NewRequestWithContext(ctx, method, promURL, body)
 	if err != nil {
 		logg.Error("Could not create request.\n", err.Error())
 		return nil, err
 	}
 
+	req.Header.Set("Accept-Encoding", upstreamAcceptEncoding)
 	for k, v := range promCli.customHeaders {
 		req.Header.Add(k, v)
 	}
@@ -157,9 +314,18 @@ func (promCli *prometheusStorageClient) sendToPrometheus(method, promURL string,
 
 	logg.Debug("Forwarding request to API: %s", promURL)
 
-	resp, err := promCli.httpClient.Do(req)
+	resp, err := set.client.Do(req)
 	if err != nil {
-		logg.Error("Request failed.\n%s", err.Error())
+		if ctx.Err() != nil {
+			logg.Info("Request aborted: %s", err.Error())
+		} else {
+			logg.Error("Request failed.\n%s", err.Error())
+		}
+		return nil, err
+	}
+	if err := decodeResponse(resp); err != nil {
+		resp.Body.Close()
+		logg.Error("Invalid response from %s: %s", promURL, err.Error())
 		return nil, err
 	}
 	return resp, nil
@@ -185,12 +351,16 @@ func (promCli *prometheusStorageClient) validateUpstreamURL(urlStr string) error
 		return fmt.Errorf("invalid URL %q: empty host", urlStr)
 	}
 
-	// Enforce that the host matches a trusted upstream. promCli.url is always set;
-	// promCli.federateURL is set in init() (it falls back to promCli.url when no
-	// dedicated federate URL is configured).
-	allowedHosts := []string{promCli.url.Host}
-	if promCli.federateURL != nil && promCli.federateURL.Host != promCli.url.Host {
-		allowedHosts = append(allowedHosts, promCli.federateURL.Host)
+	// Enforce that the host matches a trusted upstream, i.e. one of the configured
+	// replicas or the federate URL (which falls back to the replicas when no
+	// dedicated federate URL is configured, see newPrometheusStorageClient()).
+	var allowedHosts []string
+	for _, set := range []*replicaSet{promCli.replicas, promCli.federate} {
+		for _, r := range set.replicas {
+			if !slices.Contains(allowedHosts, r.url.Host) {
+				allowedHosts = append(allowedHosts, r.url.Host)
+			}
+		}
 	}
 	if slices.Contains(allowedHosts, parsedURL.Host) {
 		return nil
@@ -4,10 +4,20 @@
 package storage
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/h2non/gock"
+	"github.com/klauspost/compress/zstd"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
@@ -24,7 +34,7 @@ func setupTest(t *testing.T) Driver { //nolint:unparam
 	viper.Set("maia.prometheus_url", prometheusURL)
 	viper.Set("maia.federate_url", federateURL)
 
-	return NewPrometheusDriver(prometheusURL, map[string]string{})
+	return NewPrometheusDriver(prometheusURL, map[string]string{}, nil)
 }
 
 func mocksToStrings(mocks []gock.Mock) []string {
@@ -58,7 +68,7 @@ func TestFederate(t *testing.T) {
 		File("fixtures/federate.txt").
 		AddHeader("Content-Type", PlainText)
 
-	_, err := ps.Federate([]string{"{vmware_name=\"win_cifs_13\",project_id=\"p00001\"}"}, PlainText)
+	_, err := ps.Federate(t.Context(), []string{"{vmware_name=\"win_cifs_13\",project_id=\"p00001\"}"}, PlainText)
 
 	assert.Nil(t, err, "Federate should not fail")
 
@@ -71,11 +81,12 @@ func TestLabelValues(t *testing.T) {
 	ps := setupTest(t)
 
 	gock.New(prometheusURL).Get("/api/v1/label/service/values").
+		MatchParams(map[string]string{"match[]": `{project_id="12345"}`, "start": "1499066783", "end": "1499153183"}).
 		Reply(http.StatusOK).
 		File("fixtures/label_values.json").
 		AddHeader("Content-Type", JSON)
 
-	_, err := ps.LabelValues("service", JSON)
+	_, err := ps.LabelValues(t.Context(), "service", []string{`{project_id="12345"}`}, "1499066783", "1499153183", JSON)
 
 	assert.Nil(t, err, "label/.../values should not fail")
 
@@ -99,7 +110,7 @@ func TestLabels(t *testing.T) {
 	start := "2023-05-12T00:00:00Z"
 	end := "2023-05-12T23:59:59Z"
 	match := []string{"project_id=\"p00001\""}
-	_, err := ps.Labels(start, end, match, JSON)
+	_, err := ps.Labels(t.Context(), start, end, match, JSON)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,13 +120,203 @@ func TestLabels(t *testing.T) {
 	assertDone(t)
 }
 
+func TestRules(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupTest(t)
+
+	gock.New(prometheusURL).Get("/api/v1/rules").
+		MatchParams(map[string]string{"type": "alert", "rule_group[]": "instances"}).
+		Reply(http.StatusOK).
+		BodyString(`{"status":"success","data":{"groups":[]}}`).
+		AddHeader("Content-Type", JSON)
+
+	_, err := ps.Rules(t.Context(), "alert", nil, []string{"instances"})
+
+	assert.Nil(t, err, "rules should not fail")
+
+	assertDone(t)
+}
+
+func TestQueryRange_timeoutFromDeadline(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupTest(t)
+
+	gock.New(prometheusURL).Get("/api/v1/query_range").
+		MatchParam("timeout", `^(29|30)\.\d{3}$`).
+		Reply(http.StatusOK).
+		File("fixtures/query.json").
+		AddHeader("Content-Type", JSON)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 30*time.Second)
+	defer cancel()
+	_, err := ps.QueryRange(ctx, "sum(up)", "1500000000", "1500003600", "60", JSON)
+
+	assert.Nil(t, err, "query_range should not fail")
+
+	assertDone(t)
+}
+
+func TestQuery_cancelled(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupTest(t)
+
+	gock.New(prometheusURL).Get("/api/v1/query").
+		Reply(http.StatusOK).
+		File("fixtures/query.json").
+		AddHeader("Content-Type", JSON)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	_, err := ps.Query(ctx, "sum(up)", "", JSON)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestQuery_compressedResponse(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupTest(t)
+
+	fixture, err := os.ReadFile("fixtures/query.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gzipped bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(fixture) //nolint:errcheck
+	gw.Close()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed := map[string][]byte{"gzip": gzipped.Bytes(), "zstd": zw.EncodeAll(fixture, nil)}
+
+	for encoding, body := range compressed {
+		gock.New(prometheusURL).Get("/api/v1/query").
+			MatchHeader("Accept-Encoding", "^zstd, gzip$").
+			Reply(http.StatusOK).
+			Body(bytes.NewReader(body)).
+			AddHeader("Content-Type", JSON).
+			AddHeader("Content-Encoding", encoding)
+
+		resp, err := ps.Query(t.Context(), "sum(up)", "", JSON)
+		if !assert.NoError(t, err, encoding) {
+			continue
+		}
+		content, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NoError(t, err, encoding)
+		assert.Equal(t, string(fixture), string(content), encoding)
+		assert.Empty(t, resp.Header.Get("Content-Encoding"), encoding)
+	}
+
+	assertDone(t)
+}
+
+func TestQuery_postLongQuery(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupTest(t)
+
+	projects := make([]string, 500)
+	for i := range projects {
+		projects[i] = fmt.Sprintf("p%05d", i)
+	}
+	longQuery := `sum(up{project_id=~"` + strings.Join(projects, "|") + `"})`
+
+	gock.New(prometheusURL).Get("/api/v1/query").
+		MatchParam("query", "^sum\\(up\\)$").
+		Reply(http.StatusOK).
+		File("fixtures/query.json").
+		AddHeader("Content-Type", JSON)
+	gock.New(prometheusURL).Post("/api/v1/query").
+		MatchType("url").
+		BodyString(url.Values{"query": {longQuery}}.Encode()).
+		Reply(http.StatusOK).
+		File("fixtures/query.json").
+		AddHeader("Content-Type", JSON)
+
+	for _, query := range []string{"sum(up)", longQuery} {
+		resp, err := ps.Query(t.Context(), query, "", JSON)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	}
+
+	assertDone(t)
+}
+
+func TestGetOnlyEndpointsStayGet(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupTest(t).(*prometheusStorageClient)
+	ps.postThreshold = 16
+
+	selector := `{project_id=~"p00001|p00002|p00003|p00004"}`
+	gock.New(federateURL).Get("/federate").
+		MatchParams(map[string]string{"match[]": selector}).
+		Reply(http.StatusOK).
+		File("fixtures/federate.txt").
+		AddHeader("Content-Type", PlainText)
+	gock.New(prometheusURL).Get("/api/v1/label/service/values").
+		MatchParams(map[string]string{"match[]": selector}).
+		Reply(http.StatusOK).
+		File("fixtures/label_values.json").
+		AddHeader("Content-Type", JSON)
+
+	resp, err := ps.Federate(t.Context(), []string{selector}, PlainText)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	resp, err = ps.LabelValues(t.Context(), "service", []string{selector}, "", "", JSON)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	assertDone(t)
+}
+
+func TestRemoteRead(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupTest(t)
+
+	gock.New(prometheusURL).Post("/api/v1/read").
+		MatchHeader("Content-Encoding", "^snappy$").
+		MatchHeader("Content-Type", "^application/x-protobuf$").
+		MatchHeader("X-Prometheus-Remote-Read-Version", "^0.1.0$").
+		Reply(http.StatusOK).
+		BodyString("response").
+		AddHeader("Content-Type", "application/x-protobuf").
+		AddHeader("Content-Encoding", "snappy")
+
+	resp, err := ps.RemoteRead(t.Context(), []byte("request"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	// the snappy-compressed response is passed on as is
+	assert.Equal(t, "snappy", resp.Header.Get("Content-Encoding"))
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "response", string(body))
+
+	assertDone(t)
+}
+
 // TestValidateUpstreamURL exercises the SSRF defense-in-depth check that backs
 // CodeQL go/request-forgery. mapURL() rewrites Host/Scheme/User to the trusted
 // upstream, so the validator should accept the configured Prometheus host and
 // the federate host but reject anything else.
 func TestValidateUpstreamURL(t *testing.T) {
 	setupTest(t)
-	driver := NewPrometheusDriver(prometheusURL, map[string]string{}).(*prometheusStorageClient)
+	driver := NewPrometheusDriver(prometheusURL, map[string]string{}, nil).(*prometheusStorageClient)
 
 	cases := []struct {
 		name    string
@@ -152,12 +353,12 @@ func TestValidateUpstreamURL(t *testing.T) {
 func TestSendToPrometheusRejectsUntrustedHost(t *testing.T) {
 	defer gock.Off()
 	setupTest(t)
-	driver := NewPrometheusDriver(prometheusURL, map[string]string{}).(*prometheusStorageClient)
+	driver := NewPrometheusDriver(prometheusURL, map[string]string{}, nil).(*prometheusStorageClient)
 
 	// No gock mock registered — if validation fails to block, the request would
 	// hit the real network (or gock's "unmatched" path) and we'd see a different
 	// error. Validation must short-circuit before any HTTP call.
-	resp, err := driver.sendToPrometheus("GET", "http://attacker.invalid/api/v1/query", nil, nil)
+	resp, err := driver.sendToPrometheus(t.Context(), driver.replicas, "GET", "http://attacker.invalid/api/v1/query", nil, nil)
 	assert.Nil(t, resp)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "untrusted host")
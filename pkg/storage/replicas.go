@@ -0,0 +1,257 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+
+	"github.com/sapcc/go-bits/logg"
+)
+
+var replicaUpGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "maia_tsdb_replica_up", Help: "Result of the last readiness check of a Prometheus replica (1 = ready)"},
+	[]string{"replica"})
+var replicaCircuitOpenGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "maia_tsdb_replica_circuit_open", Help: "Whether the circuit breaker of a Prometheus replica is open, i.e. the replica is avoided after repeated failures"},
+	[]string{"replica"})
+var replicaFailuresCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "maia_tsdb_replica_failures_count", Help: "Number of requests to a Prometheus replica that failed with a connection error or 5xx status"},
+	[]string{"replica"})
+var replicaRetriesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "maia_tsdb_retries_count", Help: "Number of requests retried on a Prometheus replica after a previous attempt failed"},
+	[]string{"replica"})
+
+func init() {
+	prometheus.MustRegister(replicaUpGauge, replicaCircuitOpenGauge, replicaFailuresCounter, replicaRetriesCounter)
+}
+
+// failoverConfig contains the settings for health checks, retries and circuit breaking of replicas
+type failoverConfig struct {
+	// HealthCheckInterval is the time between two readiness checks of a replica (0 disables the checks)
+	HealthCheckInterval time.Duration
+	// Retries is the number of additional attempts for idempotent requests
+	Retries int
+	// RetryBackoff is the wait time before the first retry, it grows linearly with every retry
+	RetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failures after which a replica is avoided
+	BreakerThreshold int
+	// BreakerCooldown is the time for which a replica is avoided after its circuit breaker opened
+	BreakerCooldown time.Duration
+}
+
+// failoverConfigFromViper reads the failover settings from the [maia] section
+func failoverConfigFromViper() failoverConfig {
+	cfg := failoverConfig{
+		HealthCheckInterval: 10 * time.Second,
+		Retries:             2,
+		RetryBackoff:        100 * time.Millisecond,
+		BreakerThreshold:    5,
+		BreakerCooldown:     30 * time.Second,
+	}
+	if viper.IsSet("maia.health_check_interval") {
+		cfg.HealthCheckInterval = viper.GetDuration("maia.health_check_interval")
+	}
+	if viper.IsSet("maia.retries") {
+		cfg.Retries = viper.GetInt("maia.retries")
+	}
+	if viper.IsSet("maia.retry_backoff") {
+		cfg.RetryBackoff = viper.GetDuration("maia.retry_backoff")
+	}
+	if viper.IsSet("maia.circuit_breaker_threshold") {
+		cfg.BreakerThreshold = viper.GetInt("maia.circuit_breaker_threshold")
+	}
+	if viper.IsSet("maia.circuit_breaker_cooldown") {
+		cfg.BreakerCooldown = viper.GetDuration("maia.circuit_breaker_cooldown")
+	}
+	return cfg
+}
+
+// replica is a single Prometheus instance out of a set of identical instances
+type replica struct {
+	url *url.URL
+	// name identifies the replica in metrics and logs (the URL without credentials)
+	name string
+
+	mutex               sync.Mutex
+	ready               bool
+	consecutiveFailures int
+	circuitOpenUntil    time.Time
+}
+
+func newReplica(u *url.URL) *replica {
+	r := &replica{url: u, name: u.Redacted(), ready: true}
+	replicaUpGauge.WithLabelValues(r.name).Set(1)
+	replicaCircuitOpenGauge.WithLabelValues(r.name).Set(0)
+	return r
+}
+
+// resolve returns the URL of the given API path and query on this replica
+func (r *replica) resolve(reqURL url.URL) url.URL {
+	result := *r.url
+	result.Path += reqURL.Path
+	result.RawQuery = reqURL.RawQuery
+	return result
+}
+
+// available tells whether the replica is ready and its circuit breaker is closed
+func (r *replica) available(now time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ready && !now.Before(r.circuitOpenUntil)
+}
+
+func (r *replica) setReady(ready bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if ready != r.ready {
+		logg.Info("Prometheus replica %s changed readiness to %t", r.name, ready)
+	}
+	r.ready = ready
+	if ready {
+		replicaUpGauge.WithLabelValues(r.name).Set(1)
+	} else {
+		replicaUpGauge.WithLabelValues(r.name).Set(0)
+	}
+}
+
+// recordSuccess closes the circuit breaker of the replica
+func (r *replica) recordSuccess() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.consecutiveFailures = 0
+	if !r.circuitOpenUntil.IsZero() {
+		logg.Info("Circuit breaker of Prometheus replica %s closed", r.name)
+		r.circuitOpenUntil = time.Time{}
+		replicaCircuitOpenGauge.WithLabelValues(r.name).Set(0)
+	}
+}
+
+// recordFailure opens the circuit breaker once the replica failed too often in a row. After the cooldown,
+// the replica gets another chance and the next failure opens the circuit breaker again.
+func (r *replica) recordFailure(cfg failoverConfig) {
+	replicaFailuresCounter.WithLabelValues(r.name).Inc()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.consecutiveFailures++
+	if cfg.BreakerThreshold > 0 && r.consecutiveFailures >= cfg.BreakerThreshold {
+		logg.Info("WARNING: Circuit breaker of Prometheus replica %s opened after %d failures", r.name, r.consecutiveFailures)
+		r.circuitOpenUntil = time.Now().Add(cfg.BreakerCooldown)
+		replicaCircuitOpenGauge.WithLabelValues(r.name).Set(1)
+	}
+}
+
+// replicaSet is a list of identical Prometheus instances in order of preference
+type replicaSet struct {
+	replicas []*replica
+	// client is configured with the authentication and TLS settings of the replicas
+	client *http.Client
+	config failoverConfig
+	// stopHealthChecks ends the background health checks of the replicas and waits for them to finish
+	stopHealthChecks func()
+}
+
+func newReplicaSet(urls []*url.URL, client *http.Client, cfg failoverConfig) *replicaSet {
+	set := &replicaSet{client: client, config: cfg}
+	for _, u := range urls {
+		set.replicas = append(set.replicas, newReplica(u))
+	}
+	return set
+}
+
+// candidates returns the replicas to use for the given number of attempts: available replicas
+// come first in order of preference. Unavailable ones are only used when no replica is available.
+// With fewer replicas than attempts, the list wraps around.
+func (set *replicaSet) candidates(attempts int) []*replica {
+	now := time.Now()
+	var available []*replica
+	for _, r := range set.replicas {
+		if r.available(now) {
+			available = append(available, r)
+		}
+	}
+	if len(available) == 0 {
+		available = set.replicas
+	}
+
+	result := make([]*replica, attempts)
+	for i := range result {
+		result[i] = available[i%len(available)]
+	}
+	return result
+}
+
+// startHealthChecks periodically checks the readiness endpoint of every replica in the background, until
+// stopHealthChecks is called
+func (set *replicaSet) startHealthChecks(headers map[string]string) {
+	if set.config.HealthCheckInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, r := range set.replicas {
+		wg.Go(func() {
+			ticker := time.NewTicker(set.config.HealthCheckInterval)
+			defer ticker.Stop()
+			for {
+				ready := checkReadiness(ctx, set.client, headers, r, set.config.HealthCheckInterval)
+				if ctx.Err() != nil {
+					// the checks were stopped, which says nothing about the replica
+					return
+				}
+				r.setReady(ready)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		})
+	}
+	set.stopHealthChecks = func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// close stops the health checks of the replicas, if any
+func (set *replicaSet) close() {
+	if set.stopHealthChecks != nil {
+		set.stopHealthChecks()
+		set.stopHealthChecks = nil
+	}
+}
+
+// checkReadiness asks the /-/ready endpoint of Prometheus (or Thanos) whether the replica can serve queries
+func checkReadiness(ctx context.Context, client *http.Client, headers map[string]string, r *replica, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	readyURL := r.resolve(url.URL{Path: "/-/ready"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, readyURL.String(), http.NoBody)
+	if err != nil {
+		logg.Error("Could not create readiness check for %s: %s", r.name, err.Error())
+		return false
+	}
+	for k, v := range headers {
+		req.Header.Add(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			logg.Debug("Readiness check of %s failed: %s", r.name, err.Error())
+		}
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
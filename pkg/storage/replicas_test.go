@@ -0,0 +1,254 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/h2non/gock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	replicaAURL = "http://prometheus-1.local"
+	replicaBURL = "http://prometheus-2.local"
+)
+
+func setupReplicaTest(t *testing.T) *prometheusStorageClient {
+	viper.Set("maia.health_check_interval", "0s")
+	viper.Set("maia.retry_backoff", "1ms")
+	viper.Set("maia.circuit_breaker_threshold", 2)
+	t.Cleanup(func() {
+		for _, key := range []string{"maia.health_check_interval", "maia.retry_backoff", "maia.circuit_breaker_threshold"} {
+			viper.Set(key, nil)
+		}
+	})
+
+	return newPrometheusStorageClient(backendConfig{PrometheusURL: replicaAURL, Replicas: []string{replicaBURL}}, map[string]string{})
+}
+
+func TestReplicas_failoverOn5xx(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupReplicaTest(t)
+
+	gock.New(replicaAURL).Get("/api/v1/query").
+		Reply(http.StatusBadGateway)
+	gock.New(replicaBURL).Get("/api/v1/query").
+		Reply(http.StatusOK).
+		File("fixtures/query.json").
+		AddHeader("Content-Type", JSON)
+
+	resp, err := ps.Query(t.Context(), "up", "", JSON)
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.InDelta(t, 1, testutil.ToFloat64(replicaRetriesCounter.WithLabelValues(replicaBURL)), 0.1)
+
+	assertDone(t)
+}
+
+func TestReplicas_failoverOnConnectionError(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupReplicaTest(t)
+
+	gock.New(replicaAURL).Get("/api/v1/series").
+		ReplyError(errors.New("connection refused"))
+	gock.New(replicaBURL).Get("/api/v1/series").
+		Reply(http.StatusOK).
+		File("fixtures/series.json").
+		AddHeader("Content-Type", JSON)
+
+	resp, err := ps.Series(t.Context(), []string{"{component=\"objectstore\"}"}, "", "", JSON)
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	assertDone(t)
+}
+
+func TestReplicas_noRetryOnClientError(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupReplicaTest(t)
+
+	gock.New(replicaAURL).Get("/api/v1/query").
+		Reply(http.StatusBadRequest).
+		BodyString(`{"status":"error","errorType":"bad_data","error":"parse error"}`).
+		AddHeader("Content-Type", JSON)
+
+	resp, err := ps.Query(t.Context(), "sum(", "", JSON)
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	assertDone(t)
+}
+
+func TestReplicas_allFailed(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupReplicaTest(t)
+
+	gock.New(replicaAURL).Get("/api/v1/query").
+		Times(2).
+		Reply(http.StatusServiceUnavailable).
+		BodyString(`{"status":"error","errorType":"timeout","error":"query timed out"}`)
+	gock.New(replicaBURL).Get("/api/v1/query").
+		Reply(http.StatusServiceUnavailable).
+		BodyString(`{"status":"error","errorType":"timeout","error":"query timed out"}`)
+
+	resp, err := ps.Query(t.Context(), "up", "", JSON)
+	if assert.NoError(t, err, "last error response should be passed on") {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	assertDone(t)
+}
+
+func TestReplicas_timeoutPerAttempt(t *testing.T) {
+	defer gock.Off()
+
+	ps := setupReplicaTest(t)
+
+	var timeouts []float64
+	recordTimeout := func(req *http.Request, _ *gock.Request) (bool, error) {
+		timeout, err := strconv.ParseFloat(req.URL.Query().Get("timeout"), 64)
+		timeouts = append(timeouts, timeout)
+		return err == nil, err
+	}
+	gock.New(replicaAURL).Get("/api/v1/query").
+		AddMatcher(recordTimeout).
+		Reply(http.StatusServiceUnavailable).
+		Delay(50 * time.Millisecond)
+	gock.New(replicaBURL).Get("/api/v1/query").
+		AddMatcher(recordTimeout).
+		Reply(http.StatusOK).
+		File("fixtures/query.json").
+		AddHeader("Content-Type", JSON)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 30*time.Second)
+	defer cancel()
+	resp, err := ps.Query(ctx, "up", "", JSON)
+	if assert.NoError(t, err) {
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	// the retry only gets the time that is left of the deadline
+	if assert.Len(t, timeouts, 2) {
+		assert.Less(t, timeouts[1], timeouts[0]-0.04)
+	}
+
+	assertDone(t)
+}
+
+func TestReplicas_circuitBreaker(t *testing.T) {
+	ps := setupReplicaTest(t)
+	a, b := ps.replicas.replicas[0], ps.replicas.replicas[1]
+
+	assert.Equal(t, []*replica{a, b, a}, ps.replicas.candidates(3))
+
+	a.recordFailure(ps.replicas.config)
+	assert.True(t, a.available(time.Now()), "circuit breaker should open only after the threshold")
+	a.recordFailure(ps.replicas.config)
+	assert.False(t, a.available(time.Now()))
+	assert.InDelta(t, 1, testutil.ToFloat64(replicaCircuitOpenGauge.WithLabelValues(a.name)), 0.1)
+	assert.Equal(t, []*replica{b, b}, ps.replicas.candidates(2))
+	assert.True(t, a.available(time.Now().Add(time.Minute)), "replica should get another chance after the cooldown")
+
+	a.recordSuccess()
+	assert.True(t, a.available(time.Now()))
+	assert.InDelta(t, 0, testutil.ToFloat64(replicaCircuitOpenGauge.WithLabelValues(a.name)), 0.1)
+
+	// when no replica is available, all of them are tried anyway
+	a.setReady(false)
+	b.setReady(false)
+	assert.Equal(t, []*replica{a, b}, ps.replicas.candidates(2))
+}
+
+func TestReplicas_readinessCheck(t *testing.T) {
+	ready := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/thanos/-/ready", r.URL.Path)
+		if ready {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL + "/thanos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newReplica(u)
+	client := &http.Client{Transport: &http.Transport{}}
+
+	assert.True(t, checkReadiness(context.Background(), client, nil, r, time.Second))
+	ready = false
+	assert.False(t, checkReadiness(context.Background(), client, nil, r, time.Second))
+}
+
+func TestReplicas_stopHealthChecks(t *testing.T) {
+	var checks atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := newReplicaSet([]*url.URL{u, u}, &http.Client{Transport: &http.Transport{}}, failoverConfig{HealthCheckInterval: 5 * time.Millisecond})
+	set.startHealthChecks(nil)
+	assert.Eventually(t, func() bool { return checks.Load() >= 4 }, time.Second, time.Millisecond)
+
+	// after close returns, no more checks are made
+	set.close()
+	stopped := checks.Load()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, stopped, checks.Load())
+}
+
+func TestReplicas_stopDuringHealthCheck(t *testing.T) {
+	inFlight := make(chan struct{}, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := newReplicaSet([]*url.URL{u, u}, &http.Client{Transport: &http.Transport{}}, failoverConfig{HealthCheckInterval: time.Minute})
+	set.startHealthChecks(nil)
+	<-inFlight
+	<-inFlight
+
+	// stopping the checks says nothing about the health of the replicas
+	set.close()
+	for _, r := range set.replicas {
+		assert.True(t, r.available(time.Now()), "%s should still be ready", r.name)
+	}
+}
@@ -0,0 +1,276 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/spf13/viper"
+
+	"github.com/sapcc/go-bits/logg"
+
+	"github.com/SAP-cloud-infrastructure/maia/pkg/util"
+)
+
+// routingRule selects a backend for requests matching all of its conditions.
+// It is configured in a [[maia.routing.rules]] section.
+type routingRule struct {
+	Backend string `mapstructure:"backend"`
+	// Metric is a regex that must match all metric names referenced by the request
+	Metric string `mapstructure:"metric"`
+	// Tenant is a regex that must match one of the project/domain IDs the request is scoped to
+	Tenant string `mapstructure:"tenant"`
+	// MinAge is the minimum age of the requested start time
+	MinAge string `mapstructure:"min_age"`
+
+	metricRegexp, tenantRegexp *regexp.Regexp
+	minAge                     time.Duration
+}
+
+// routingRequest contains the attributes of a request that are relevant for routing
+type routingRequest struct {
+	selectors [][]*labels.Matcher
+	start     time.Time
+}
+
+// routingStorageClient is a storage Driver that sends each request to exactly one backend,
+// chosen by the first matching routing rule
+type routingStorageClient struct {
+	backends       map[string]Driver
+	rules          []routingRule
+	defaultBackend string
+	// tenantLabels are the labels used by Maia to restrict queries to a project or domain (see [maia.scope_labels])
+	tenantLabels []string
+}
+
+// Routing creates a storage driver that routes every request to one of the backends configured in the
+// [maia.backends.<name>] sections, based on the rules in the [maia.routing] section. The tenant conditions of the
+// rules match the values of the given labels.
+func Routing(customHeaders map[string]string, tenantLabels []string) Driver {
+	backends := map[string]Driver{}
+	for _, b := range newBackendDrivers(backendsFromConfig(), customHeaders) {
+		backends[b.name] = b.driver
+	}
+
+	var rules []routingRule
+	if err := viper.UnmarshalKey("maia.routing.rules", &rules); err != nil {
+		panic(fmt.Errorf("invalid routing rules (maia.routing.rules): %w", err))
+	}
+
+	result, err := newRoutingStorageClient(backends, rules, viper.GetString("maia.routing.default_backend"), tenantLabels)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// newRoutingStorageClient validates the routing configuration and compiles the rules
+func newRoutingStorageClient(backends map[string]Driver, rules []routingRule, defaultBackend string, tenantLabels []string) (*routingStorageClient, error) {
+	if _, ok := backends[defaultBackend]; !ok {
+		return nil, fmt.Errorf("default backend %q of routing storage driver is not configured (maia.routing.default_backend)", defaultBackend)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if _, ok := backends[rule.Backend]; !ok {
+			return nil, fmt.Errorf("routing rule %d refers to unknown backend %q", i+1, rule.Backend)
+		}
+		if rule.Metric == "" && rule.Tenant == "" && rule.MinAge == "" {
+			return nil, fmt.Errorf("routing rule %d has no conditions (metric, tenant or min_age)", i+1)
+		}
+
+		var err error
+		if rule.Metric != "" {
+			// anchor the regex like in PromQL
+			rule.metricRegexp, err = regexp.Compile("^(?:" + rule.Metric + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid metric regex in routing rule %d: %w", i+1, err)
+			}
+		}
+		if rule.Tenant != "" {
+			if len(tenantLabels) == 0 {
+				return nil, fmt.Errorf("routing rule %d has a tenant condition, but no scope labels are known", i+1)
+			}
+			rule.tenantRegexp, err = regexp.Compile("^(?:" + rule.Tenant + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid tenant regex in routing rule %d: %w", i+1, err)
+			}
+		}
+		if rule.MinAge != "" {
+			rule.minAge, err = time.ParseDuration(rule.MinAge)
+			if err != nil {
+				return nil, fmt.Errorf("invalid min_age in routing rule %d: %w", i+1, err)
+			}
+		}
+		logg.Info("Routing rule %d: metric=%q tenant=%q min_age=%q --> %s", i+1, rule.Metric, rule.Tenant, rule.MinAge, rule.Backend)
+	}
+	logg.Info("Routing other requests to %s", defaultBackend)
+
+	return &routingStorageClient{backends: backends, rules: rules, defaultBackend: defaultBackend, tenantLabels: tenantLabels}, nil
+}
+
+func (r *routingStorageClient) Query(ctx context.Context, query, time, acceptContentType string) (*http.Response, error) {
+	return r.route(newRoutingRequestFromExpression(query, time)).Query(ctx, query, time, acceptContentType)
+}
+
+func (r *routingStorageClient) QueryRange(ctx context.Context, query, start, end, step, acceptContentType string) (*http.Response, error) {
+	return r.route(newRoutingRequestFromExpression(query, start)).QueryRange(ctx, query, start, end, step, acceptContentType)
+}
+
+func (r *routingStorageClient) Series(ctx context.Context, match []string, start, end, acceptContentType string) (*http.Response, error) {
+	return r.route(newRoutingRequestFromSelectors(match, start)).Series(ctx, match, start, end, acceptContentType)
+}
+
+func (r *routingStorageClient) LabelValues(ctx context.Context, name string, match []string, start, end, acceptContentType string) (*http.Response, error) {
+	return r.route(newRoutingRequestFromSelectors(match, start)).LabelValues(ctx, name, match, start, end, acceptContentType)
+}
+
+func (r *routingStorageClient) Labels(ctx context.Context, start, end string, match []string, acceptContentType string) (*http.Response, error) {
+	return r.route(newRoutingRequestFromSelectors(match, start)).Labels(ctx, start, end, match, acceptContentType)
+}
+
+func (r *routingStorageClient) QueryExemplars(ctx context.Context, query, start, end, acceptContentType string) (*http.Response, error) {
+	return r.route(newRoutingRequestFromExpression(query, start)).QueryExemplars(ctx, query, start, end, acceptContentType)
+}
+
+func (r *routingStorageClient) Metadata(ctx context.Context, metric, limitPerMetric, acceptContentType string) (*http.Response, error) {
+	var match []string
+	if metric != "" {
+		match = []string{metric}
+	}
+	return r.route(newRoutingRequestFromSelectors(match, "")).Metadata(ctx, metric, limitPerMetric, acceptContentType)
+}
+
+// Alerts are taken from the default backend unless a rule without conditions routes them otherwise
+func (r *routingStorageClient) Alerts(ctx context.Context) (*http.Response, error) {
+	return r.route(newRoutingRequestFromSelectors(nil, "")).Alerts(ctx)
+}
+
+func (r *routingStorageClient) Rules(ctx context.Context, ruleType string, ruleNames, ruleGroups []string) (*http.Response, error) {
+	return r.route(newRoutingRequestFromSelectors(nil, "")).Rules(ctx, ruleType, ruleNames, ruleGroups)
+}
+
+func (r *routingStorageClient) Federate(ctx context.Context, selectors []string, acceptContentType string) (*http.Response, error) {
+	return r.route(newRoutingRequestFromSelectors(selectors, "")).Federate(ctx, selectors, acceptContentType)
+}
+
+func (r *routingStorageClient) RemoteRead(ctx context.Context, request []byte) (*http.Response, error) {
+	return r.route(newRoutingRequestFromSelectors(nil, "")).RemoteRead(ctx, request)
+}
+
+// Close closes all backends
+func (r *routingStorageClient) Close() error {
+	var errs []error
+	for _, d := range r.backends {
+		errs = append(errs, d.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// route returns the backend of the first matching rule or the default backend
+func (r *routingStorageClient) route(req routingRequest) Driver {
+	for i, rule := range r.rules {
+		if rule.matches(req, r.tenantLabels) {
+			logg.Debug("Request matches routing rule %d, sending it to backend %s", i+1, rule.Backend)
+			return r.backends[rule.Backend]
+		}
+	}
+	return r.backends[r.defaultBackend]
+}
+
+// matches checks whether all conditions of the rule are met by the request
+func (rule *routingRule) matches(req routingRequest, tenantLabels []string) bool {
+	if rule.metricRegexp != nil {
+		names := req.metricNames()
+		if len(names) == 0 || slices.ContainsFunc(names, func(n string) bool { return !rule.metricRegexp.MatchString(n) }) {
+			return false
+		}
+	}
+	if rule.tenantRegexp != nil && !slices.ContainsFunc(req.tenantValues(tenantLabels), rule.tenantRegexp.MatchString) {
+		return false
+	}
+	if rule.minAge > 0 && time.Since(req.start) < rule.minAge {
+		return false
+	}
+	return true
+}
+
+// metricNames returns the metric names referenced by the request. If any selector does not
+// select a fixed metric name, the result is empty since the metrics cannot be determined.
+func (req routingRequest) metricNames() []string {
+	var names []string
+	for _, matchers := range req.selectors {
+		idx := slices.IndexFunc(matchers, func(m *labels.Matcher) bool {
+			return m.Name == labels.MetricName && m.Type == labels.MatchEqual
+		})
+		if idx < 0 {
+			return nil
+		}
+		names = append(names, matchers[idx].Value)
+	}
+	return names
+}
+
+// tenantValues returns the project/domain IDs a request is scoped to, i.e. the values of the tenant labels
+func (req routingRequest) tenantValues(tenantLabels []string) []string {
+	var values []string
+	for _, matchers := range req.selectors {
+		for _, m := range matchers {
+			if !slices.Contains(tenantLabels, m.Name) {
+				continue
+			}
+			switch m.Type {
+			case labels.MatchEqual:
+				values = append(values, m.Value)
+			case labels.MatchRegexp:
+				values = append(values, strings.Split(m.Value, "|")...)
+			default:
+				// negative matchers do not scope a request
+			}
+		}
+	}
+	return values
+}
+
+// newRoutingRequestFromExpression extracts the routing attributes of a query. Invalid parameters are
+// ignored here, so that the request ends up at the default backend which reports the error.
+func newRoutingRequestFromExpression(expression, start string) routingRequest {
+	selectors, err := util.ExtractSelectors(expression)
+	if err != nil {
+		logg.Debug("Cannot route by expression %q: %s", expression, err.Error())
+	}
+	return routingRequest{selectors: selectors, start: parseStartParam(start)}
+}
+
+// newRoutingRequestFromSelectors extracts the routing attributes of a request with match[] parameters
+func newRoutingRequestFromSelectors(match []string, start string) routingRequest {
+	selectors := make([][]*labels.Matcher, 0, len(match))
+	for _, sel := range match {
+		matchers, err := util.ParseSelector(sel)
+		if err != nil {
+			logg.Debug("Cannot route by selector %q: %s", sel, err.Error())
+			return routingRequest{start: parseStartParam(start)}
+		}
+		selectors = append(selectors, matchers)
+	}
+	return routingRequest{selectors: selectors, start: parseStartParam(start)}
+}
+
+// parseStartParam parses the start time of a request, defaulting to the current time
+func parseStartParam(start string) time.Time {
+	t, err := util.ParseTime(start)
+	if err != nil {
+		logg.Debug("Cannot route by start time: %s", err.Error())
+		return time.Now()
+	}
+	return t
+}
@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/h2non/gock"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	recentURL    = "http://prometheus.local"
+	longtermURL  = "http://thanos.local/thanos"
+	longtermHost = "http://thanos.local"
+)
+
+func setupRoutingTest(t *testing.T) Driver {
+	viper.Set("maia.storage_driver", "routing")
+	viper.Set("maia.backends.recent.prometheus_url", recentURL)
+	viper.Set("maia.backends.longterm.prometheus_url", longtermURL)
+	viper.Set("maia.routing.default_backend", "recent")
+	viper.Set("maia.routing.rules", []map[string]any{
+		{"backend": "longterm", "metric": "limes_.*"},
+		{"backend": "longterm", "tenant": "p0000[12]"},
+		{"backend": "longterm", "min_age": "48h"},
+	})
+	t.Cleanup(func() {
+		viper.Set("maia.storage_driver", "prometheus")
+		viper.Set("maia.backends", map[string]any{})
+		viper.Set("maia.routing", map[string]any{})
+	})
+
+	return NewPrometheusDriver("", map[string]string{}, []string{"project_id", "domain_id"})
+}
+
+func TestRoutingQueryRange(t *testing.T) {
+	longAgo := strconv.FormatInt(time.Now().Add(-72*time.Hour).Unix(), 10)
+	recently := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	cases := []struct {
+		name    string
+		query   string
+		start   string
+		backend string
+	}{
+		{"default backend", `sum(up{project_id="p00003"})`, recently, recentURL},
+		{"metric rule", `limes_project_quota{project_id="p00003"}`, recently, longtermHost},
+		{"metric rule requires all metrics to match", `limes_project_quota{project_id="p00003"} / up{project_id="p00003"}`, recently, recentURL},
+		{"tenant rule", `up{project_id=~"p00001|all"}`, recently, longtermHost},
+		{"age rule", `up{project_id="p00003"}`, longAgo, longtermHost},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer gock.Off()
+			rs := setupRoutingTest(t)
+
+			gock.New(tc.backend).Get("/api/v1/query_range").
+				MatchParam("query", ".+").
+				Reply(http.StatusOK).
+				File("fixtures/query.json").
+				AddHeader("Content-Type", JSON)
+
+			_, err := rs.QueryRange(t.Context(), tc.query, tc.start, "", "60s", JSON)
+			assert.NoError(t, err)
+
+			assertDone(t)
+		})
+	}
+}
+
+func TestRoutingSeries(t *testing.T) {
+	defer gock.Off()
+
+	rs := setupRoutingTest(t)
+
+	gock.New(longtermHost).Get("/thanos/api/v1/series").
+		Reply(http.StatusOK).
+		File("fixtures/series.json").
+		AddHeader("Content-Type", JSON)
+
+	_, err := rs.Series(t.Context(), []string{`{__name__="limes_project_usage",project_id="p00003"}`}, "", "", JSON)
+	assert.NoError(t, err)
+
+	assertDone(t)
+}
+
+func TestRoutingInvalidConfig(t *testing.T) {
+	backends := map[string]Driver{"recent": nil}
+
+	_, err := newRoutingStorageClient(backends, nil, "unknown", nil)
+	assert.ErrorContains(t, err, "default backend")
+
+	_, err = newRoutingStorageClient(backends, []routingRule{{Backend: "recent"}}, "recent", nil)
+	assert.ErrorContains(t, err, "no conditions")
+
+	_, err = newRoutingStorageClient(backends, []routingRule{{Backend: "longterm", MinAge: "1h"}}, "recent", nil)
+	assert.ErrorContains(t, err, "unknown backend")
+
+	_, err = newRoutingStorageClient(backends, []routingRule{{Backend: "recent", Metric: "("}}, "recent", nil)
+	assert.ErrorContains(t, err, "invalid metric regex")
+
+	_, err = newRoutingStorageClient(backends, []routingRule{{Backend: "recent", Tenant: "p00001"}}, "recent", nil)
+	assert.ErrorContains(t, err, "no scope labels")
+}
+
+func TestRoutingTenantLabels(t *testing.T) {
+	recent, longterm := &memoryStorageClient{}, &memoryStorageClient{}
+	backends := map[string]Driver{"recent": recent, "longterm": longterm}
+	rs, err := newRoutingStorageClient(backends, []routingRule{{Backend: "longterm", Tenant: "p00001"}}, "recent", []string{"tenant_id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Same(t, longterm, rs.route(newRoutingRequestFromExpression(`up{tenant_id="p00001"}`, "")))
+	// only the configured labels scope a request
+	assert.Same(t, recent, rs.route(newRoutingRequestFromExpression(`up{project_id="p00001"}`, "")))
+}
@@ -0,0 +1,270 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// upstreamConfig contains the authentication and TLS settings for the connection to a Prometheus backend.
+// It is read from the section of the backend, e.g. [maia], [maia.tls] and [maia.basic_auth].
+type upstreamConfig struct {
+	// BearerTokenFile is re-read on every request, so that the token can be rotated
+	BearerTokenFile string
+	BasicAuth       basicAuthConfig
+	OAuth2          oauth2Config
+	TLS             tlsConfig
+	// Headers are sent along with every request
+	Headers map[string]string
+}
+
+type basicAuthConfig struct {
+	Username     string
+	Password     string
+	PasswordFile string
+}
+
+type oauth2Config struct {
+	ClientID         string
+	ClientSecret     string
+	ClientSecretFile string
+	TokenURL         string
+	Scopes           []string
+}
+
+type tlsConfig struct {
+	CAFile string
+	// CertFile and KeyFile are re-read on every TLS handshake, so that the client certificate can be rotated
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// upstreamConfigFromViper reads the connection settings from the given configuration section
+func upstreamConfigFromViper(section string) upstreamConfig {
+	return upstreamConfig{
+		BearerTokenFile: viper.GetString(section + ".bearer_token_file"),
+		BasicAuth: basicAuthConfig{
+			Username:     viper.GetString(section + ".basic_auth.username"),
+			Password:     viper.GetString(section + ".basic_auth.password"),
+			PasswordFile: viper.GetString(section + ".basic_auth.password_file"),
+		},
+		OAuth2: oauth2Config{
+			ClientID:         viper.GetString(section + ".oauth2.client_id"),
+			ClientSecret:     viper.GetString(section + ".oauth2.client_secret"),
+			ClientSecretFile: viper.GetString(section + ".oauth2.client_secret_file"),
+			TokenURL:         viper.GetString(section + ".oauth2.token_url"),
+			Scopes:           viper.GetStringSlice(section + ".oauth2.scopes"),
+		},
+		TLS: tlsConfig{
+			CAFile:             viper.GetString(section + ".tls.ca_file"),
+			CertFile:           viper.GetString(section + ".tls.cert_file"),
+			KeyFile:            viper.GetString(section + ".tls.key_file"),
+			ServerName:         viper.GetString(section + ".tls.server_name"),
+			InsecureSkipVerify: viper.GetBool(section + ".tls.insecure_skip_verify"),
+		},
+		Headers: viper.GetStringMapString(section + ".headers"),
+	}
+}
+
+// federateUpstreamConfigFromViper reads the connection settings for the federate URL of a backend. They are
+// configured in a "federate" subsection (e.g. [maia.federate.tls]) and default to the settings of the backend.
+func federateUpstreamConfigFromViper(section string, backendConfig upstreamConfig) upstreamConfig {
+	if !viper.IsSet(section + ".federate") {
+		return backendConfig
+	}
+	return upstreamConfigFromViper(section + ".federate")
+}
+
+// NewHTTPClientFromConfig creates an HTTP client with the authentication and TLS settings of the given configuration
+// section. It is used for upstreams other than Prometheus, e.g. Alertmanager.
+func NewHTTPClientFromConfig(section string) (*http.Client, error) {
+	return newHTTPClient(upstreamConfigFromViper(section))
+}
+
+// newHTTPClient creates the HTTP client for connections to an upstream with the given settings. The process-wide
+// proxy setting (maia.proxy) applies to all upstreams.
+func newHTTPClient(cfg upstreamConfig) (*http.Client, error) {
+	var transport http.RoundTripper
+	if viper.IsSet("maia.proxy") || cfg.TLS != (tlsConfig{}) {
+		// start from the default transport, so that process-wide settings like MAIA_INSECURE still apply
+		t, ok := http.DefaultTransport.(*http.Transport)
+		if ok {
+			t = t.Clone()
+		} else {
+			t = &http.Transport{Proxy: http.ProxyFromEnvironment}
+		}
+
+		if viper.IsSet("maia.proxy") {
+			proxyURL, err := url.Parse(viper.GetString("maia.proxy"))
+			if err != nil {
+				return nil, fmt.Errorf("could not set proxy: %s .\n%s", proxyURL, err.Error())
+			}
+			t.Proxy = http.ProxyURL(proxyURL)
+		}
+
+		if cfg.TLS != (tlsConfig{}) {
+			tlsConf, err := cfg.TLS.build(t.TLSClientConfig)
+			if err != nil {
+				return nil, err
+			}
+			t.TLSClientConfig = tlsConf
+		}
+		transport = t
+	}
+
+	authMethods := 0
+	for _, configured := range []bool{cfg.BearerTokenFile != "", cfg.BasicAuth.Username != "", cfg.OAuth2.ClientID != ""} {
+		if configured {
+			authMethods++
+		}
+	}
+	if authMethods > 1 {
+		return nil, errors.New("at most one of bearer_token_file, basic_auth and oauth2 may be configured")
+	}
+
+	if cfg.OAuth2.ClientID != "" {
+		rt, err := cfg.OAuth2.roundTripper(transport)
+		if err != nil {
+			return nil, err
+		}
+		transport = rt
+	}
+	if cfg.BearerTokenFile != "" || cfg.BasicAuth.Username != "" || len(cfg.Headers) > 0 {
+		transport = &authRoundTripper{config: cfg, next: transport}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// build returns the TLS client configuration. base is the configuration of the default transport (may be nil).
+func (c tlsConfig) build(base *tls.Config) (*tls.Config, error) {
+	result := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		result = base.Clone()
+	}
+	result.ServerName = c.ServerName
+	if c.InsecureSkipVerify {
+		result.InsecureSkipVerify = true //nolint:gosec // explicitly configured by the operator
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+		result.RootCAs = pool
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("tls.cert_file and tls.key_file must be configured together")
+	}
+	if c.CertFile != "" {
+		// fail early on unusable client certificates
+		if _, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile); err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		result.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load client certificate: %w", err)
+			}
+			return &cert, nil
+		}
+	}
+
+	return result, nil
+}
+
+// roundTripper returns a transport that authenticates requests with a token obtained via the
+// OAuth2 client credentials flow. Tokens are cached until they expire.
+func (c oauth2Config) roundTripper(next http.RoundTripper) (http.RoundTripper, error) {
+	if c.TokenURL == "" {
+		return nil, errors.New("oauth2.token_url must be configured")
+	}
+	secret := c.ClientSecret
+	if c.ClientSecretFile != "" {
+		content, err := readSecretFile(c.ClientSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = content
+	}
+
+	ccConfig := clientcredentials.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: secret,
+		TokenURL:     c.TokenURL,
+		Scopes:       c.Scopes,
+	}
+	// token requests use the same TLS and proxy settings as the requests to Prometheus
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: next})
+	return &oauth2.Transport{Source: ccConfig.TokenSource(ctx), Base: next}, nil
+}
+
+// authRoundTripper adds static headers and bearer token or basic auth credentials to every request
+type authRoundTripper struct {
+	config upstreamConfig
+	next   http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the original request
+	req = req.Clone(req.Context())
+	for k, v := range rt.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	switch {
+	case rt.config.BearerTokenFile != "":
+		token, err := readSecretFile(rt.config.BearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case rt.config.BasicAuth.Username != "":
+		password := rt.config.BasicAuth.Password
+		if rt.config.BasicAuth.PasswordFile != "" {
+			content, err := readSecretFile(rt.config.BasicAuth.PasswordFile)
+			if err != nil {
+				return nil, err
+			}
+			password = content
+		}
+		req.SetBasicAuth(rt.config.BasicAuth.Username, password)
+	}
+
+	next := rt.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req)
+}
+
+// readSecretFile reads a credential from a file, ignoring surrounding whitespace
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read credentials: %w", err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
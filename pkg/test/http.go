@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -24,7 +25,8 @@ type APIRequest struct {
 	Headers          map[string]string
 	Method           string
 	Path             string
-	RequestJSON      any // if non-nil, will be encoded as JSON
+	RequestJSON      any        // if non-nil, will be encoded as JSON
+	RequestForm      url.Values // if non-nil, will be sent form-encoded
 	ExpectStatusCode int
 	ExpectBody       *string // raw content (not a file path)
 	ExpectJSON       string  // path to JSON file
@@ -43,7 +45,13 @@ func (r APIRequest) Check(t *testing.T, handler http.Handler) {
 		}
 		requestBody = bytes.NewReader(body)
 	}
+	if r.RequestForm != nil {
+		requestBody = strings.NewReader(r.RequestForm.Encode())
+	}
 	request := httptest.NewRequest(r.Method, r.Path, requestBody)
+	if r.RequestForm != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
 	for k, v := range r.Headers {
 		request.Header.Set(k, v)
 	}
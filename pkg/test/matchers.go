@@ -5,6 +5,7 @@ package test
 
 import (
 	"context"
+	"time"
 
 	"go.uber.org/mock/gomock"
 )
@@ -25,3 +26,26 @@ func (m ContextMatcher) String() string {
 func MatchContext() gomock.Matcher {
 	return ContextMatcher{}
 }
+
+// ContextDeadlineMatcher is a custom matcher for contexts with a deadline no later than the given timeout
+type ContextDeadlineMatcher struct {
+	Timeout time.Duration
+}
+
+func (m ContextDeadlineMatcher) Matches(x any) bool {
+	ctx, ok := x.(context.Context)
+	if !ok {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) <= m.Timeout
+}
+
+func (m ContextDeadlineMatcher) String() string {
+	return "is a context.Context with a deadline within " + m.Timeout.String()
+}
+
+// MatchContextWithDeadline returns a matcher for a context.Context that expires within the given timeout
+func MatchContextWithDeadline(timeout time.Duration) gomock.Matcher {
+	return ContextDeadlineMatcher{Timeout: timeout}
+}
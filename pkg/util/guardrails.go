@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// QueryLimits are guardrails that protect the storage backend from excessively expensive queries of a tenant.
+// A zero value disables the respective limit.
+type QueryLimits struct {
+	// MaxRange is the maximum duration of range vectors and subqueries, e.g. the 30d of rate(x[30d])
+	MaxRange time.Duration
+	// MinSubqueryStep is the finest allowed subquery resolution, e.g. the 1s of x[30d:1s]
+	MinSubqueryStep time.Duration
+	// DefaultSubqueryStep is the resolution of subqueries without explicit step like x[30d:], i.e. the global
+	// evaluation interval of the backend. It defaults to DefaultEvaluationInterval.
+	DefaultSubqueryStep time.Duration
+	// MaxPoints is the maximum number of steps of a query_range request, i.e. (end-start)/step
+	MaxPoints int64
+	// DeniedFunctions are the names of PromQL functions (or aggregation operators) that must not be used
+	DeniedFunctions []string
+	// DenyNameOnlySelectors rejects selectors like {__name__=~".+"} that have no other matchers than a pattern
+	// for the metric name and thus select a large part of the TSDB
+	DenyNameOnlySelectors bool
+}
+
+// DefaultEvaluationInterval is the default global evaluation interval of Prometheus, which determines the resolution
+// of subqueries without explicit step
+const DefaultEvaluationInterval = time.Minute
+
+// TenantQueryLimits are the QueryLimits of all tenants, with overrides for individual projects and domains.
+type TenantQueryLimits struct {
+	Default  QueryLimits
+	Projects map[string]QueryLimits
+	Domains  map[string]QueryLimits
+}
+
+// For returns the limits of a project (if projectID is not empty) or domain. The limits of a project take precedence
+// over the ones of its domain.
+func (t TenantQueryLimits) For(projectID, domainID string) QueryLimits {
+	if l, ok := t.Projects[projectID]; ok && projectID != "" {
+		return l
+	}
+	if l, ok := t.Domains[domainID]; ok && domainID != "" {
+		return l
+	}
+	return t.Default
+}
+
+// CheckExpression parses a PromQL expression and checks it against the limits. The returned error explains
+// which limit is exceeded.
+func (l QueryLimits) CheckExpression(expression string) error {
+	exprNode, err := promqlParser.ParseExpr(expression)
+	if err != nil {
+		return err
+	}
+	return parser.Walk(queryLimitChecker{limits: l}, exprNode, nil)
+}
+
+// CheckRange checks the number of steps of a range query against MaxPoints.
+func (l QueryLimits) CheckRange(start, end time.Time, step time.Duration) error {
+	if l.MaxPoints <= 0 || step <= 0 || end.Before(start) {
+		return nil
+	}
+	points := int64(end.Sub(start)/step) + 1
+	if points > l.MaxPoints {
+		return fmt.Errorf("query_range with step %s would return %d points per series, more than the maximum of %d: increase the step or shorten the time range",
+			model.Duration(step), points, l.MaxPoints)
+	}
+	return nil
+}
+
+// CheckSelector parses a series selector, e.g. from a match[] parameter, and checks it against the limits.
+func (l QueryLimits) CheckSelector(selector string) error {
+	matchers, err := promqlParser.ParseMetricSelector(selector)
+	if err != nil {
+		return err
+	}
+	if l.DenyNameOnlySelectors && isNameOnlySelector(matchers) {
+		return fmt.Errorf("selector %s must contain a label matcher other than %s or an exact metric name",
+			selector, labels.MetricName)
+	}
+	return nil
+}
+
+// queryLimitChecker is a parser.Visitor that reports the first node of an expression exceeding the limits
+type queryLimitChecker struct {
+	limits QueryLimits
+}
+
+// Visit checks the visited parser.Node against the limits.
+func (c queryLimitChecker) Visit(node parser.Node, path []parser.Node) (parser.Visitor, error) {
+	switch n := node.(type) {
+	case *parser.MatrixSelector:
+		if c.limits.MaxRange > 0 && n.Range > c.limits.MaxRange {
+			return nil, fmt.Errorf("range [%s] of %s exceeds the maximum range of %s",
+				model.Duration(n.Range), n.VectorSelector, model.Duration(c.limits.MaxRange))
+		}
+	case *parser.SubqueryExpr:
+		if c.limits.MaxRange > 0 && n.Range > c.limits.MaxRange {
+			return nil, fmt.Errorf("subquery range [%s] exceeds the maximum range of %s",
+				model.Duration(n.Range), model.Duration(c.limits.MaxRange))
+		}
+		if c.limits.MinSubqueryStep > 0 && n.Step > 0 && n.Step < c.limits.MinSubqueryStep {
+			return nil, fmt.Errorf("subquery resolution of %s is finer than the minimum resolution of %s",
+				model.Duration(n.Step), model.Duration(c.limits.MinSubqueryStep))
+		}
+		// without an explicit step, the subquery is evaluated at the default resolution of the backend
+		if c.limits.MinSubqueryStep > 0 && n.Step == 0 {
+			step := c.limits.DefaultSubqueryStep
+			if step <= 0 {
+				step = DefaultEvaluationInterval
+			}
+			if step < c.limits.MinSubqueryStep {
+				return nil, fmt.Errorf("subquery without resolution is evaluated at the default resolution of %s, which is finer than the minimum resolution of %s: specify a resolution like [%s:%s]",
+					model.Duration(step), model.Duration(c.limits.MinSubqueryStep), model.Duration(n.Range), model.Duration(c.limits.MinSubqueryStep))
+			}
+		}
+	case *parser.Call:
+		if slices.Contains(c.limits.DeniedFunctions, n.Func.Name) {
+			return nil, fmt.Errorf("function %s() is not allowed", n.Func.Name)
+		}
+	case *parser.AggregateExpr:
+		// aggregation operators like count_values look like functions to the user
+		if name := n.Op.String(); slices.Contains(c.limits.DeniedFunctions, name) {
+			return nil, fmt.Errorf("function %s() is not allowed", name)
+		}
+	case *parser.VectorSelector:
+		if c.limits.DenyNameOnlySelectors && isNameOnlySelector(n.LabelMatchers) {
+			return nil, fmt.Errorf("selector %s must contain a label matcher other than %s or an exact metric name",
+				n, labels.MetricName)
+		}
+	}
+	return c, nil
+}
+
+// isNameOnlySelector checks whether a selector only consists of a pattern for the metric name
+func isNameOnlySelector(matchers []*labels.Matcher) bool {
+	if len(matchers) == 0 {
+		return false
+	}
+	for _, m := range matchers {
+		if m.Name != labels.MetricName || m.Type == labels.MatchEqual {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testQueryLimits = QueryLimits{
+	MaxRange:              7 * 24 * time.Hour,
+	MinSubqueryStep:       time.Minute,
+	MaxPoints:             11000,
+	DeniedFunctions:       []string{"count_values"},
+	DenyNameOnlySelectors: true,
+}
+
+func TestQueryLimits_CheckExpression(t *testing.T) {
+	allowed := []string{
+		`sum(rate(http_requests_total{job="api"}[5m]))`,
+		`max_over_time(up[7d])`,
+		`max_over_time(rate(up[5m])[1d:5m])`,
+		`max_over_time(rate(up[5m])[1d:])`,
+		`{__name__="up"}`,
+		`{__name__=~"node_.*",job="node"}`,
+	}
+	for _, expr := range allowed {
+		if err := testQueryLimits.CheckExpression(expr); err != nil {
+			t.Errorf("%s: unexpected error: %s", expr, err.Error())
+		}
+	}
+
+	denied := map[string]string{
+		`count_over_time({__name__=~".+"}[30d:1s])`:  "subquery range [30d] exceeds the maximum range of 1w",
+		`rate(http_requests_total[30d])`:             "range [30d] of http_requests_total exceeds the maximum range of 1w",
+		`max_over_time(rate(up[5m])[1d:1s])`:         "subquery resolution of 1s is finer than the minimum resolution of 1m",
+		`count_values("value", up)`:                  "function count_values() is not allowed",
+		`count({__name__=~".+"})`:                    `selector {__name__=~".+"} must contain a label matcher`,
+		`up + on() group_left {__name__=~"node_.+"}`: `selector {__name__=~"node_.+"} must contain a label matcher`,
+		`sum(rate(http_requests_total{job="api"}`:    "unclosed left parenthesis",
+	}
+	for expr, expected := range denied {
+		err := testQueryLimits.CheckExpression(expr)
+		if err == nil {
+			t.Errorf("%s: expected error, got none", expr)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q, got %q", expr, expected, err.Error())
+		}
+	}
+
+	// without limits, everything is allowed
+	if err := (QueryLimits{}).CheckExpression(`count_over_time({__name__=~".+"}[30d:1s])`); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestQueryLimits_CheckRange(t *testing.T) {
+	start := time.Unix(0, 0)
+	if err := testQueryLimits.CheckRange(start, start.Add(24*time.Hour), 10*time.Second); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+	err := testQueryLimits.CheckRange(start, start.Add(30*24*time.Hour), time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "43201 points per series, more than the maximum of 11000") {
+		t.Errorf("expected error about the number of points, got %v", err)
+	}
+}
+
+func TestQueryLimits_CheckSubqueryDefaultStep(t *testing.T) {
+	// subqueries without step are evaluated at the evaluation interval of the backend
+	limits := QueryLimits{MinSubqueryStep: 5 * time.Minute}
+	err := limits.CheckExpression(`max_over_time(rate(up[5m])[1d:])`)
+	if err == nil || !strings.Contains(err.Error(), "default resolution of 1m, which is finer than the minimum resolution of 5m") {
+		t.Errorf("expected error about the default resolution, got %v", err)
+	}
+	limits.DefaultSubqueryStep = 5 * time.Minute
+	if err := limits.CheckExpression(`max_over_time(rate(up[5m])[1d:])`); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+func TestQueryLimits_CheckSelector(t *testing.T) {
+	for _, sel := range []string{`up`, `{__name__=~"node_.*",job="node"}`, `{job="api"}`} {
+		if err := testQueryLimits.CheckSelector(sel); err != nil {
+			t.Errorf("%s: unexpected error: %s", sel, err.Error())
+		}
+	}
+	denied := map[string]string{
+		`{__name__=~".+"}`:                    `selector {__name__=~".+"} must contain a label matcher`,
+		`{__name__!="up",__name__=~"node.*"}`: "must contain a label matcher",
+		`up{`:                                 "unexpected end of input",
+	}
+	for sel, expected := range denied {
+		err := testQueryLimits.CheckSelector(sel)
+		if err == nil {
+			t.Errorf("%s: expected error, got none", sel)
+		} else if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected error containing %q, got %q", sel, expected, err.Error())
+		}
+	}
+}
+
+func TestTenantQueryLimits_For(t *testing.T) {
+	limits := TenantQueryLimits{
+		Default:  QueryLimits{MaxPoints: 11000},
+		Projects: map[string]QueryLimits{"12345": {MaxPoints: 50000}},
+		Domains:  map[string]QueryLimits{"77777": {MaxPoints: 20000}},
+	}
+	cases := []struct {
+		projectID, domainID string
+		expected            int64
+	}{
+		{"12345", "77777", 50000},
+		{"67890", "77777", 20000},
+		{"", "77777", 20000},
+		{"67890", "", 11000},
+		{"", "", 11000},
+	}
+	for _, c := range cases {
+		if actual := limits.For(c.projectID, c.domainID).MaxPoints; actual != c.expected {
+			t.Errorf("project %q, domain %q: expected max_points %d, got %d", c.projectID, c.domainID, c.expected, actual)
+		}
+	}
+}
@@ -16,6 +16,8 @@ func init() {
 	// with an env variable. (It's very important that this is not the standard
 	// "DEBUG" variable. "DEBUG" is meant to be useful for production systems,
 	// where you definitely don't want to turn off certificate verification.)
+	// For the connections to Prometheus, prefer the tls.* settings of the
+	// storage backend which also allow to configure a CA bundle.
 	if os.Getenv("MAIA_INSECURE") == "1" {
 		tlsConf := &tls.Config{
 			InsecureSkipVerify: true, //nolint:gosec // intentional usage of InsecureSkipVerify
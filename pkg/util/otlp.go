@@ -0,0 +1,299 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/otlptranslator"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+var (
+	otlpMetricNamer = otlptranslator.MetricNamer{WithMetricSuffixes: true}
+	otlpLabelNamer  = otlptranslator.LabelNamer{}
+)
+
+// OTLPToWriteRequest converts OTLP metrics to a remote write request. The metrics are passed as MetricsData, which is
+// encoded like the ExportMetricsServiceRequest of OTLP/HTTP.
+//
+// The conversion follows the OpenTelemetry-to-Prometheus compatibility rules: names are translated to the Prometheus
+// naming scheme with unit and type suffixes, attributes become labels, and the service.name, service.namespace and
+// service.instance.id resource attributes become the job and instance labels. Data points that cannot be represented
+// in the classic Prometheus data model (delta temporality and exponential histograms) are skipped and counted in the
+// second return value.
+func OTLPToWriteRequest(request *metricspb.MetricsData) (result prompb.WriteRequest, dropped int64) {
+	for _, rm := range request.GetResourceMetrics() {
+		resourceLabels := otlpResourceLabels(rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				dropped += convertOTLPMetric(&result, m, resourceLabels)
+			}
+		}
+	}
+	return result, dropped
+}
+
+// otlpResourceLabels derives the job and instance labels from the resource attributes
+func otlpResourceLabels(attributes []*commonpb.KeyValue) []prompb.Label {
+	var serviceName, serviceNamespace, instance string
+	for _, kv := range attributes {
+		switch kv.GetKey() {
+		case "service.name":
+			serviceName = otlpValueString(kv.GetValue())
+		case "service.namespace":
+			serviceNamespace = otlpValueString(kv.GetValue())
+		case "service.instance.id":
+			instance = otlpValueString(kv.GetValue())
+		}
+	}
+
+	var result []prompb.Label
+	if serviceName != "" {
+		job := serviceName
+		if serviceNamespace != "" {
+			job = serviceNamespace + "/" + serviceName
+		}
+		result = append(result, prompb.Label{Name: "job", Value: job})
+	}
+	if instance != "" {
+		result = append(result, prompb.Label{Name: "instance", Value: instance})
+	}
+	return result
+}
+
+// convertOTLPMetric appends the series and metadata of a metric to the request and returns the number of data
+// points that were skipped
+func convertOTLPMetric(result *prompb.WriteRequest, m *metricspb.Metric, resourceLabels []prompb.Label) int64 {
+	metric := otlptranslator.Metric{Name: m.GetName(), Unit: m.GetUnit()}
+	var metadataType prompb.MetricMetadata_MetricType
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		metric.Type = otlptranslator.MetricTypeGauge
+		metadataType = prompb.MetricMetadata_GAUGE
+	case *metricspb.Metric_Sum:
+		if data.Sum.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return int64(len(data.Sum.GetDataPoints()))
+		}
+		metric.Type = otlptranslator.MetricTypeGauge
+		metadataType = prompb.MetricMetadata_GAUGE
+		if data.Sum.GetIsMonotonic() {
+			metric.Type = otlptranslator.MetricTypeMonotonicCounter
+			metadataType = prompb.MetricMetadata_COUNTER
+		}
+	case *metricspb.Metric_Histogram:
+		if data.Histogram.GetAggregationTemporality() != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+			return int64(len(data.Histogram.GetDataPoints()))
+		}
+		metric.Type = otlptranslator.MetricTypeHistogram
+		metadataType = prompb.MetricMetadata_HISTOGRAM
+	case *metricspb.Metric_Summary:
+		metric.Type = otlptranslator.MetricTypeSummary
+		metadataType = prompb.MetricMetadata_SUMMARY
+	case *metricspb.Metric_ExponentialHistogram:
+		return int64(len(data.ExponentialHistogram.GetDataPoints()))
+	default:
+		return 0
+	}
+
+	name, err := otlpMetricNamer.Build(metric)
+	if err != nil {
+		return otlpDataPointCount(m)
+	}
+	result.Metadata = append(result.Metadata, prompb.MetricMetadata{
+		Type: metadataType, MetricFamilyName: name, Help: m.GetDescription(), Unit: m.GetUnit(),
+	})
+
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		appendOTLPNumberDataPoints(result, name, data.Gauge.GetDataPoints(), resourceLabels)
+	case *metricspb.Metric_Sum:
+		appendOTLPNumberDataPoints(result, name, data.Sum.GetDataPoints(), resourceLabels)
+	case *metricspb.Metric_Histogram:
+		for _, dp := range data.Histogram.GetDataPoints() {
+			lbls := otlpLabels(dp.GetAttributes(), resourceLabels)
+			ts := otlpTimestamp(dp.GetTimeUnixNano())
+			stale := otlpNoRecordedValue(dp.GetFlags())
+			var cumulative uint64
+			for i, count := range dp.GetBucketCounts() {
+				cumulative += count
+				le := math.Inf(1)
+				if i < len(dp.GetExplicitBounds()) {
+					le = dp.GetExplicitBounds()[i]
+				}
+				appendOTLPSample(result, name+"_bucket", withLabel(lbls, model.BucketLabel, formatOTLPFloat(le)), float64(cumulative), ts, stale)
+			}
+			// the +Inf bucket is implied when the bucket counts are left out
+			if len(dp.GetBucketCounts()) <= len(dp.GetExplicitBounds()) {
+				appendOTLPSample(result, name+"_bucket", withLabel(lbls, model.BucketLabel, "+Inf"), float64(dp.GetCount()), ts, stale)
+			}
+			if dp.Sum != nil {
+				appendOTLPSample(result, name+"_sum", lbls, dp.GetSum(), ts, stale)
+			}
+			appendOTLPSample(result, name+"_count", lbls, float64(dp.GetCount()), ts, stale)
+		}
+	case *metricspb.Metric_Summary:
+		for _, dp := range data.Summary.GetDataPoints() {
+			lbls := otlpLabels(dp.GetAttributes(), resourceLabels)
+			ts := otlpTimestamp(dp.GetTimeUnixNano())
+			stale := otlpNoRecordedValue(dp.GetFlags())
+			for _, q := range dp.GetQuantileValues() {
+				appendOTLPSample(result, name, withLabel(lbls, model.QuantileLabel, formatOTLPFloat(q.GetQuantile())), q.GetValue(), ts, stale)
+			}
+			appendOTLPSample(result, name+"_sum", lbls, dp.GetSum(), ts, stale)
+			appendOTLPSample(result, name+"_count", lbls, float64(dp.GetCount()), ts, stale)
+		}
+	}
+	return 0
+}
+
+func appendOTLPNumberDataPoints(result *prompb.WriteRequest, name string, dataPoints []*metricspb.NumberDataPoint, resourceLabels []prompb.Label) {
+	for _, dp := range dataPoints {
+		var v float64
+		switch value := dp.GetValue().(type) {
+		case *metricspb.NumberDataPoint_AsDouble:
+			v = value.AsDouble
+		case *metricspb.NumberDataPoint_AsInt:
+			v = float64(value.AsInt)
+		}
+		appendOTLPSample(result, name, otlpLabels(dp.GetAttributes(), resourceLabels), v, otlpTimestamp(dp.GetTimeUnixNano()),
+			otlpNoRecordedValue(dp.GetFlags()))
+	}
+}
+
+// appendOTLPSample adds a series with a single sample. Data points without a recorded value become stale markers.
+func appendOTLPSample(result *prompb.WriteRequest, name string, lbls []prompb.Label, v float64, timestamp int64, stale bool) {
+	if stale {
+		v = math.Float64frombits(value.StaleNaN)
+	}
+	lbls = withLabel(lbls, model.MetricNameLabel, name)
+	result.Timeseries = append(result.Timeseries, prompb.TimeSeries{
+		Labels:  lbls,
+		Samples: []prompb.Sample{{Value: v, Timestamp: timestamp}},
+	})
+}
+
+// otlpLabels translates the attributes of a data point to labels. Attributes whose names collide after the
+// translation are joined with ";". Attributes take precedence over the labels derived from the resource.
+func otlpLabels(attributes []*commonpb.KeyValue, resourceLabels []prompb.Label) []prompb.Label {
+	values := make(map[string]string, len(attributes)+len(resourceLabels))
+	for _, kv := range attributes {
+		name, err := otlpLabelNamer.Build(kv.GetKey())
+		if err != nil {
+			continue
+		}
+		v := otlpValueString(kv.GetValue())
+		if existing, ok := values[name]; ok {
+			v = existing + ";" + v
+		}
+		values[name] = v
+	}
+	for _, l := range resourceLabels {
+		if _, ok := values[l.Name]; !ok {
+			values[l.Name] = l.Value
+		}
+	}
+
+	result := make([]prompb.Label, 0, len(values)+2)
+	for name, v := range values {
+		result = append(result, prompb.Label{Name: name, Value: v})
+	}
+	slices.SortFunc(result, func(a, b prompb.Label) int { return strings.Compare(a.Name, b.Name) })
+	return result
+}
+
+// withLabel returns a sorted copy of the labels with an additional label
+func withLabel(lbls []prompb.Label, name, v string) []prompb.Label {
+	result := make([]prompb.Label, 0, len(lbls)+1)
+	result = append(result, lbls...)
+	result = append(result, prompb.Label{Name: name, Value: v})
+	slices.SortFunc(result, func(a, b prompb.Label) int { return strings.Compare(a.Name, b.Name) })
+	return result
+}
+
+// otlpValueString renders an attribute value as label value
+func otlpValueString(v *commonpb.AnyValue) string {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(value.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(value.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return formatOTLPFloat(value.DoubleValue)
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(value.BytesValue)
+	case *commonpb.AnyValue_ArrayValue, *commonpb.AnyValue_KvlistValue:
+		encoded, err := json.Marshal(otlpValueJSON(v))
+		if err != nil {
+			return ""
+		}
+		return string(encoded)
+	}
+	return ""
+}
+
+// otlpValueJSON converts array and key-value list attributes to JSON values
+func otlpValueJSON(v *commonpb.AnyValue) any {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_ArrayValue:
+		result := []any{}
+		for _, item := range value.ArrayValue.GetValues() {
+			result = append(result, otlpValueJSON(item))
+		}
+		return result
+	case *commonpb.AnyValue_KvlistValue:
+		result := map[string]any{}
+		for _, kv := range value.KvlistValue.GetValues() {
+			result[kv.GetKey()] = otlpValueJSON(kv.GetValue())
+		}
+		return result
+	case *commonpb.AnyValue_BoolValue:
+		return value.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return value.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return value.DoubleValue
+	}
+	return otlpValueString(v)
+}
+
+func otlpDataPointCount(m *metricspb.Metric) int64 {
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		return int64(len(data.Gauge.GetDataPoints()))
+	case *metricspb.Metric_Sum:
+		return int64(len(data.Sum.GetDataPoints()))
+	case *metricspb.Metric_Histogram:
+		return int64(len(data.Histogram.GetDataPoints()))
+	case *metricspb.Metric_Summary:
+		return int64(len(data.Summary.GetDataPoints()))
+	case *metricspb.Metric_ExponentialHistogram:
+		return int64(len(data.ExponentialHistogram.GetDataPoints()))
+	}
+	return 0
+}
+
+func otlpTimestamp(unixNano uint64) int64 {
+	return int64(unixNano / 1e6) //nolint:gosec // OTLP timestamps are far below the int64 limit in milliseconds
+}
+
+func otlpNoRecordedValue(flags uint32) bool {
+	return flags&uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK) != 0
+}
+
+// formatOTLPFloat renders bucket boundaries and quantiles like the Prometheus client libraries
+func formatOTLPFloat(f float64) string {
+	return model.SampleValue(f).String()
+}
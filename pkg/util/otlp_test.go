@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// testTimeUnixNano is 2017-07-14T02:40:00Z
+const testTimeUnixNano = 1500000000 * 1e9
+
+func stringAttribute(key, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}}
+}
+
+func testMetricsData(metrics ...*metricspb.Metric) *metricspb.MetricsData {
+	return &metricspb.MetricsData{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			stringAttribute("service.name", "checkout"),
+			stringAttribute("service.namespace", "shop"),
+			stringAttribute("service.instance.id", "host-1:8080"),
+			// other resource attributes are not converted
+			stringAttribute("host.arch", "amd64"),
+		}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+func testSeries(v float64, lbls ...string) prompb.TimeSeries {
+	ts := prompb.TimeSeries{Samples: []prompb.Sample{{Value: v, Timestamp: 1500000000000}}}
+	for i := 0; i < len(lbls); i += 2 {
+		ts.Labels = append(ts.Labels, prompb.Label{Name: lbls[i], Value: lbls[i+1]})
+	}
+	return ts
+}
+
+func TestOTLPToWriteRequest_sumAndGauge(t *testing.T) {
+	request := testMetricsData(
+		&metricspb.Metric{Name: "http.server.requests", Unit: "{request}", Description: "Number of requests.", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+			DataPoints: []*metricspb.NumberDataPoint{{
+				// attributes whose names collide after the translation are joined
+				Attributes:   []*commonpb.KeyValue{stringAttribute("http.method", "GET"), stringAttribute("http_method", "get")},
+				TimeUnixNano: testTimeUnixNano,
+				Value:        &metricspb.NumberDataPoint_AsInt{AsInt: 42},
+			}},
+		}}},
+		&metricspb.Metric{Name: "process.memory.usage", Unit: "By", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{
+				// attributes take precedence over the labels of the resource
+				Attributes:   []*commonpb.KeyValue{stringAttribute("job", "batch")},
+				TimeUnixNano: testTimeUnixNano,
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 1024},
+			}},
+		}}},
+	)
+
+	result, dropped := OTLPToWriteRequest(request)
+	if dropped != 0 {
+		t.Errorf("expected no dropped data points, got %d", dropped)
+	}
+	expected := []prompb.TimeSeries{
+		testSeries(42, "__name__", "http_server_requests_total", "http_method", "GET;get", "instance", "host-1:8080", "job", "shop/checkout"),
+		testSeries(1024, "__name__", "process_memory_usage_bytes", "instance", "host-1:8080", "job", "batch"),
+	}
+	if !reflect.DeepEqual(expected, result.Timeseries) {
+		t.Errorf("expected series %v, got %v", expected, result.Timeseries)
+	}
+	expectedMetadata := []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "http_server_requests_total", Help: "Number of requests.", Unit: "{request}"},
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "process_memory_usage_bytes", Unit: "By"},
+	}
+	if !reflect.DeepEqual(expectedMetadata, result.Metadata) {
+		t.Errorf("expected metadata %v, got %v", expectedMetadata, result.Metadata)
+	}
+}
+
+func TestOTLPToWriteRequest_histogramAndSummary(t *testing.T) {
+	sum := 2.5
+	request := testMetricsData(
+		&metricspb.Metric{Name: "http.server.duration", Unit: "s", Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			DataPoints: []*metricspb.HistogramDataPoint{{
+				TimeUnixNano:   testTimeUnixNano,
+				Count:          6,
+				Sum:            &sum,
+				ExplicitBounds: []float64{0.1, 1},
+				BucketCounts:   []uint64{2, 3, 1},
+			}},
+		}}},
+		&metricspb.Metric{Name: "rpc.latency", Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{
+			DataPoints: []*metricspb.SummaryDataPoint{{
+				TimeUnixNano:   testTimeUnixNano,
+				Count:          4,
+				Sum:            10,
+				QuantileValues: []*metricspb.SummaryDataPoint_ValueAtQuantile{{Quantile: 0.5, Value: 2}},
+			}},
+		}}},
+	)
+
+	result, dropped := OTLPToWriteRequest(request)
+	if dropped != 0 {
+		t.Errorf("expected no dropped data points, got %d", dropped)
+	}
+	common := []string{"instance", "host-1:8080", "job", "shop/checkout"}
+	expected := []prompb.TimeSeries{
+		// buckets are cumulative in Prometheus
+		testSeries(2, append([]string{"__name__", "http_server_duration_seconds_bucket"}, append(common, "le", "0.1")...)...),
+		testSeries(5, append([]string{"__name__", "http_server_duration_seconds_bucket"}, append(common, "le", "1")...)...),
+		testSeries(6, append([]string{"__name__", "http_server_duration_seconds_bucket"}, append(common, "le", "+Inf")...)...),
+		testSeries(2.5, append([]string{"__name__", "http_server_duration_seconds_sum"}, common...)...),
+		testSeries(6, append([]string{"__name__", "http_server_duration_seconds_count"}, common...)...),
+		testSeries(2, append([]string{"__name__", "rpc_latency"}, append(common, "quantile", "0.5")...)...),
+		testSeries(10, append([]string{"__name__", "rpc_latency_sum"}, common...)...),
+		testSeries(4, append([]string{"__name__", "rpc_latency_count"}, common...)...),
+	}
+	if !reflect.DeepEqual(expected, result.Timeseries) {
+		t.Errorf("expected series %v, got %v", expected, result.Timeseries)
+	}
+}
+
+func TestOTLPToWriteRequest_dropped(t *testing.T) {
+	request := testMetricsData(
+		&metricspb.Metric{Name: "requests", Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+			IsMonotonic:            true,
+			DataPoints:             []*metricspb.NumberDataPoint{{TimeUnixNano: testTimeUnixNano}, {TimeUnixNano: testTimeUnixNano}},
+		}}},
+		&metricspb.Metric{Name: "latency", Data: &metricspb.Metric_ExponentialHistogram{ExponentialHistogram: &metricspb.ExponentialHistogram{
+			DataPoints: []*metricspb.ExponentialHistogramDataPoint{{TimeUnixNano: testTimeUnixNano}},
+		}}},
+		// data points without recorded value become stale markers
+		&metricspb.Metric{Name: "queue.size", Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+			DataPoints: []*metricspb.NumberDataPoint{{
+				TimeUnixNano: testTimeUnixNano,
+				Flags:        uint32(metricspb.DataPointFlags_DATA_POINT_FLAGS_NO_RECORDED_VALUE_MASK),
+			}},
+		}}},
+	)
+
+	result, dropped := OTLPToWriteRequest(request)
+	if dropped != 3 {
+		t.Errorf("expected 3 dropped data points, got %d", dropped)
+	}
+	if len(result.Timeseries) != 1 {
+		t.Fatalf("expected a single series, got %v", result.Timeseries)
+	}
+	if v := result.Timeseries[0].Samples[0].Value; !value.IsStaleNaN(v) {
+		t.Errorf("expected stale marker, got %v (%x)", v, math.Float64bits(v))
+	}
+}
@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// ParseTime parses a timestamp in one of the formats accepted by the Prometheus API
+// (Unix timestamp or RFC3339). An empty string denotes the current time.
+func ParseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Now(), nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("cannot parse " + strconv.Quote(s) + " to a valid timestamp")
+}
+
+// ParseDuration parses a duration in one of the formats accepted by the Prometheus API
+// (number of seconds or a duration like "5m")
+func ParseDuration(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	d, err := model.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New("cannot parse " + strconv.Quote(s) + " to a valid duration")
+	}
+	return time.Duration(d), nil
+}
@@ -4,6 +4,8 @@
 package util
 
 import (
+	"errors"
+	"fmt"
 	"slices"
 	"strings"
 
@@ -53,12 +55,7 @@ func AddLabelConstraintToSelector(metricSelector, key string, values []string) (
 	}
 
 	// Parse the metric selector to obtain existing label matchers
-	var labelMatchers []*labels.Matcher
-	if metricSelector != "{}" {
-		labelMatchers, err = promqlParser.ParseMetricSelector(metricSelector)
-	} else {
-		labelMatchers = make([]*labels.Matcher, 0)
-	}
+	labelMatchers, err := ParseSelector(metricSelector)
 	if err != nil {
 		return "", err
 	}
@@ -72,6 +69,135 @@ func AddLabelConstraintToSelector(metricSelector, key string, values []string) (
 	return "{" + strings.Join(l, ",") + "}", nil
 }
 
+// LabelConstraint limits series to the ones carrying one of the given values in the label Key.
+type LabelConstraint struct {
+	Key    string
+	Values []string
+}
+
+// String implements the fmt.Stringer interface.
+func (c LabelConstraint) String() string {
+	return fmt.Sprintf("%s=%v", c.Key, c.Values)
+}
+
+// AddLabelConstraintsToExpression enhances a PromQL expression to limit it to series matching any of the given label
+// constraints. With a single constraint, this is the same as AddLabelConstraintToExpression. Since the label matchers
+// of a selector cannot be OR-combined in PromQL, every vector selector is expanded into a union ("or") of selectors,
+// one per constraint. Range functions are computed per series, so calls of them are expanded into a union of calls
+// instead. A range selector on the top level of the expression cannot be expanded and is rejected (see
+// AddLabelConstraintsToQueries).
+//
+// Constraints with more than maxSetMatches values are split up like several constraints (see shardLabelConstraints),
+// except for a range selector on the top level, which is restricted by a single matcher per constraint instead.
+func AddLabelConstraintsToExpression(expression string, constraints []LabelConstraint) (string, error) {
+	queries, err := AddLabelConstraintsToQueries(expression, constraints)
+	if err != nil {
+		return "", err
+	}
+	if len(queries) > 1 {
+		return "", errors.New("range selectors cannot be restricted to several scope labels, use a subquery instead")
+	}
+	return queries[0], nil
+}
+
+// AddLabelConstraintsToQueries is like AddLabelConstraintsToExpression, but accepts a range selector on the top level
+// with several constraints. Since range vectors cannot be combined with "or", one expression per constraint is
+// returned for it, e.g. x{project_id="a"}[5m] and x{tenant_id="a"}[5m]. The caller has to merge their results.
+// Otherwise, the result is the single expression of AddLabelConstraintsToExpression.
+func AddLabelConstraintsToQueries(expression string, constraints []LabelConstraint) ([]string, error) {
+	exprNode, err := promqlParser.ParseExpr(expression)
+	if err != nil {
+		return nil, err
+	}
+	if _, isRangeSelector := unwrapRangeSelector(exprNode); isRangeSelector {
+		result := make([]string, len(constraints))
+		for i, c := range constraints {
+			result[i], err = AddLabelConstraintToExpression(expression, c.Key, c.Values)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	constraints = shardLabelConstraints(constraints)
+	if len(constraints) == 1 {
+		result, err := AddLabelConstraintToExpression(expression, constraints[0].Key, constraints[0].Values)
+		return []string{result}, err
+	}
+
+	matchers, err := makeLabelMatchers(constraints)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{labelUnion{matchers: matchers}.expand(exprNode).String()}, nil
+}
+
+// unwrapRangeSelector returns the range selector if the expression is one, possibly in parentheses
+func unwrapRangeSelector(node parser.Expr) (*parser.MatrixSelector, bool) {
+	for {
+		switch n := node.(type) {
+		case *parser.MatrixSelector:
+			return n, true
+		case *parser.ParenExpr:
+			node = n.Expr
+		default:
+			return nil, false
+		}
+	}
+}
+
+// AddLabelConstraintsToSelector adds label constraints to a metric selector. Since all matchers of a selector must
+// match, the result contains one selector per constraint, which are OR-combined by the match[] parameter of the
+// Prometheus API. Constraints with many values are split up like in AddLabelConstraintsToExpression.
+func AddLabelConstraintsToSelector(metricSelector string, constraints []LabelConstraint) ([]string, error) {
+	constraints = shardLabelConstraints(constraints)
+	result := make([]string, len(constraints))
+	for i, c := range constraints {
+		var err error
+		result[i], err = AddLabelConstraintToSelector(metricSelector, c.Key, c.Values)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// maxSetMatches is the number of values up to which Prometheus looks up the series of a matcher like
+// project_id=~"a|b|c" directly in its index. With more values, Prometheus matches every value of the label in the
+// index against the regex, which is slow for labels with many values like project_id.
+const maxSetMatches = 256
+
+// shardLabelConstraints splits up every constraint with more than maxSetMatches values into several constraints for
+// the same label, which select the same series together. This way, large project trees are selected by a union of
+// matchers that Prometheus can look up in its index instead of a single huge regex.
+func shardLabelConstraints(constraints []LabelConstraint) []LabelConstraint {
+	if !slices.ContainsFunc(constraints, func(c LabelConstraint) bool { return len(c.Values) > maxSetMatches }) {
+		return constraints
+	}
+	var result []LabelConstraint
+	for _, c := range constraints {
+		for values := range slices.Chunk(c.Values, maxSetMatches) {
+			result = append(result, LabelConstraint{Key: c.Key, Values: values})
+		}
+	}
+	return result
+}
+
+// makeLabelMatchers creates the labels.Matcher for each constraint
+func makeLabelMatchers(constraints []LabelConstraint) ([]*labels.Matcher, error) {
+	matchers := make([]*labels.Matcher, len(constraints))
+	for i, c := range constraints {
+		var err error
+		matchers[i], err = makeLabelMatcher(c.Key, c.Values)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matchers, nil
+}
+
 // makeLabelMatcher creates a new labels.Matcher based on the provided key and values
 func makeLabelMatcher(key string, values []string) (*labels.Matcher, error) {
 	if len(values) == 1 {
@@ -99,3 +225,236 @@ func (v labelInjector) Visit(node parser.Node, path []parser.Node) (parser.Visit
 	}
 	return v, nil
 }
+
+// labelUnion rewrites a PromQL syntax tree so that every vector selector is replaced by the union of copies of it,
+// each restricted by one of the matchers. Unlike labelInjector, it cannot modify the tree in place, since the
+// replacement of a node has a different type.
+type labelUnion struct {
+	matchers []*labels.Matcher
+}
+
+// expand returns the expression with all selectors in it expanded
+func (u labelUnion) expand(node parser.Expr) parser.Expr {
+	switch n := node.(type) {
+	case *parser.VectorSelector:
+		alternatives := make([]parser.Expr, len(u.matchers))
+		for i, m := range u.matchers {
+			alternatives[i] = restrictSelector(n, m)
+		}
+		return union(alternatives, parser.LOR, nil)
+	case *parser.ParenExpr:
+		n.Expr = u.expand(n.Expr)
+	case *parser.UnaryExpr:
+		n.Expr = u.expand(n.Expr)
+	case *parser.StepInvariantExpr:
+		n.Expr = u.expand(n.Expr)
+	case *parser.SubqueryExpr:
+		n.Expr = u.expand(n.Expr)
+	case *parser.AggregateExpr:
+		n.Expr = u.expand(n.Expr)
+		if n.Param != nil {
+			n.Param = u.expand(n.Param)
+		}
+	case *parser.BinaryExpr:
+		n.LHS = u.expand(n.LHS)
+		n.RHS = u.expand(n.RHS)
+	case *parser.Call:
+		return u.expandCall(n)
+	}
+	return node
+}
+
+// expandCall expands the arguments of a function call. Calls with a range selector as argument are replaced by the
+// union of calls for each matcher.
+func (u labelUnion) expandCall(n *parser.Call) parser.Expr {
+	rangeArg := -1
+	var ms *parser.MatrixSelector
+	for i, arg := range n.Args {
+		if sel, ok := unwrapRangeSelector(arg); ok {
+			rangeArg, ms = i, sel
+		} else {
+			n.Args[i] = u.expand(arg)
+		}
+	}
+	if rangeArg < 0 {
+		return n
+	}
+
+	alternatives := make([]parser.Expr, len(u.matchers))
+	for i, m := range u.matchers {
+		call := *n
+		call.Args = slices.Clone(n.Args)
+		call.Args[rangeArg] = &parser.MatrixSelector{
+			VectorSelector: restrictSelector(ms.VectorSelector.(*parser.VectorSelector), m), //nolint:errcheck // guaranteed by the parser
+			Range:          ms.Range,
+			EndPos:         ms.EndPos,
+		}
+		alternatives[i] = &call
+	}
+	if n.Func.Name == "absent_over_time" {
+		// the union is absent only if all of its parts are absent
+		return union(alternatives, parser.LAND, []string{})
+	}
+	return union(alternatives, parser.LOR, nil)
+}
+
+// restrictSelector returns a copy of the vector selector with the additional matcher, unless it is already present
+func restrictSelector(vs *parser.VectorSelector, matcher *labels.Matcher) *parser.VectorSelector {
+	result := *vs
+	result.LabelMatchers = slices.Clone(vs.LabelMatchers)
+	if !slices.ContainsFunc(result.LabelMatchers, func(e *labels.Matcher) bool {
+		return e.Type == matcher.Type && e.Name == matcher.Name && e.Value == matcher.Value
+	}) {
+		result.LabelMatchers = append(result.LabelMatchers, matcher)
+	}
+	return &result
+}
+
+// union combines the expressions with a set operator, matching on the given labels (or on all labels if on is nil)
+func union(exprs []parser.Expr, op parser.ItemType, on []string) parser.Expr {
+	result := exprs[0]
+	for _, e := range exprs[1:] {
+		result = &parser.BinaryExpr{
+			Op:             op,
+			LHS:            result,
+			RHS:            e,
+			VectorMatching: &parser.VectorMatching{Card: parser.CardManyToMany, On: on != nil, MatchingLabels: on},
+		}
+	}
+	return &parser.ParenExpr{Expr: result}
+}
+
+// ExtractSelectors parses a PromQL expression and returns the label matchers of every vector selector it contains.
+func ExtractSelectors(expression string) ([][]*labels.Matcher, error) {
+	exprNode, err := promqlParser.ParseExpr(expression)
+	if err != nil {
+		return nil, err
+	}
+	return parser.ExtractSelectors(exprNode), nil
+}
+
+// DependsOnQueryRange checks whether the result of a PromQL expression at a given timestamp depends on the start or
+// end of the query range, i.e. whether it uses the @ start() or @ end() modifiers.
+func DependsOnQueryRange(expression string) (bool, error) {
+	exprNode, err := promqlParser.ParseExpr(expression)
+	if err != nil {
+		return false, err
+	}
+
+	result := false
+	parser.Inspect(exprNode, func(node parser.Node, _ []parser.Node) error {
+		switch n := node.(type) {
+		case *parser.VectorSelector:
+			result = result || n.StartOrEnd != 0
+		case *parser.SubqueryExpr:
+			result = result || n.StartOrEnd != 0
+		}
+		return nil
+	})
+	return result, nil
+}
+
+// PreservesLabel checks whether the series returned by a PromQL expression keep the given label of the selected
+// series, i.e. whether it is neither aggregated away nor dropped or overwritten by a function or binary operation.
+// Expressions that do not return series (scalars and strings) do not preserve any label.
+func PreservesLabel(expression, name string) (bool, error) {
+	exprNode, err := promqlParser.ParseExpr(expression)
+	if err != nil {
+		return false, err
+	}
+	return preservesLabel(exprNode, name), nil
+}
+
+func preservesLabel(node parser.Expr, name string) bool {
+	switch n := node.(type) {
+	case *parser.VectorSelector, *parser.MatrixSelector:
+		return true
+	case *parser.ParenExpr:
+		return preservesLabel(n.Expr, name)
+	case *parser.UnaryExpr:
+		return preservesLabel(n.Expr, name)
+	case *parser.StepInvariantExpr:
+		return preservesLabel(n.Expr, name)
+	case *parser.SubqueryExpr:
+		return preservesLabel(n.Expr, name)
+	case *parser.AggregateExpr:
+		switch n.Op {
+		case parser.TOPK, parser.BOTTOMK, parser.LIMITK, parser.LIMIT_RATIO:
+			// these return a subset of the input series
+			return preservesLabel(n.Expr, name)
+		case parser.COUNT_VALUES:
+			if lit, ok := n.Param.(*parser.StringLiteral); !ok || lit.Val == name {
+				return false
+			}
+		}
+		if n.Without {
+			return !slices.Contains(n.Grouping, name) && preservesLabel(n.Expr, name)
+		}
+		return slices.Contains(n.Grouping, name) && preservesLabel(n.Expr, name)
+	case *parser.Call:
+		switch n.Func.Name {
+		case "absent", "absent_over_time":
+			// the labels of the result are derived from equality matchers
+			return false
+		case "label_replace", "label_join":
+			if lit, ok := n.Args[1].(*parser.StringLiteral); !ok || lit.Val == name {
+				return false
+			}
+		}
+		if n.Func.ReturnType != parser.ValueTypeVector {
+			return false
+		}
+		for _, arg := range n.Args {
+			if t := arg.Type(); (t == parser.ValueTypeVector || t == parser.ValueTypeMatrix) && preservesLabel(arg, name) {
+				return true
+			}
+		}
+		return false
+	case *parser.BinaryExpr:
+		lhs := n.LHS.Type() == parser.ValueTypeVector
+		rhs := n.RHS.Type() == parser.ValueTypeVector
+		switch {
+		case lhs && rhs:
+			return binaryPreservesLabel(n, name)
+		case lhs:
+			return preservesLabel(n.LHS, name)
+		case rhs:
+			return preservesLabel(n.RHS, name)
+		}
+	}
+	return false
+}
+
+// binaryPreservesLabel implements preservesLabel for binary operations between two vectors
+func binaryPreservesLabel(n *parser.BinaryExpr, name string) bool {
+	vm := n.VectorMatching
+	switch {
+	case n.Op == parser.LOR:
+		// series from both sides
+		return preservesLabel(n.LHS, name) && preservesLabel(n.RHS, name)
+	case n.Op.IsSetOperator() || vm == nil:
+		// "and" and "unless" return series of the left-hand side
+		return preservesLabel(n.LHS, name)
+	case vm.Card == parser.CardOneToOne:
+		// the result only has the matching labels with on(), and not the matching labels with ignoring()
+		if vm.On != slices.Contains(vm.MatchingLabels, name) {
+			return false
+		}
+		return preservesLabel(n.LHS, name)
+	case slices.Contains(vm.Include, name):
+		// group_left(name) or group_right(name) copies the label from the "one" side
+		return preservesLabel(n.LHS, name) && preservesLabel(n.RHS, name)
+	case vm.Card == parser.CardOneToMany:
+		return preservesLabel(n.RHS, name)
+	default:
+		return preservesLabel(n.LHS, name)
+	}
+}
+
+// ParseSelector parses a series selector like the ones passed in the match[] parameter of the Prometheus API.
+func ParseSelector(metricSelector string) ([]*labels.Matcher, error) {
+	if metricSelector == "{}" {
+		return []*labels.Matcher{}, nil
+	}
+	return promqlParser.ParseMetricSelector(metricSelector)
+}
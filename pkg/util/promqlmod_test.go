@@ -5,6 +5,8 @@ package util
 
 import (
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -70,3 +72,182 @@ func TestAddLabelConstraintToExpression_LargeValues(t *testing.T) {
 		t.Errorf("Error modifying expression with large values: %v", err)
 	}
 }
+
+func TestExtractSelectors(t *testing.T) {
+	selectors, err := ExtractSelectors("sum(rate(http_request_total{job=\"myjob\"}[5m])) / on(job) up")
+	if err != nil {
+		t.Fatalf("Error extracting selectors: %v", err)
+	}
+	if len(selectors) != 2 {
+		t.Fatalf("Expected 2 selectors, but got %d", len(selectors))
+	}
+	if selectors[0][0].String() != "job=\"myjob\"" || selectors[0][1].String() != "__name__=\"http_request_total\"" {
+		t.Errorf("Unexpected matchers for first selector: %v", selectors[0])
+	}
+}
+
+func TestDependsOnQueryRange(t *testing.T) {
+	cases := map[string]bool{
+		"sum(rate(http_request_total[5m]))":             false,
+		"http_request_total @ 1609746000":               false,
+		"http_request_total @ start()":                  true,
+		"rate(http_request_total[5m] @ end())":          true,
+		"max_over_time(up[1h:5m] @ start())":            true,
+		"up / on(job) group_left http_requests @ end()": true,
+	}
+	for expression, expected := range cases {
+		result, err := DependsOnQueryRange(expression)
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", expression, err)
+		}
+		if result != expected {
+			t.Errorf("Expected %t for %q, but got %t", expected, expression, result)
+		}
+	}
+}
+
+func TestPreservesLabel(t *testing.T) {
+	cases := map[string]bool{
+		`up`:                               true,
+		`rate(http_requests_total[5m])`:    true,
+		`-(up)`:                            true,
+		`max_over_time(rate(up[5m])[1h:])`: true,
+		`sum(up)`:                          false,
+		`sum by (project_id, job) (up)`:    true,
+		`sum without (instance) (up)`:      true,
+		`sum without (project_id) (up)`:    false,
+		`topk(3, up)`:                      true,
+		`count_values("value", up)`:        false,
+		`count_values("code", up)`:         false,
+		`label_replace(up, "project_id", "x", "", "")`:         false,
+		`label_replace(up, "foo", "$1", "project_id", "(.*)")`: true,
+		`absent(up)`: false,
+		`histogram_quantile(0.9, rate(http_request_duration_seconds_bucket[5m]))`: true,
+		`scalar(up)`:                   false,
+		`vector(1)`:                    false,
+		`time()`:                       false,
+		`up * 2`:                       true,
+		`2 > bool up`:                  true,
+		`up / on(instance) node_load1`: false,
+		`up / on(instance, project_id) node_load1`:           true,
+		`up / ignoring(project_id) node_load1`:               false,
+		`up * on(instance) group_left(version) build_info`:   true,
+		`up * on(instance) group_left(project_id) vector(1)`: false,
+		`vector(1) * on() group_right up`:                    true,
+		`up and vector(1)`:                                   true,
+		`up or vector(1)`:                                    false,
+		`up or node_load1`:                                   true,
+	}
+	for expression, expected := range cases {
+		result, err := PreservesLabel(expression, "project_id")
+		if err != nil {
+			t.Errorf("%s: %s", expression, err.Error())
+		} else if result != expected {
+			t.Errorf("%s: expected %v, got %v", expression, expected, result)
+		}
+	}
+}
+
+func TestAddLabelConstraintsToExpression(t *testing.T) {
+	constraints := []LabelConstraint{{Key: "project_id", Values: []string{"p1", "p2"}}, {Key: "tenant_id", Values: []string{"p1"}}}
+	cases := map[string]string{
+		`up`: `(up{project_id=~"p1|p2"} or up{tenant_id="p1"})`,
+		`sum by (job) (rate(http_requests_total{code="200"}[5m] offset 1h))`: `sum by (job) ((rate(http_requests_total{code="200",project_id=~"p1|p2"}[5m] offset 1h) or rate(http_requests_total{code="200",tenant_id="p1"}[5m] offset 1h)))`,
+		`quantile_over_time(0.9, up[5m]) / on (job) group_left () down`:      `(quantile_over_time(0.9, up{project_id=~"p1|p2"}[5m]) or quantile_over_time(0.9, up{tenant_id="p1"}[5m])) / on (job) group_left () (down{project_id=~"p1|p2"} or down{tenant_id="p1"})`,
+		`absent_over_time(up{job="a"}[5m])`:                                  `(absent_over_time(up{job="a",project_id=~"p1|p2"}[5m]) and on () absent_over_time(up{job="a",tenant_id="p1"}[5m]))`,
+		`max_over_time(up[1h:5m])`:                                           `max_over_time((up{project_id=~"p1|p2"} or up{tenant_id="p1"})[1h:5m])`,
+		`rate((up[5m]))`:                                                     `(rate(up{project_id=~"p1|p2"}[5m]) or rate(up{tenant_id="p1"}[5m]))`,
+		`topk(scalar(limit), -up)`:                                           `topk(scalar((limit{project_id=~"p1|p2"} or limit{tenant_id="p1"})), -(up{project_id=~"p1|p2"} or up{tenant_id="p1"}))`,
+		`vector(1)`:                                                          `vector(1)`,
+	}
+	for expression, expected := range cases {
+		result, err := AddLabelConstraintsToExpression(expression, constraints)
+		if err != nil {
+			t.Errorf("Error modifying expression %s: %v", expression, err)
+			continue
+		}
+		if result != expected {
+			t.Errorf("Expected %s to be modified to %q, but got %q", expression, expected, result)
+		}
+		if _, err := promqlParser.ParseExpr(result); err != nil {
+			t.Errorf("Modified expression %q is invalid: %v", result, err)
+		}
+	}
+
+	if _, err := AddLabelConstraintsToExpression("up[5m]", constraints); err == nil {
+		t.Error("Expected error for a range selector on the top level, but got none")
+	}
+
+	// a single constraint gives the same result as AddLabelConstraintToExpression
+	result, err := AddLabelConstraintsToExpression("up[5m]", constraints[:1])
+	if err != nil || result != `up{project_id=~"p1|p2"}[5m]` {
+		t.Errorf("Unexpected result for a single constraint: %q, %v", result, err)
+	}
+}
+
+func TestAddLabelConstraintsToQueries(t *testing.T) {
+	constraints := []LabelConstraint{{Key: "project_id", Values: []string{"p1", "p2"}}, {Key: "tenant_id", Values: []string{"p1"}}}
+	cases := map[string][]string{
+		`up`:                      {`(up{project_id=~"p1|p2"} or up{tenant_id="p1"})`},
+		`up[5m]`:                  {`up{project_id=~"p1|p2"}[5m]`, `up{tenant_id="p1"}[5m]`},
+		`(up{job="a"}[5m] @ 100)`: {`(up{job="a",project_id=~"p1|p2"}[5m] @ 100.000)`, `(up{job="a",tenant_id="p1"}[5m] @ 100.000)`},
+	}
+	for expression, expected := range cases {
+		result, err := AddLabelConstraintsToQueries(expression, constraints)
+		if err != nil {
+			t.Errorf("Error modifying expression %s: %v", expression, err)
+			continue
+		}
+		if !slices.Equal(result, expected) {
+			t.Errorf("Expected %s to be modified to %q, but got %q", expression, expected, result)
+		}
+	}
+}
+
+func TestAddLabelConstraintsToSelector(t *testing.T) {
+	result, err := AddLabelConstraintsToSelector(`{check=~"$api"}`, []LabelConstraint{{Key: "project_id", Values: []string{"p1"}}, {Key: "tenant_id", Values: []string{"p1", "all"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`{check=~"$api",project_id="p1"}`, `{check=~"$api",tenant_id=~"p1|all"}`}
+	if !slices.Equal(result, expected) {
+		t.Errorf("Unexpected result: %v; should have been %v", result, expected)
+	}
+}
+
+func TestAddLabelConstraintsToExpression_largeScope(t *testing.T) {
+	values := make([]string, maxSetMatches+2)
+	for i := range values {
+		values[i] = fmt.Sprintf("p%d", i)
+	}
+	constraints := []LabelConstraint{{Key: "project_id", Values: values}}
+	shard1 := `project_id=~"` + strings.Join(values[:maxSetMatches], "|") + `"`
+	shard2 := `project_id=~"p256|p257"`
+
+	// the values are split up into matchers that Prometheus can look up in its index
+	result, err := AddLabelConstraintsToExpression("sum(rate(up[5m]))", constraints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "sum((rate(up{" + shard1 + "}[5m]) or rate(up{" + shard2 + "}[5m])))"
+	if result != expected {
+		t.Errorf("Unexpected result: %s", result)
+	}
+
+	selectors, err := AddLabelConstraintsToSelector("up", constraints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(selectors, []string{`{__name__="up",` + shard1 + "}", `{__name__="up",` + shard2 + "}"}) {
+		t.Errorf("Unexpected selectors: %v", selectors)
+	}
+
+	// a range selector on the top level cannot be split up
+	result, err = AddLabelConstraintsToExpression("up[5m]", constraints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != `up{project_id=~"`+strings.Join(values, "|")+`"}[5m]` {
+		t.Errorf("Unexpected result for range selector: %s", result)
+	}
+}
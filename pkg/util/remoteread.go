@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"errors"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// AddLabelConstraintToReadRequest restricts every query of a remote read request to series matching a certain label.
+// All other fields of the request are left unchanged.
+func AddLabelConstraintToReadRequest(request *prompb.ReadRequest, key string, values []string) error {
+	if len(request.Queries) == 0 {
+		return errors.New("remote read request does not contain any query")
+	}
+	matcher, err := makeLabelMatcher(key, values)
+	if err != nil {
+		return err
+	}
+	encoded, err := remote.ToLabelMatchers([]*labels.Matcher{matcher})
+	if err != nil {
+		return err
+	}
+
+	for _, query := range request.Queries {
+		if query == nil {
+			return errors.New("invalid remote read request: empty query")
+		}
+		query.Matchers = append(query.Matchers, encoded...)
+	}
+
+	return nil
+}
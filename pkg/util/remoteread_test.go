@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestAddLabelConstraintToReadRequest(t *testing.T) {
+	request := prompb.ReadRequest{
+		Queries: []*prompb.Query{
+			{StartTimestampMs: 1000, EndTimestampMs: 2000, Matchers: []*prompb.LabelMatcher{
+				{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"},
+			}},
+			{StartTimestampMs: 1000, EndTimestampMs: 2000, Matchers: []*prompb.LabelMatcher{
+				{Type: prompb.LabelMatcher_RE, Name: "__name__", Value: "node_.*"},
+				{Type: prompb.LabelMatcher_NEQ, Name: "job", Value: "test"},
+			}},
+		},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS},
+	}
+
+	if err := AddLabelConstraintToReadRequest(&request, "project_id", []string{"p1", "p2"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]*prompb.LabelMatcher{
+		{{Type: prompb.LabelMatcher_EQ, Name: "__name__", Value: "up"}, {Type: prompb.LabelMatcher_RE, Name: "project_id", Value: "p1|p2"}},
+		{{Type: prompb.LabelMatcher_RE, Name: "__name__", Value: "node_.*"}, {Type: prompb.LabelMatcher_NEQ, Name: "job", Value: "test"}, {Type: prompb.LabelMatcher_RE, Name: "project_id", Value: "p1|p2"}},
+	}
+	for i, query := range request.Queries {
+		if !reflect.DeepEqual(query.Matchers, expected[i]) {
+			t.Errorf("Expected matchers %v, but got %v", expected[i], query.Matchers)
+		}
+		if query.StartTimestampMs != 1000 || query.EndTimestampMs != 2000 {
+			t.Errorf("Expected time range of query %d to be preserved", i)
+		}
+	}
+	if !reflect.DeepEqual(request.AcceptedResponseTypes, []prompb.ReadRequest_ResponseType{prompb.ReadRequest_STREAMED_XOR_CHUNKS}) {
+		t.Errorf("Expected accepted_response_types to be preserved")
+	}
+}
+
+func TestAddLabelConstraintToReadRequest_noQueries(t *testing.T) {
+	if err := AddLabelConstraintToReadRequest(&prompb.ReadRequest{}, "project_id", []string{"p1"}); err == nil {
+		t.Errorf("expected error, got none")
+	}
+}